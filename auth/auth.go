@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"rpc/protocol"
+)
+
+// ErrUnrecognized 令牌格式不属于该认证器，Chain遇到该错误时尝试下一个认证器
+var ErrUnrecognized = errors.New("unrecognized token")
+
+// Principal 已认证的调用方
+type Principal struct {
+	Subject   string                 // 调用方标识，如用户名或服务名
+	Method    string                 // 认证方式：bearer、hmac、jwt
+	Roles     []string               // 调用方的角色（JWT的roles声明），用于访问控制
+	Claims    map[string]interface{} // 令牌携带的声明（JWT），其他方式为nil
+	ExpiresAt time.Time              // 凭证过期时间，零值表示不过期
+}
+
+// Expired 判断凭证在now时是否已过期
+func (p *Principal) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// Authenticator 验证客户端提供的令牌，返回对应的调用方；令牌无效时返回Unauthenticated错误
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// AuthenticatorFunc 将函数适配为Authenticator
+type AuthenticatorFunc func(ctx context.Context, token string) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// Chain 依次尝试多个认证器，返回第一个成功的结果；
+// 认证器对格式不属于自己的令牌返回ErrUnrecognized，都不认识时返回Unauthenticated错误
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, token string) (*Principal, error) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(ctx, token)
+			if errors.Is(err, ErrUnrecognized) {
+				continue
+			}
+			return p, err
+		}
+		return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token")
+	})
+}
+
+// StaticTokens 验证预先分配的Bearer令牌，tokens为令牌到调用方标识的映射
+func StaticTokens(tokens map[string]string) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, token string) (*Principal, error) {
+		// 逐个比较全部令牌，比较时间与令牌内容无关
+		var subject string
+		found := 0
+		for t, s := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				subject, found = s, 1
+			}
+		}
+		if found == 0 {
+			return nil, ErrUnrecognized
+		}
+		return &Principal{Subject: subject, Method: "bearer"}, nil
+	})
+}
+
+// principalKey 在context中保存Principal的键
+type principalKey struct{}
+
+// NewContext 返回携带调用方的context
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext 从服务方法收到的context中获取已认证的调用方，未认证时返回nil
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Credentials 客户端凭证，每次调用前获取要发送的令牌；令牌变化时客户端在连接上重新认证
+type Credentials interface {
+	Token() (string, error)
+}
+
+// CredentialsFunc 将函数适配为Credentials
+type CredentialsFunc func() (string, error)
+
+func (f CredentialsFunc) Token() (string, error) {
+	return f()
+}
+
+// Bearer 固定的令牌，如预先分配的Bearer令牌或已签发的JWT
+func Bearer(token string) Credentials {
+	return CredentialsFunc(func() (string, error) {
+		return token, nil
+	})
+}
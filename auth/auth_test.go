@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"rpc/protocol"
+)
+
+func TestStaticTokens(t *testing.T) {
+	a := StaticTokens(map[string]string{"secret": "alice"})
+
+	p, err := a.Authenticate(context.Background(), "secret")
+	if err != nil || p.Subject != "alice" || p.Method != "bearer" {
+		t.Fatalf("Authenticate = %+v, %v", p, err)
+	}
+	if _, err := a.Authenticate(context.Background(), "wrong"); !errors.Is(err, ErrUnrecognized) {
+		t.Errorf("unknown token: %v, want ErrUnrecognized", err)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	key := []byte("shared")
+	a := HMAC(key)
+
+	p, err := a.Authenticate(context.Background(), SignHMAC(key, "svc", time.Minute))
+	if err != nil || p.Subject != "svc" || p.Method != "hmac" || p.ExpiresAt.IsZero() {
+		t.Fatalf("Authenticate = %+v, %v", p, err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong key", SignHMAC([]byte("other"), "svc", time.Minute)},
+		{"expired", SignHMAC(key, "svc", -time.Minute)},
+	}
+	for _, tt := range tests {
+		if _, err := a.Authenticate(context.Background(), tt.token); protocol.CodeOf(err) != protocol.Unauthenticated {
+			t.Errorf("%s: %v, want Unauthenticated", tt.name, err)
+		}
+	}
+	if _, err := a.Authenticate(context.Background(), "no-dot"); !errors.Is(err, ErrUnrecognized) {
+		t.Errorf("non-HMAC token: %v, want ErrUnrecognized", err)
+	}
+}
+
+func TestHMACCredentialsRefresh(t *testing.T) {
+	creds := HMACCredentials([]byte("shared"), "svc", 2*time.Second)
+	first, _ := creds.Token()
+	if again, _ := creds.Token(); again != first {
+		t.Error("token changed before half of its lifetime passed")
+	}
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := []byte("jwt-secret")
+	j := &JWT{HMACKey: hmacKey, RSAKey: &rsaKey.PublicKey, Issuer: "issuer", Audience: "rpc"}
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "bob", "iss": "issuer", "aud": []string{"web", "rpc"}, "exp": now + 60}
+	for _, key := range []interface{}{hmacKey, rsaKey} {
+		token, err := SignJWT(valid, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := j.Authenticate(context.Background(), token)
+		if err != nil || p.Subject != "bob" || p.Method != "jwt" || p.Claims["iss"] != "issuer" {
+			t.Errorf("%T: Authenticate = %+v, %v", key, p, err)
+		}
+	}
+
+	claims := func(override map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{}
+		for k, v := range valid {
+			c[k] = v
+		}
+		for k, v := range override {
+			c[k] = v
+		}
+		return c
+	}
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		key    interface{}
+	}{
+		{"expired", claims(map[string]interface{}{"exp": now - 60}), hmacKey},
+		{"not yet valid", claims(map[string]interface{}{"nbf": now + 60}), hmacKey},
+		{"wrong issuer", claims(map[string]interface{}{"iss": "other"}), hmacKey},
+		{"wrong audience", claims(map[string]interface{}{"aud": "web"}), hmacKey},
+		{"wrong key", valid, []byte("other")},
+	}
+	for _, tt := range tests {
+		token, err := SignJWT(tt.claims, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := j.Authenticate(context.Background(), token); protocol.CodeOf(err) != protocol.Unauthenticated {
+			t.Errorf("%s: %v, want Unauthenticated", tt.name, err)
+		}
+	}
+
+	// 未配置密钥的算法一律拒绝，避免用公钥作为HMAC密钥伪造令牌
+	rsaOnly := &JWT{RSAKey: &rsaKey.PublicKey}
+	token, _ := SignJWT(valid, hmacKey)
+	if _, err := rsaOnly.Authenticate(context.Background(), token); protocol.CodeOf(err) != protocol.Unauthenticated {
+		t.Errorf("HS256 without HMAC key: %v, want Unauthenticated", err)
+	}
+}
+
+func TestParseRSAPublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil || !parsed.Equal(&key.PublicKey) {
+		t.Fatalf("ParseRSAPublicKey = %v, %v", parsed, err)
+	}
+	if _, err := ParseRSAPublicKey([]byte("not pem")); err == nil {
+		t.Error("ParseRSAPublicKey accepted non-PEM data")
+	}
+}
+
+func TestChain(t *testing.T) {
+	key := []byte("shared")
+	a := Chain(StaticTokens(map[string]string{"secret": "alice"}), HMAC(key))
+
+	if p, err := a.Authenticate(context.Background(), "secret"); err != nil || p.Subject != "alice" {
+		t.Errorf("static token: %+v, %v", p, err)
+	}
+	if p, err := a.Authenticate(context.Background(), SignHMAC(key, "svc", time.Minute)); err != nil || p.Subject != "svc" {
+		t.Errorf("HMAC token: %+v, %v", p, err)
+	}
+	if _, err := a.Authenticate(context.Background(), "unknown"); protocol.CodeOf(err) != protocol.Unauthenticated {
+		t.Errorf("unknown token: %v, want Unauthenticated", err)
+	}
+}
+
+func TestJWTRoles(t *testing.T) {
+	key := []byte("jwt-secret")
+	j := &JWT{HMACKey: key}
+	for _, roles := range []interface{}{[]string{"admin", "ops"}, "admin ops"} {
+		token, _ := SignJWT(map[string]interface{}{"sub": "bob", "roles": roles}, key)
+		p, err := j.Authenticate(context.Background(), token)
+		if err != nil || len(p.Roles) != 2 || p.Roles[0] != "admin" || p.Roles[1] != "ops" {
+			t.Errorf("roles %v: %+v, %v", roles, p, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"rpc/protocol"
+)
+
+// JWT签名算法
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+// jwtHeader JWT头部
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWT 使用本地密钥验证HS256或RS256签名的JWT，检查exp、nbf以及配置的iss和aud
+// 令牌的alg必须与配置的密钥对应，未配置密钥的算法（包括none）一律拒绝
+type JWT struct {
+	HMACKey  []byte         // HS256的共享密钥，nil表示不接受HS256
+	RSAKey   *rsa.PublicKey // RS256的公钥，nil表示不接受RS256
+	Issuer   string         // 要求的签发者（iss），空表示不检查
+	Audience string         // 要求的受众（aud），空表示不检查
+	Leeway   time.Duration  // 检查exp和nbf时允许的时钟偏差
+}
+
+// Authenticate 验证JWT并返回其中的调用方（sub）和全部声明
+func (j *JWT) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnrecognized
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrUnrecognized
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token signature")
+	}
+
+	signed := parts[0] + "." + parts[1]
+	switch {
+	case header.Alg == HS256 && j.HMACKey != nil:
+		if !hmac.Equal(sig, hmacSum(j.HMACKey, signed)) {
+			return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token signature")
+		}
+	case header.Alg == RS256 && j.RSAKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(j.RSAKey, crypto.SHA256, digest[:], sig) != nil {
+			return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token signature")
+		}
+	default:
+		return nil, protocol.Errorf(protocol.Unauthenticated, "unsupported token algorithm: %q", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token claims")
+	}
+
+	now := time.Now()
+	p := &Principal{Method: "jwt", Claims: claims}
+	p.Subject, _ = claims["sub"].(string)
+	p.Roles = stringsClaim(claims, "roles")
+	if exp, ok := numericClaim(claims, "exp"); ok {
+		p.ExpiresAt = exp.Add(j.Leeway)
+		if p.Expired(now) {
+			return nil, protocol.Errorf(protocol.Unauthenticated, "token expired")
+		}
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(j.Leeway).Before(nbf) {
+		return nil, protocol.Errorf(protocol.Unauthenticated, "token not valid yet")
+	}
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token issuer")
+	}
+	if j.Audience != "" && !hasAudience(claims["aud"], j.Audience) {
+		return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token audience")
+	}
+	return p, nil
+}
+
+// decodeSegment 解码JWT中base64url编码的JSON段
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// numericClaim 读取以Unix秒表示的时间声明
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// stringsClaim 读取字符串数组声明，也接受以空格分隔的字符串
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var s []string
+		for _, a := range v {
+			if str, ok := a.(string); ok {
+				s = append(s, str)
+			}
+		}
+		return s
+	}
+	return nil
+}
+
+// hasAudience aud可以是字符串或字符串数组
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SignJWT 签发JWT：key为[]byte时使用HS256，为*rsa.PrivateKey时使用RS256
+func SignJWT(claims map[string]interface{}, key interface{}) (string, error) {
+	var alg string
+	switch key.(type) {
+	case []byte:
+		alg = HS256
+	case *rsa.PrivateKey:
+		alg = RS256
+	default:
+		return "", fmt.Errorf("unsupported signing key %T", key)
+	}
+
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Typ: "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		sig = hmacSum(k, signed)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ParseRSAPublicKey 解析PEM格式的RSA公钥（PKIX或PKCS#1），也接受证书
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA public key: %T", key)
+	}
+	return rsaKey, nil
+}
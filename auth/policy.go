@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"rpc/protocol"
+)
+
+// Authorizer 判断调用方是否可以调用 "Service.Method"，允许时返回nil，拒绝时返回PermissionDenied错误
+// p为nil表示调用方未认证（服务端没有配置认证器）
+type Authorizer interface {
+	Authorize(ctx context.Context, p *Principal, serviceMethod string) error
+}
+
+// Rule 一条访问控制规则：调用方标识或角色匹配时，Deny中的方法被拒绝，Allow中的方法被允许
+// 方法模式为 "Service.Method"，支持 * 和 ? 通配符（语法同path.Match），如 "ArithService.*"、"*.Get*"、"*"
+type Rule struct {
+	Principals []string `yaml:"principals"` // 调用方标识（Principal.Subject），"*" 匹配任何调用方，包括未认证的
+	Roles      []string `yaml:"roles"`      // 调用方角色，来自Principal.Roles和策略中的roles绑定
+	Allow      []string `yaml:"allow"`      // 允许调用的方法模式
+	Deny       []string `yaml:"deny"`       // 拒绝调用的方法模式，优先于所有规则的Allow
+}
+
+// policyConfig 策略文件的内容（YAML或JSON）
+type policyConfig struct {
+	Default string              `yaml:"default"` // 没有规则允许时的处理：deny（默认）或allow
+	Roles   map[string][]string `yaml:"roles"`   // 调用方标识 -> 角色
+	Rules   []Rule              `yaml:"rules"`
+}
+
+// Policy 基于规则的访问控制策略，可以从YAML或JSON文件加载并在运行中重新加载
+//
+//	default: deny
+//	roles:
+//	  alice: [admin]
+//	rules:
+//	  - roles: [admin]
+//	    allow: ["*"]
+//	  - principals: ["*"]
+//	    allow: ["ArithService.*", "EchoService.Echo"]
+//	    deny: ["ArithService.Div"]
+type Policy struct {
+	path    string
+	config  atomic.Pointer[policyConfig]
+	modTime atomic.Int64 // 最近一次加载时文件的修改时间（UnixNano）
+}
+
+// ParsePolicy 解析YAML或JSON格式的策略
+func ParsePolicy(data []byte) (*Policy, error) {
+	config, err := parsePolicyConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	p.config.Store(config)
+	return p, nil
+}
+
+// LoadPolicy 从YAML或JSON文件加载策略，之后可以通过Reload或Watch重新加载
+func LoadPolicy(file string) (*Policy, error) {
+	p := &Policy{path: file}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload 重新读取策略文件，文件无效时保留原有策略并返回错误
+func (p *Policy) Reload() error {
+	if p.path == "" {
+		return fmt.Errorf("policy not loaded from a file")
+	}
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	config, err := parsePolicyConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.path, err)
+	}
+	p.config.Store(config)
+	p.modTime.Store(info.ModTime().UnixNano())
+	return nil
+}
+
+// Watch 每隔interval检查策略文件，修改后重新加载；返回的函数停止检查
+func (p *Policy) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(p.path)
+				if err != nil || info.ModTime().UnixNano() == p.modTime.Load() {
+					continue
+				}
+				if err := p.Reload(); err != nil {
+					// 记下修改时间，文件再次修改前不重复报错
+					p.modTime.Store(info.ModTime().UnixNano())
+					log.Printf("Reload policy error: %v\n", err)
+					continue
+				}
+				log.Printf("Policy %s reloaded\n", p.path)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// parsePolicyConfig 解析并检查策略内容（JSON是YAML的子集，统一按YAML解析）
+func parsePolicyConfig(data []byte) (*policyConfig, error) {
+	var config policyConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	switch config.Default {
+	case "":
+		config.Default = "deny"
+	case "allow", "deny":
+	default:
+		return nil, fmt.Errorf("invalid default %q, want allow or deny", config.Default)
+	}
+	for i, rule := range config.Rules {
+		for _, pattern := range append(slices.Clone(rule.Allow), rule.Deny...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// Authorize 按策略判断调用是否允许：匹配规则中的Deny优先，其次是Allow，都不匹配时按default处理
+func (p *Policy) Authorize(ctx context.Context, principal *Principal, serviceMethod string) error {
+	config := p.config.Load()
+
+	var subject string
+	var roles []string
+	if principal != nil {
+		subject = principal.Subject
+		roles = append(slices.Clone(principal.Roles), config.Roles[subject]...)
+	}
+
+	allowed := false
+	for _, rule := range config.Rules {
+		if !rule.matches(principal != nil, subject, roles) {
+			continue
+		}
+		if matchAny(rule.Deny, serviceMethod) {
+			return deny(subject, serviceMethod)
+		}
+		if matchAny(rule.Allow, serviceMethod) {
+			allowed = true
+		}
+	}
+	if allowed || config.Default == "allow" {
+		return nil
+	}
+	return deny(subject, serviceMethod)
+}
+
+// matches 判断规则是否适用于调用方
+func (r *Rule) matches(authenticated bool, subject string, roles []string) bool {
+	for _, s := range r.Principals {
+		if s == "*" || (authenticated && s == subject) {
+			return true
+		}
+	}
+	for _, role := range r.Roles {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny 判断方法是否匹配任一模式
+func matchAny(patterns []string, serviceMethod string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, serviceMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// deny 构造拒绝调用的错误
+func deny(subject, serviceMethod string) error {
+	if subject == "" {
+		subject = "anonymous"
+	}
+	return protocol.Errorf(protocol.PermissionDenied, "permission denied: %s may not call %s", subject, serviceMethod)
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rpc/protocol"
+)
+
+const testPolicy = `
+default: deny
+roles:
+  alice: [admin]
+rules:
+  - roles: [admin]
+    allow: ["*"]
+  - principals: ["*"]
+    allow: ["Arith.*", "Echo.Echo"]
+    deny: ["Arith.Div"]
+  - principals: [bob]
+    allow: ["Store.Get*"]
+`
+
+func TestPolicyAuthorize(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &Principal{Subject: "alice"}
+	bob := &Principal{Subject: "bob"}
+	carol := &Principal{Subject: "carol", Roles: []string{"admin"}}
+	tests := []struct {
+		principal *Principal
+		method    string
+		allowed   bool
+	}{
+		{nil, "Arith.Add", true},
+		{nil, "Echo.Echo", true},
+		{nil, "Echo.Shout", false},
+		{bob, "Store.GetItem", true},
+		{bob, "Store.Put", false},
+		{nil, "Store.GetItem", false},
+		{alice, "Store.Put", true},
+		{carol, "Store.Put", true},
+		// Deny优先于所有规则的Allow，包括管理员
+		{alice, "Arith.Div", false},
+		{bob, "Arith.Div", false},
+	}
+	for _, tt := range tests {
+		err := p.Authorize(context.Background(), tt.principal, tt.method)
+		if tt.allowed && err != nil {
+			t.Errorf("%+v calling %s: %v, want allowed", tt.principal, tt.method, err)
+		}
+		if !tt.allowed && protocol.CodeOf(err) != protocol.PermissionDenied {
+			t.Errorf("%+v calling %s: %v, want PermissionDenied", tt.principal, tt.method, err)
+		}
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid default": "default: maybe",
+		"invalid pattern": `rules: [{principals: ["*"], allow: ["["]}]`,
+		"invalid yaml":    "rules: [",
+	}
+	for name, data := range tests {
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("%s: ParsePolicy succeeded", name)
+		}
+	}
+
+	// JSON也可以作为策略
+	p, err := ParsePolicy([]byte(`{"default": "allow"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize(context.Background(), nil, "Any.Method"); err != nil {
+		t.Errorf("default allow: %v", err)
+	}
+}
+
+func TestPolicyReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	write := func(data string, modTime time.Time) {
+		if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, modTime, modTime)
+	}
+	write("default: deny", time.Now().Add(-time.Hour))
+
+	p, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize(context.Background(), nil, "Arith.Add"); err == nil {
+		t.Fatal("deny policy allowed the call")
+	}
+
+	stop := p.Watch(10 * time.Millisecond)
+	defer stop()
+
+	// 无效的文件不替换原有策略
+	write("default: maybe", time.Now().Add(-time.Minute))
+	time.Sleep(100 * time.Millisecond)
+	if err := p.Authorize(context.Background(), nil, "Arith.Add"); err == nil {
+		t.Error("invalid policy file replaced the loaded policy")
+	}
+
+	write("default: allow", time.Now())
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Authorize(context.Background(), nil, "Arith.Add") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("policy was not reloaded after the file changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
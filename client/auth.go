@@ -0,0 +1,45 @@
+package client
+
+import "rpc/protocol"
+
+// authenticate 当前连接尚未使用最新的令牌认证时发送认证请求，等待服务端应答后再发起调用
+// 服务端在读取循环中按顺序处理认证，之后发送的请求都以认证得到的身份调用
+func (client *Client) authenticate() error {
+	if client.credentials == nil {
+		return nil
+	}
+	token, err := client.credentials.Token()
+	if err != nil {
+		return protocol.Errorf(protocol.Unauthenticated, "get credentials error: %v", err)
+	}
+
+	client.authMu.Lock()
+	defer client.authMu.Unlock()
+
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
+	if conn == client.authConn && token == client.authToken {
+		return nil
+	}
+
+	frame := &protocol.Frame{
+		Header: &protocol.Header{
+			MagicNumber:   protocol.MagicNumber,
+			Version:       protocol.Version,
+			MessageType:   protocol.Auth,
+			SerializeType: byte(client.codecType),
+		},
+		Payload: []byte(token),
+	}
+	resp, err := client.roundTrip(frame, protocol.EncodeFrame(frame), client.deadline())
+	if err != nil {
+		return err
+	}
+	if err := client.decodeResponse(resp, nil); err != nil {
+		return err
+	}
+
+	client.authConn, client.authToken = conn, token
+	return nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"rpc/protocol"
+)
+
+// BatchCall 批量调用中的单个条目
+type BatchCall struct {
+	ServiceMethod string      // 格式: "Service.Method"
+	Args          interface{} // 参数
+	Reply         interface{} // 返回值
+	Error         error       // 该条目的调用错误
+}
+
+// Batch 批量调用，在一次往返中发送多个请求
+type Batch struct {
+	client   *Client
+	Parallel bool         // 是否允许服务端并行执行各条目
+	Calls    []*BatchCall // 已添加的条目
+}
+
+// NewBatch 创建批量调用
+func (client *Client) NewBatch() *Batch {
+	return &Batch{client: client}
+}
+
+// Add 添加一个调用条目
+func (b *Batch) Add(serviceMethod string, args interface{}, reply interface{}) *BatchCall {
+	call := &BatchCall{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+	}
+	b.Calls = append(b.Calls, call)
+	return call
+}
+
+// Do 发送批量请求并等待所有结果
+// 返回的错误表示整个批量失败；单个条目的错误记录在对应BatchCall.Error中
+func (b *Batch) Do() error {
+	client := b.client
+
+	// 编码各条目，编码失败的条目不发送
+	entries := make([][]byte, 0, len(b.Calls))
+	sent := make([]*BatchCall, 0, len(b.Calls))
+	for _, call := range b.Calls {
+		call.Error = nil
+		frame, err := client.encodeRequest(call.ServiceMethod, call.Args)
+		if err != nil {
+			call.Error = err
+			continue
+		}
+		entries = append(entries, protocol.EncodeFrame(frame))
+		sent = append(sent, call)
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var flags byte
+	if b.Parallel {
+		flags |= protocol.BatchParallel
+	}
+
+	// 批量调用只检查服务端的熔断器
+	done, err := client.breakers.allow("")
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.send(&protocol.Frame{
+		Header: &protocol.Header{
+			MagicNumber:   protocol.MagicNumber,
+			Version:       protocol.Version,
+			MessageType:   protocol.BatchRequest,
+			SerializeType: byte(client.codecType),
+		},
+		Metadata: client.metadata,
+		Payload:  protocol.EncodeBatch(flags, entries),
+	}, client.deadline())
+	done(err, nil)
+	if err != nil {
+		return err
+	}
+
+	// 整个批量被拒绝时服务端返回普通错误响应
+	if resp.Header.MessageType == protocol.Response {
+		return client.decodeResponse(resp, nil)
+	}
+
+	if resp.Header.MessageType != protocol.BatchResponse {
+		return errors.New("invalid message type in response")
+	}
+
+	_, results, err := protocol.DecodeBatch(resp.Payload)
+	if err != nil {
+		return fmt.Errorf("decode batch response error: %v", err)
+	}
+
+	if len(results) != len(sent) {
+		return fmt.Errorf("batch response count mismatch: sent %d, received %d", len(sent), len(results))
+	}
+
+	for i, call := range sent {
+		result, err := protocol.DecodeFrame(results[i])
+		if err != nil {
+			call.Error = err
+			continue
+		}
+		call.Error = client.decodeResponse(result, call.Reply)
+	}
+
+	return nil
+}
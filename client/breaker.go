@@ -0,0 +1,314 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"rpc/protocol"
+)
+
+// ErrCircuitOpen 熔断器处于打开状态，调用未发送直接失败
+var ErrCircuitOpen error = protocol.Errorf(protocol.Unavailable, "circuit breaker is open")
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 关闭：正常发送调用并统计失败
+	BreakerOpen                         // 打开：调用直接返回ErrCircuitOpen，冷却时间后进入半开
+	BreakerHalfOpen                     // 半开：放行少量试探调用，全部成功后关闭，任一失败重新打开
+)
+
+// String 返回状态名称
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+const (
+	DefaultBreakerFailures  = 5                // 未设置任何熔断条件时，连续失败多少次后熔断
+	DefaultBreakerWindow    = 10 * time.Second // 统计失败率的默认时间窗口
+	DefaultBreakerRequests  = 10               // 按失败率熔断时窗口内默认的最少调用数
+	DefaultBreakerCooldown  = 5 * time.Second  // 熔断后进入半开状态的默认冷却时间
+	DefaultBreakerHalfOpens = 1                // 半开状态下默认的试探调用数
+)
+
+// CircuitBreaker 客户端熔断配置，同时作用于整个服务端（连接、发送失败和超时）和每个方法（包括服务端返回的不可用和内部错误）
+// 连续失败或窗口内失败率达到阈值时打开熔断器，打开期间调用立即返回ErrCircuitOpen，不再等待连接失败
+type CircuitBreaker struct {
+	ConsecutiveFailures int           // 连续失败多少次后熔断，0表示不按连续失败熔断（与FailureRate都为0时使用DefaultBreakerFailures）
+	FailureRate         float64       // 窗口内失败的比例（0~1）达到该值后熔断，0表示不按失败率熔断
+	MinRequests         int           // 按失败率熔断时窗口内至少需要的调用数，0表示使用DefaultBreakerRequests
+	Window              time.Duration // 统计失败率的时间窗口，关闭状态下每个窗口重新计数，0表示使用DefaultBreakerWindow
+	Cooldown            time.Duration // 打开后多久进入半开状态，0表示使用DefaultBreakerCooldown
+	HalfOpenRequests    int           // 半开状态下放行的试探调用数，0表示使用DefaultBreakerHalfOpens
+
+	// IsFailure 判断调用错误是否计为失败，nil表示使用默认判断：连接、发送等本地错误、超时、Unavailable和Internal计为失败，参数错误、未找到、无权限等业务错误不计
+	IsFailure func(err error) bool
+	// OnStateChange 熔断器状态变化时调用，name为服务器地址（整个服务端的熔断器）或 "Service.Method"（方法的熔断器）
+	OnStateChange func(name string, from, to BreakerState)
+}
+
+// isFailure 默认的失败判断
+func isFailure(err error) bool {
+	var e *protocol.Error
+	if !errors.As(err, &e) {
+		return true
+	}
+	switch e.Code {
+	case protocol.Unavailable, protocol.DeadlineExceeded, protocol.Internal:
+		return true
+	}
+	return false
+}
+
+// breaker 单个熔断器
+type breaker struct {
+	name string
+	opt  *CircuitBreaker
+
+	mu          sync.Mutex
+	state       BreakerState
+	generation  uint64    // 每次状态变化时递增，忽略状态变化前发出的调用的结果
+	expiry      time.Time // 关闭状态下当前窗口的结束时间，打开状态下冷却的结束时间
+	requests    int       // 当前窗口（或半开状态下）已放行的调用数
+	results     int       // 当前窗口已记录结果的调用数
+	failures    int       // 当前窗口的失败数
+	consecutive int       // 连续失败数
+	successes   int       // 半开状态下成功的试探调用数
+}
+
+// allow 检查是否放行调用，返回调用开始时的代数
+func (b *breaker) allow(now time.Time) (uint64, error) {
+	b.mu.Lock()
+	changed, from := b.refresh(now)
+	gen, err := b.generation, error(nil)
+	switch {
+	case b.state == BreakerOpen:
+		err = ErrCircuitOpen
+	case b.state == BreakerHalfOpen && b.requests >= b.opt.HalfOpenRequests:
+		err = ErrCircuitOpen
+	default:
+		b.requests++
+	}
+	state := b.state
+	b.mu.Unlock()
+
+	if changed {
+		b.notify(from, state)
+	}
+	return gen, err
+}
+
+// record 记录调用结果，gen为allow返回的代数
+func (b *breaker) record(gen uint64, failed bool, now time.Time) {
+	b.mu.Lock()
+	changed, from := b.refresh(now)
+	if gen != b.generation {
+		state := b.state
+		b.mu.Unlock()
+		if changed {
+			b.notify(from, state)
+		}
+		return
+	}
+	if !changed {
+		from = b.state
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		b.results++
+		if failed {
+			b.failures++
+			b.consecutive++
+			if b.tripped() {
+				b.setState(BreakerOpen, now)
+			}
+		} else {
+			b.consecutive = 0
+		}
+	case BreakerHalfOpen:
+		if failed {
+			b.setState(BreakerOpen, now)
+		} else if b.successes++; b.successes >= b.opt.HalfOpenRequests {
+			b.setState(BreakerClosed, now)
+		}
+	}
+	state := b.state
+	b.mu.Unlock()
+
+	if from != state {
+		b.notify(from, state)
+	}
+}
+
+// release 撤销allow放行的调用，用于调用最终未发送时
+func (b *breaker) release(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen == b.generation && b.requests > 0 {
+		b.requests--
+	}
+}
+
+// tripped 判断关闭状态下的失败是否达到熔断条件
+func (b *breaker) tripped() bool {
+	opt := b.opt
+	if opt.ConsecutiveFailures > 0 && b.consecutive >= opt.ConsecutiveFailures {
+		return true
+	}
+	return opt.FailureRate > 0 && b.results >= opt.MinRequests &&
+		float64(b.failures) >= opt.FailureRate*float64(b.results)
+}
+
+// refresh 处理随时间发生的变化：关闭状态的窗口到期后重新计数，打开状态的冷却结束后进入半开，调用方需持有mu
+// 返回状态是否变化及变化前的状态
+func (b *breaker) refresh(now time.Time) (bool, BreakerState) {
+	from := b.state
+	switch {
+	case b.state == BreakerClosed && now.After(b.expiry):
+		b.requests, b.results, b.failures = 0, 0, 0
+		b.expiry = now.Add(b.opt.Window)
+	case b.state == BreakerOpen && now.After(b.expiry):
+		b.setState(BreakerHalfOpen, now)
+	}
+	return b.state != from, from
+}
+
+// setState 切换状态并重新计数，调用方需持有mu
+func (b *breaker) setState(state BreakerState, now time.Time) {
+	b.state = state
+	b.generation++
+	b.requests, b.results, b.failures, b.successes = 0, 0, 0, 0
+	switch state {
+	case BreakerClosed:
+		b.expiry = now.Add(b.opt.Window)
+	case BreakerOpen:
+		b.consecutive = 0
+		b.expiry = now.Add(b.opt.Cooldown)
+	}
+}
+
+// notify 通知状态变化，在锁外调用回调
+func (b *breaker) notify(from, to BreakerState) {
+	if b.opt.OnStateChange != nil {
+		b.opt.OnStateChange(b.name, from, to)
+	}
+}
+
+// breakers 客户端的熔断器：整个服务端一个，每个方法一个
+type breakers struct {
+	opt      CircuitBreaker
+	endpoint *breaker
+
+	mu      sync.Mutex
+	methods map[string]*breaker // 方法 -> 熔断器
+}
+
+// newBreakers 根据配置创建熔断器，未配置时返回nil
+func newBreakers(addr string, opt *CircuitBreaker) *breakers {
+	if opt == nil {
+		return nil
+	}
+	bs := &breakers{opt: *opt, methods: make(map[string]*breaker)}
+	o := &bs.opt
+	if o.ConsecutiveFailures <= 0 && o.FailureRate <= 0 {
+		o.ConsecutiveFailures = DefaultBreakerFailures
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = DefaultBreakerRequests
+	}
+	if o.Window <= 0 {
+		o.Window = DefaultBreakerWindow
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = DefaultBreakerCooldown
+	}
+	if o.HalfOpenRequests <= 0 {
+		o.HalfOpenRequests = DefaultBreakerHalfOpens
+	}
+	if o.IsFailure == nil {
+		o.IsFailure = isFailure
+	}
+	bs.endpoint = bs.newBreaker(addr)
+	return bs
+}
+
+// newBreaker 创建处于关闭状态的熔断器
+func (bs *breakers) newBreaker(name string) *breaker {
+	return &breaker{name: name, opt: &bs.opt, expiry: time.Now().Add(bs.opt.Window)}
+}
+
+// method 返回方法的熔断器
+func (bs *breakers) method(serviceMethod string) *breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.methods[serviceMethod]
+	if !ok {
+		b = bs.newBreaker(serviceMethod)
+		bs.methods[serviceMethod] = b
+	}
+	return b
+}
+
+// allow 依次检查服务端和方法的熔断器，serviceMethod为空时只检查服务端的（如批量调用）
+// 放行时返回调用结束后记录结果的函数：sendErr为连接、发送或等待响应的错误，callErr为调用最终的错误
+func (bs *breakers) allow(serviceMethod string) (func(sendErr, callErr error), error) {
+	if bs == nil {
+		return func(error, error) {}, nil
+	}
+	now := time.Now()
+	endpointGen, err := bs.endpoint.allow(now)
+	if err != nil {
+		return nil, err
+	}
+	var method *breaker
+	var methodGen uint64
+	if serviceMethod != "" {
+		method = bs.method(serviceMethod)
+		if methodGen, err = method.allow(now); err != nil {
+			// 调用未发送，不计入服务端熔断器的结果
+			bs.endpoint.release(endpointGen)
+			return nil, err
+		}
+	}
+
+	return func(sendErr, callErr error) {
+		now := time.Now()
+		bs.endpoint.record(endpointGen, sendErr != nil && bs.opt.IsFailure(sendErr), now)
+		if method != nil {
+			method.record(methodGen, callErr != nil && bs.opt.IsFailure(callErr), now)
+		}
+	}, nil
+}
+
+// currentState 返回熔断器的当前状态
+func (b *breaker) currentState() BreakerState {
+	b.mu.Lock()
+	changed, from := b.refresh(time.Now())
+	state := b.state
+	b.mu.Unlock()
+	if changed {
+		b.notify(from, state)
+	}
+	return state
+}
+
+// BreakerState 返回熔断器的当前状态，serviceMethod为空时返回整个服务端的熔断器状态，未配置熔断时总是BreakerClosed
+func (client *Client) BreakerState(serviceMethod string) BreakerState {
+	bs := client.breakers
+	if bs == nil {
+		return BreakerClosed
+	}
+	if serviceMethod == "" {
+		return bs.endpoint.currentState()
+	}
+	return bs.method(serviceMethod).currentState()
+}
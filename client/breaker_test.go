@@ -0,0 +1,246 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"rpc/protocol"
+)
+
+// transitions 记录熔断器的状态变化
+type transitions struct {
+	mu      sync.Mutex
+	changes []string
+}
+
+func (tr *transitions) record(name string, from, to BreakerState) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.changes = append(tr.changes, name+": "+from.String()+" -> "+to.String())
+}
+
+func (tr *transitions) list() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return append([]string(nil), tr.changes...)
+}
+
+// call 放行并立即记录一次调用的结果
+func call(t *testing.T, b *breaker, failed bool, now time.Time) {
+	t.Helper()
+	gen, err := b.allow(now)
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	b.record(gen, failed, now)
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	var tr transitions
+	bs := newBreakers("server", &CircuitBreaker{ConsecutiveFailures: 2, Cooldown: time.Second, OnStateChange: tr.record})
+	b := bs.endpoint
+	now := time.Now()
+
+	call(t, b, true, now)
+	call(t, b, false, now) // 成功清零连续失败数
+	call(t, b, true, now)
+	if b.state != BreakerClosed {
+		t.Fatalf("state = %v after non-consecutive failures, want closed", b.state)
+	}
+	call(t, b, true, now)
+	if b.state != BreakerOpen {
+		t.Fatalf("state = %v after 2 consecutive failures, want open", b.state)
+	}
+	if _, err := b.allow(now.Add(time.Second / 2)); err != ErrCircuitOpen {
+		t.Fatalf("allow during cooldown: %v, want ErrCircuitOpen", err)
+	}
+
+	// 冷却后半开，只放行一次试探调用
+	now = now.Add(2 * time.Second)
+	gen, err := b.allow(now)
+	if err != nil {
+		t.Fatalf("allow after cooldown: %v", err)
+	}
+	if _, err := b.allow(now); err != ErrCircuitOpen {
+		t.Fatalf("second half-open allow: %v, want ErrCircuitOpen", err)
+	}
+	b.record(gen, true, now)
+	if b.state != BreakerOpen {
+		t.Fatalf("state = %v after a failed probe, want open", b.state)
+	}
+
+	now = now.Add(2 * time.Second)
+	call(t, b, false, now)
+	if b.state != BreakerClosed {
+		t.Fatalf("state = %v after a successful probe, want closed", b.state)
+	}
+
+	want := []string{
+		"server: closed -> open",
+		"server: open -> half-open",
+		"server: half-open -> open",
+		"server: open -> half-open",
+		"server: half-open -> closed",
+	}
+	if got := tr.list(); len(got) != len(want) {
+		t.Fatalf("transitions = %v, want %v", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("transition %d = %q, want %q", i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	bs := newBreakers("server", &CircuitBreaker{FailureRate: 0.5, MinRequests: 4, Window: time.Second})
+	b := bs.endpoint
+	now := time.Now()
+
+	// 调用数不足MinRequests时不熔断
+	call(t, b, true, now)
+	call(t, b, true, now)
+	call(t, b, false, now)
+	if b.state != BreakerClosed {
+		t.Fatalf("state = %v below MinRequests, want closed", b.state)
+	}
+
+	// 窗口到期后重新计数
+	now = now.Add(2 * time.Second)
+	for _, failed := range []bool{false, false, false, true} {
+		call(t, b, failed, now)
+	}
+	if b.state != BreakerClosed {
+		t.Fatalf("state = %v at 25%% failures, want closed", b.state)
+	}
+	call(t, b, true, now)
+	call(t, b, true, now)
+	if b.state != BreakerOpen {
+		t.Fatalf("state = %v at 50%% failures, want open", b.state)
+	}
+}
+
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	bs := newBreakers("server", &CircuitBreaker{ConsecutiveFailures: 1, Cooldown: time.Second})
+	b := bs.endpoint
+	now := time.Now()
+
+	stale, err := b.allow(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call(t, b, true, now)
+	now = now.Add(2 * time.Second)
+
+	// 熔断前发出的调用成功返回，不能关闭半开的熔断器
+	gen, err := b.allow(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.record(stale, false, now)
+	if b.state != BreakerHalfOpen {
+		t.Fatalf("state = %v after a stale result, want half-open", b.state)
+	}
+	b.record(gen, false, now)
+	if b.state != BreakerClosed {
+		t.Fatalf("state = %v after the probe succeeded, want closed", b.state)
+	}
+}
+
+func TestBreakersPerMethod(t *testing.T) {
+	bs := newBreakers("server", &CircuitBreaker{ConsecutiveFailures: 1})
+
+	// 服务端返回的业务错误不计为失败
+	done, err := bs.allow("Arith.Div")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(nil, protocol.Errorf(protocol.InvalidArgument, "divide by zero"))
+	if bs.method("Arith.Div").state != BreakerClosed {
+		t.Fatal("business error tripped the method breaker")
+	}
+
+	// 服务端不可用只打开该方法的熔断器
+	done, err = bs.allow("Arith.Div")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(nil, protocol.Errorf(protocol.Unavailable, "overloaded"))
+	if _, err := bs.allow("Arith.Div"); err != ErrCircuitOpen {
+		t.Fatalf("allow on open method: %v, want ErrCircuitOpen", err)
+	}
+	if done, err := bs.allow("Arith.Add"); err != nil {
+		t.Fatalf("other method rejected: %v", err)
+	} else {
+		done(nil, nil)
+	}
+
+	// 发送失败打开服务端的熔断器，所有方法和批量调用都被拒绝
+	done, err = bs.allow("Arith.Add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendErr := errors.New("connection refused")
+	done(sendErr, sendErr)
+	if _, err := bs.allow(""); err != ErrCircuitOpen {
+		t.Fatalf("batch with open endpoint: %v, want ErrCircuitOpen", err)
+	}
+
+	var none *breakers
+	if done, err := none.allow("Arith.Add"); err != nil {
+		t.Fatal(err)
+	} else {
+		done(sendErr, sendErr)
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("dial tcp: connection refused"), true},
+		{protocol.Errorf(protocol.Unavailable, "overloaded"), true},
+		{protocol.Errorf(protocol.DeadlineExceeded, "timeout"), true},
+		{protocol.Errorf(protocol.Internal, "panic"), true},
+		{protocol.Errorf(protocol.InvalidArgument, "bad"), false},
+		{protocol.Errorf(protocol.NotFound, "missing"), false},
+		{protocol.Errorf(protocol.PermissionDenied, "denied"), false},
+	}
+	for _, tt := range tests {
+		if got := isFailure(tt.err); got != tt.want {
+			t.Errorf("isFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCircuitBreakerOpensOnDialFailures(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	opt := *DefaultOption
+	opt.CircuitBreaker = &CircuitBreaker{ConsecutiveFailures: 2, Cooldown: time.Minute}
+	c := NewClient(addr, &opt)
+	defer c.Close()
+
+	var reply int
+	for i := 0; i < 2; i++ {
+		if err := c.Call("Arith.Add", nil, &reply); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("call %d: %v, want a dial error", i, err)
+		}
+	}
+	if err := c.Call("Arith.Add", nil, &reply); err != ErrCircuitOpen {
+		t.Fatalf("call with open breaker: %v, want ErrCircuitOpen", err)
+	}
+	if state := c.BreakerState(""); state != BreakerOpen {
+		t.Errorf("BreakerState = %v, want open", state)
+	}
+}
@@ -1,26 +1,64 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"rpc/auth"
 	"rpc/codec"
 	"rpc/protocol"
+	"rpc/server"
 	"rpc/transport"
 )
 
+// ErrTimeout 调用超时
+var ErrTimeout error = protocol.Errorf(protocol.DeadlineExceeded, "call timeout")
+
+// ErrShutdown 连接已关闭
+var ErrShutdown error = protocol.Errorf(protocol.Unavailable, "connection is shut down")
+
 // Client RPC客户端
 type Client struct {
-	transport   transport.Transport // 传输层
-	conn        transport.Conn      // 当前连接
-	serverAddr  string              // 服务器地址
-	codecType   codec.Type          // 编解码类型
-	serializer  codec.Codec         // 序列化工具
-	mu          sync.Mutex          // 保护连接
-	isConnected bool                // 是否已连接
+	transport   transport.Transport             // 传输层
+	conn        transport.Conn                  // 当前连接
+	serverAddr  string                          // 服务器地址
+	codecType   codec.Type                      // 编解码类型
+	serializer  codec.Codec                     // 序列化工具
+	timeout     time.Duration                   // 调用超时时间，0表示不超时
+	keepalive   time.Duration                   // 心跳间隔，0表示不发送心跳
+	keepTimeout time.Duration                   // 心跳应答超时
+	maxRequest  int                             // 单个请求帧的最大字节数
+	rateRetries int                             // 被服务端限流时等待后重试的次数
+	lastRead    atomic.Int64                    // 最近一次收到数据的时间（UnixNano）
+	handler     *server.Server                  // 本地注册的服务，供服务端回调
+	sending     sync.Mutex                      // 保证帧写入的完整性
+	mu          sync.Mutex                      // 保护连接和以下字段
+	seq         uint64                          // 最近使用的请求序号
+	pending     map[uint64]chan *protocol.Frame // 等待响应的调用
+	isConnected bool                            // 是否已连接
+	connSeq     uint64                          // 当前连接建立时的请求序号，之后的调用都在该连接上
+	draining    map[transport.Conn]seqRange     // 收到GoAway、等待已发出的调用完成的旧连接
+
+	subMu         sync.RWMutex             // 保护subscriptions
+	subscriptions map[string]*subscription // 已订阅的主题
+
+	credentials auth.Credentials // 认证令牌，nil表示不认证
+	authMu      sync.Mutex       // 保证同一时间只有一个认证请求，保护以下字段
+	authConn    transport.Conn   // 最近一次认证成功的连接
+	authToken   string           // 在authConn上认证成功的令牌
+
+	signer   *protocol.Signer  // 帧签名，nil表示不签名
+	metadata map[string]string // 随每个请求发送的元数据
+	breakers *breakers         // 熔断器，nil表示不熔断
+
+	closed    chan struct{} // Close时关闭，唤醒等待重试的调用
+	closeOnce sync.Once
 }
 
 // Option 配置选项
@@ -28,6 +66,28 @@ type Option struct {
 	TransportType transport.TransportType // 传输类型
 	CodecType     codec.Type              // 编解码类型
 	Timeout       time.Duration           // 超时时间
+
+	KeepaliveInterval time.Duration // 连接空闲多久后发送心跳，0表示不发送
+	KeepaliveTimeout  time.Duration // 发送心跳后等待服务端任意数据的时间，超时则重连
+
+	MaxRequestSize  int // 单个请求帧的最大字节数，超过时调用直接返回错误，0表示使用transport.DefaultMaxFrameSize
+	MaxResponseSize int // 单个响应帧的最大字节数，超过时对应调用返回错误，0表示使用transport.DefaultMaxFrameSize
+
+	TLS      *transport.TLSOption // TCP和HTTP传输的TLS配置，nil表示不加密
+	HTTPPath string               // HTTP、WebSocket和HTTP隧道传输的路径，空表示使用transport.DefaultHTTPPath
+	Proxy    string               // HTTP隧道传输经过的HTTP代理地址，空表示直连
+
+	Credentials auth.Credentials // 认证令牌：HTTP和HTTP/2传输作为每个请求的Bearer令牌，其他传输在连接建立后（及令牌变化时）发送认证请求，nil表示不认证
+	Signer      *protocol.Signer // 帧签名：发出的每一帧都签名，收到的帧签名无效或被重放时丢弃，需与服务端共享密钥，nil表示不签名
+
+	RateLimitRetries int // 调用被服务端限流时，按错误中建议的等待时间（protocol.RetryAfter）等待后重试的次数，0表示不重试
+
+	Metadata map[string]string // 随每个请求发送的元数据，如 {protocol.MetadataPriority: "sheddable"} 指定请求的优先级（服务端只接受降低优先级，critical需服务端信任调用方）
+
+	CircuitBreaker *CircuitBreaker // 按服务端和按方法熔断，打开时调用立即返回ErrCircuitOpen，nil表示不熔断
+
+	Latency   time.Duration // 进程内传输：模拟的单向延迟
+	Bandwidth int           // 进程内传输：模拟的每个方向每秒字节数，0表示不限制
 }
 
 // DefaultOption 默认配置
@@ -35,6 +95,12 @@ var DefaultOption = &Option{
 	TransportType: transport.TCP,
 	CodecType:     codec.JSON,
 	Timeout:       time.Second * 10,
+
+	KeepaliveInterval: time.Second * 30,
+	KeepaliveTimeout:  time.Second * 10,
+
+	MaxRequestSize:  transport.DefaultMaxFrameSize,
+	MaxResponseSize: transport.DefaultMaxFrameSize,
 }
 
 // NewClient 创建客户端实例
@@ -43,16 +109,90 @@ func NewClient(addr string, opt *Option) *Client {
 		opt = DefaultOption
 	}
 
+	// unix:// 和 mem:// 地址总是使用对应的传输
+	transportType := opt.TransportType
+	if t, ok := transport.AddrType(addr); ok {
+		transportType = t
+	}
+
+	// HTTP传输的每次调用是独立的请求，令牌放在请求头中，不需要认证请求
+	credentials := opt.Credentials
+	var token func() (string, error)
+	if credentials != nil && (transportType == transport.HTTP || transportType == transport.HTTP2) {
+		token, credentials = credentials.Token, nil
+	}
+
 	c := &Client{
 		serverAddr: addr,
 		codecType:  opt.CodecType,
-		transport:  transport.NewTransport(opt.TransportType),
-		serializer: codec.NewCodec(opt.CodecType),
+		transport: transport.NewTransportWithOption(transportType, &transport.Option{
+			MaxFrameSize:   opt.MaxResponseSize,
+			TLS:            opt.TLS,
+			HTTPPath:       opt.HTTPPath,
+			RequestTimeout: opt.Timeout,
+			Proxy:          opt.Proxy,
+			Token:          token,
+			Latency:        opt.Latency,
+			Bandwidth:      opt.Bandwidth,
+		}),
+		serializer:  codec.NewCodec(opt.CodecType),
+		timeout:     opt.Timeout,
+		keepalive:   opt.KeepaliveInterval,
+		keepTimeout: opt.KeepaliveTimeout,
+		maxRequest:  opt.MaxRequestSize,
+		rateRetries: opt.RateLimitRetries,
+		pending:     make(map[uint64]chan *protocol.Frame),
+
+		subscriptions: make(map[string]*subscription),
+		credentials:   credentials,
+		signer:        opt.Signer,
+		metadata:      opt.Metadata,
+		breakers:      newBreakers(addr, opt.CircuitBreaker),
+		closed:        make(chan struct{}),
 	}
 
+	if c.maxRequest <= 0 {
+		c.maxRequest = transport.DefaultMaxFrameSize
+	}
+	// UDP的一个数据报承载一帧，超过数据报上限的请求直接返回错误
+	if transportType == transport.UDP && c.maxRequest > transport.MaxDatagramFrameSize {
+		c.maxRequest = transport.MaxDatagramFrameSize
+	}
+
+	// 本地服务处理回调，回调的响应与请求受同样的大小限制
+	handlerOpt := *server.DefaultOption
+	handlerOpt.TransportType = transportType
+	handlerOpt.CodecType = opt.CodecType
+	handlerOpt.MaxResponseSize = c.maxRequest
+	c.handler = server.NewServerWithOption(&handlerOpt)
+
+	// 注册接收订阅消息的内置服务
+	c.handler.RegisterName(protocol.PubSubReceiver, &pubsubReceiver{client: c})
+
 	return c
 }
 
+// DialHTTP 经HTTP隧道连接到服务器（见transport.HTTPTunnelTransport），
+// 在只允许HTTP的网络中获得持久的多路复用连接；addr可以是 host:port 或 http(s)://host:port/path
+func DialHTTP(addr string, opt *Option) (*Client, error) {
+	if opt == nil {
+		opt = DefaultOption
+	}
+	tunnelOpt := *opt
+	tunnelOpt.TransportType = transport.HTTPTunnel
+
+	client := NewClient(addr, &tunnelOpt)
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Register 注册本地服务，服务端可以通过同一连接回调这些服务
+func (client *Client) Register(rcvr interface{}) error {
+	return client.handler.Register(rcvr)
+}
+
 // Connect 连接到服务器
 func (client *Client) Connect() error {
 	client.mu.Lock()
@@ -69,107 +209,398 @@ func (client *Client) Connect() error {
 
 	client.conn = conn
 	client.isConnected = true
+	client.connSeq = client.seq
+	client.lastRead.Store(time.Now().UnixNano())
+
+	// 启动接收循环，分发响应和服务端回调
+	done := make(chan struct{})
+	go client.receive(conn, done)
+	if client.keepalive > 0 {
+		go client.keepaliveLoop(conn, done)
+	}
 	return nil
 }
 
-// Close 关闭连接
+// Close 关闭连接，正在等待限流重试的调用返回ErrShutdown
 func (client *Client) Close() error {
+	client.closeOnce.Do(func() { close(client.closed) })
+
 	client.mu.Lock()
 	defer client.mu.Unlock()
 
+	for conn := range client.draining {
+		client.disconnect(conn)
+	}
 	if !client.isConnected || client.conn == nil {
 		return nil
 	}
 
-	err := client.conn.Close()
+	return client.disconnect(client.conn)
+}
+
+// disconnect 关闭指定连接并唤醒所有等待中的调用，调用方需持有mu
+func (client *Client) disconnect(conn transport.Conn) error {
+	// 收到GoAway的旧连接只唤醒在其上发出的调用
+	if r, ok := client.draining[conn]; ok {
+		delete(client.draining, conn)
+		for seq, ch := range client.pending {
+			if r.contains(seq) {
+				close(ch)
+				delete(client.pending, seq)
+			}
+		}
+		return conn.Close()
+	}
+
+	if client.conn != conn || !client.isConnected {
+		return nil
+	}
+
+	err := conn.Close()
 	client.isConnected = false
+	for seq, ch := range client.pending {
+		close(ch)
+		delete(client.pending, seq)
+	}
 	return err
 }
 
+// receive 持续读取连接上的帧：响应交给等待中的调用，请求交给本地服务处理
+// 连接出错退出时关闭done
+func (client *Client) receive(conn transport.Conn, done chan struct{}) {
+	defer close(done)
+
+	for {
+		data, err := conn.Read()
+		if err != nil {
+			// 单个请求发送失败（如HTTP请求出错），让对应的调用返回错误
+			var frameErr *transport.FrameError
+			if errors.As(err, &frameErr) {
+				client.rejectFrame(conn, frameErr.Head, &protocol.Error{Code: protocol.Unavailable, Message: frameErr.Error()})
+				continue
+			}
+			// 超过大小限制的帧已被传输层丢弃，让对应的调用返回错误
+			var tooLarge *transport.FrameTooLargeError
+			if errors.As(err, &tooLarge) {
+				client.lastRead.Store(time.Now().UnixNano())
+				client.rejectFrame(conn, tooLarge.Head, &protocol.Error{Code: protocol.ResourceExhausted, Message: tooLarge.Error()})
+				continue
+			}
+			break
+		}
+		client.lastRead.Store(time.Now().UnixNano())
+
+		if client.signer != nil {
+			if data, err = client.signer.Verify(data); err != nil {
+				log.Printf("Drop frame: %v\n", err)
+				continue
+			}
+		} else if protocol.IsSigned(data) {
+			// 服务端要求签名，让对应的调用返回错误
+			client.rejectFrame(conn, data, protocol.Errorf(protocol.Unauthenticated, "server requires signed frames"))
+			continue
+		}
+
+		frame, err := protocol.DecodeFrame(data)
+		if err != nil {
+			log.Printf("Decode frame error: %v\n", err)
+			continue
+		}
+
+		switch frame.Header.MessageType {
+		case protocol.Response, protocol.BatchResponse:
+			client.deliver(frame)
+		case protocol.Request, protocol.BatchRequest:
+			go client.serveRequest(conn, frame)
+		case protocol.Ping:
+			pong := protocol.EncodeFrame(&protocol.Frame{
+				Header: &protocol.Header{
+					MagicNumber:   protocol.MagicNumber,
+					Version:       protocol.Version,
+					MessageType:   protocol.Pong,
+					SerializeType: byte(client.codecType),
+					Seq:           frame.Header.Seq,
+				},
+			})
+			if err := client.write(conn, pong); err != nil {
+				log.Printf("Write error: %v\n", err)
+			}
+		case protocol.GoAway:
+			client.goAway(conn)
+		}
+	}
+
+	client.mu.Lock()
+	client.disconnect(conn)
+	client.mu.Unlock()
+}
+
+// deliver 将响应交给等待中的调用
+func (client *Client) deliver(frame *protocol.Frame) {
+	client.mu.Lock()
+	ch, ok := client.pending[frame.Header.Seq]
+	delete(client.pending, frame.Header.Seq)
+	client.closeDrained()
+	client.mu.Unlock()
+
+	if ok {
+		ch <- frame
+	}
+}
+
+// rejectFrame 处理超过大小限制的帧，head为帧的开头部分
+// 响应超限时让对应的调用返回错误，回调请求超限时向服务端返回错误响应
+func (client *Client) rejectFrame(conn transport.Conn, head []byte, reason error) {
+	header, err := protocol.DecodeHeader(head)
+	if err != nil {
+		log.Printf("Reject frame: %v\n", reason)
+		return
+	}
+
+	switch header.MessageType {
+	case protocol.Response, protocol.BatchResponse:
+		if frame, err := protocol.DecodeFrame(client.errorFrame(header, reason)); err == nil {
+			client.deliver(frame)
+		}
+	case protocol.Request, protocol.BatchRequest:
+		if err := client.write(conn, client.errorFrame(header, reason)); err != nil {
+			log.Printf("Write error: %v\n", err)
+		}
+	}
+}
+
+// errorFrame 构造与指定消息头对应的错误响应帧
+func (client *Client) errorFrame(reqHeader *protocol.Header, reason error) []byte {
+	payload, _ := client.serializer.Encode(&protocol.ResponseMessage{Error: reason.Error(), Code: protocol.CodeOf(reason)})
+	return protocol.EncodeFrame(&protocol.Frame{
+		Header: &protocol.Header{
+			MagicNumber:   protocol.MagicNumber,
+			Version:       protocol.Version,
+			MessageType:   protocol.Response,
+			SerializeType: reqHeader.SerializeType,
+			Seq:           reqHeader.Seq,
+		},
+		Payload: payload,
+	})
+}
+
+// serveRequest 处理服务端的回调请求并写回响应
+func (client *Client) serveRequest(conn transport.Conn, frame *protocol.Frame) {
+	if err := client.write(conn, client.handler.ServeRequest(context.Background(), frame)); err != nil {
+		log.Printf("Write error: %v\n", err)
+	}
+}
+
+// write 向连接写入一个完整的帧，配置了签名时先签名
+func (client *Client) write(conn transport.Conn, data []byte) error {
+	if client.signer != nil {
+		var err error
+		if data, err = client.signer.Sign(data); err != nil {
+			return err
+		}
+	}
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	return conn.Write(data)
+}
+
 // Call 远程调用方法
 func (client *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
-	// 确保连接已建立
-	if err := client.Connect(); err != nil {
+	return client.CallWithMetadata(serviceMethod, nil, args, reply)
+}
+
+// CallWithMetadata 携带元数据远程调用方法，md与Option.Metadata合并，同名的键以md为准
+// 服务方法通过server.MetadataFromContext获取元数据
+func (client *Client) CallWithMetadata(serviceMethod string, md map[string]string, args interface{}, reply interface{}) error {
+	frame, err := client.encodeRequest(serviceMethod, args)
+	if err != nil {
+		return err
+	}
+	frame.Metadata = client.mergeMetadata(md)
+
+	// 熔断器打开时不发送调用
+	done, err := client.breakers.allow(serviceMethod)
+	if err != nil {
 		return err
 	}
 
+	// 包括限流重试在内，整个调用不超过调用超时：每次尝试只等待剩余的时间
+	deadline := client.deadline()
+	for attempt := 0; ; attempt++ {
+		resp, err := client.send(frame, deadline)
+		if err != nil {
+			done(err, err)
+			return err
+		}
+		err = client.decodeResponse(resp, reply)
+
+		// 被限流时按服务端建议的时间等待后重试，等待结束时超过调用的截止时间则不再重试
+		retryAfter := protocol.RetryAfter(err)
+		if retryAfter <= 0 || attempt >= client.rateRetries || (!deadline.IsZero() && time.Now().Add(retryAfter).After(deadline)) {
+			done(nil, err)
+			return err
+		}
+		if !client.wait(retryAfter) {
+			done(nil, err)
+			return ErrShutdown
+		}
+	}
+}
+
+// deadline 从现在开始计算的调用截止时间，未设置调用超时时返回零值
+func (client *Client) deadline() time.Time {
+	if client.timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(client.timeout)
+}
+
+// wait 等待d，客户端关闭时提前返回false
+func (client *Client) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-client.closed:
+		return false
+	}
+}
+
+// mergeMetadata 合并Option.Metadata和单次调用的元数据
+func (client *Client) mergeMetadata(md map[string]string) map[string]string {
+	if len(md) == 0 {
+		return client.metadata
+	}
+	if len(client.metadata) == 0 {
+		return md
+	}
+	merged := make(map[string]string, len(client.metadata)+len(md))
+	for k, v := range client.metadata {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return merged
+}
+
+// encodeRequest 序列化参数并构造请求帧
+func (client *Client) encodeRequest(serviceMethod string, args interface{}) (*protocol.Frame, error) {
 	// 分割服务名和方法名
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		return errors.New("service/method request ill-formed: " + serviceMethod)
+		return nil, errors.New("service/method request ill-formed: " + serviceMethod)
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 
 	// 序列化参数
 	argBytes, err := client.serializer.Encode(args)
 	if err != nil {
-		return fmt.Errorf("encode arguments error: %v", err)
+		return nil, fmt.Errorf("encode arguments error: %v", err)
 	}
 
 	// 构造请求
-	header := &protocol.Header{
-		MagicNumber:   protocol.MagicNumber,
-		Version:       protocol.Version,
-		MessageType:   protocol.Request,
-		SerializeType: byte(client.codecType),
-		ServiceLength: uint16(len(serviceName)),
-		MethodLength:  uint16(len(methodName)),
-		PayloadLength: uint32(len(argBytes)),
-	}
+	return &protocol.Frame{
+		Header: &protocol.Header{
+			MagicNumber:   protocol.MagicNumber,
+			Version:       protocol.Version,
+			MessageType:   protocol.Request,
+			SerializeType: byte(client.codecType),
+		},
+		ServiceName: serviceName,
+		MethodName:  methodName,
+		Payload:     argBytes,
+	}, nil
+}
 
-	// 编码请求头
-	headerBytes := protocol.EncodeHeader(header)
+// send 为请求帧分配序号并发送，等待对应的响应帧
+func (client *Client) send(frame *protocol.Frame, deadline time.Time) (*protocol.Frame, error) {
+	// 请求超过大小限制时不发送
+	reqData := protocol.EncodeFrame(frame)
+	if len(reqData) > client.maxRequest {
+		return nil, protocol.Errorf(protocol.ResourceExhausted, "request too large: %d bytes exceeds limit of %d bytes", len(reqData), client.maxRequest)
+	}
 
-	// 合并请求数据
-	reqData := append(headerBytes, []byte(serviceName)...)
-	reqData = append(reqData, []byte(methodName)...)
-	reqData = append(reqData, argBytes...)
+	// 确保连接已建立并完成认证
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	if err := client.authenticate(); err != nil {
+		return nil, err
+	}
 
-	client.mu.Lock()
-	defer client.mu.Unlock()
+	return client.roundTrip(frame, reqData, deadline)
+}
 
-	// 发送请求
-	if err := client.conn.Write(reqData); err != nil {
-		client.isConnected = false
-		return fmt.Errorf("send request error: %v", err)
+// roundTrip 在当前连接上发送已编码的请求帧，等待对应的响应帧直到deadline，deadline为零值时不限时
+func (client *Client) roundTrip(frame *protocol.Frame, reqData []byte, deadline time.Time) (*protocol.Frame, error) {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return nil, ErrTimeout
 	}
 
-	// 接收响应
-	respData, err := client.conn.Read()
-	if err != nil {
-		client.isConnected = false
-		return fmt.Errorf("read response error: %v", err)
+	// 登记等待中的调用
+	ch := make(chan *protocol.Frame, 1)
+	client.mu.Lock()
+	if !client.isConnected {
+		client.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	conn := client.conn
+	client.seq++
+	seq := client.seq
+	client.pending[seq] = ch
+	client.mu.Unlock()
+
+	// 发送请求，序号写入已编码的请求头
+	frame.Header.Seq = seq
+	copy(reqData, protocol.EncodeHeader(frame.Header))
+	if err := client.write(conn, reqData); err != nil {
+		client.mu.Lock()
+		client.disconnect(conn)
+		client.mu.Unlock()
+		return nil, fmt.Errorf("send request error: %v", err)
 	}
 
-	// 解析响应头
-	if len(respData) < protocol.HeaderSize {
-		return errors.New("invalid response header")
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
-	respHeader, err := protocol.DecodeHeader(respData[:protocol.HeaderSize])
-	if err != nil {
-		return err
+	// 接收响应
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("read response error: %w", ErrShutdown)
+		}
+		return resp, nil
+	case <-timeout:
+		client.mu.Lock()
+		delete(client.pending, seq)
+		client.closeDrained()
+		client.mu.Unlock()
+		return nil, ErrTimeout
 	}
+}
 
+// decodeResponse 解析响应帧并将结果解码到reply中
+func (client *Client) decodeResponse(resp *protocol.Frame, reply interface{}) error {
 	// 检查响应类型
-	if respHeader.MessageType != protocol.Response {
+	if resp.Header.MessageType != protocol.Response {
 		return errors.New("invalid message type in response")
 	}
 
-	// 解析响应数据
-	if len(respData) < protocol.HeaderSize+int(respHeader.PayloadLength) {
-		return errors.New("invalid response data")
-	}
-
 	// 解码响应
 	var response protocol.ResponseMessage
-	if err := client.serializer.Decode(respData[protocol.HeaderSize:protocol.HeaderSize+respHeader.PayloadLength], &response); err != nil {
+	if err := client.serializer.Decode(resp.Payload, &response); err != nil {
 		return fmt.Errorf("decode response error: %v", err)
 	}
 
 	// 检查响应中是否有错误
-	if response.Error != "" {
-		return errors.New(response.Error)
+	if err := response.Err(); err != nil {
+		return err
 	}
 
 	// 将结果解码到reply中
@@ -0,0 +1,53 @@
+package client
+
+import "rpc/transport"
+
+// seqRange 一个连接上发出的调用的序号范围 (from, to]
+type seqRange struct {
+	from, to uint64
+}
+
+func (r seqRange) contains(seq uint64) bool {
+	return seq > r.from && seq <= r.to
+}
+
+// goAway 服务端即将关闭连接（如热重启）：后续调用在新连接上发起，旧连接在已发出的调用完成后关闭
+func (client *Client) goAway(conn transport.Conn) {
+	client.mu.Lock()
+	if client.conn != conn || !client.isConnected {
+		client.mu.Unlock()
+		return
+	}
+	if client.draining == nil {
+		client.draining = make(map[transport.Conn]seqRange)
+	}
+	client.draining[conn] = seqRange{from: client.connSeq, to: client.seq}
+	client.isConnected = false
+	client.closeDrained()
+	client.mu.Unlock()
+
+	// 订阅保存在服务端的连接上，立即在新连接上恢复
+	client.subMu.RLock()
+	subscribed := len(client.subscriptions) > 0
+	client.subMu.RUnlock()
+	if subscribed {
+		go client.reconnect()
+	}
+}
+
+// closeDrained 关闭已发出的调用全部完成的旧连接，调用方需持有mu
+func (client *Client) closeDrained() {
+	for conn, r := range client.draining {
+		done := true
+		for seq := range client.pending {
+			if r.contains(seq) {
+				done = false
+				break
+			}
+		}
+		if done {
+			delete(client.draining, conn)
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"rpc/protocol"
+	"rpc/transport"
+)
+
+// keepaliveLoop 连接空闲时定期发送心跳，心跳无应答时将连接标记为断开并重连
+func (client *Client) keepaliveLoop(conn transport.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(client.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			sinceRead := now.Sub(time.Unix(0, client.lastRead.Load()))
+
+			if sinceRead >= client.keepalive+client.keepTimeout {
+				log.Printf("Keepalive timeout, reconnecting to %s\n", client.serverAddr)
+				client.mu.Lock()
+				client.disconnect(conn)
+				client.mu.Unlock()
+				client.reconnect()
+				return
+			}
+
+			if sinceRead >= client.keepalive {
+				ping := protocol.EncodeFrame(&protocol.Frame{
+					Header: &protocol.Header{
+						MagicNumber:   protocol.MagicNumber,
+						Version:       protocol.Version,
+						MessageType:   protocol.Ping,
+						SerializeType: byte(client.codecType),
+					},
+				})
+				if err := client.write(conn, ping); err != nil {
+					log.Printf("Keepalive write error: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+// reconnect 重新建立连接并恢复订阅，失败时由下一次调用再次尝试连接
+func (client *Client) reconnect() {
+	if err := client.Connect(); err != nil {
+		log.Printf("Reconnect to %s error: %v\n", client.serverAddr, err)
+		return
+	}
+	client.resubscribe()
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"fmt"
+	"log"
+
+	"rpc/codec"
+	"rpc/protocol"
+)
+
+// Message 订阅收到的消息
+type Message struct {
+	Topic      string // 主题名
+	Data       []byte // 编码后的消息
+	serializer codec.Codec
+}
+
+// Decode 将消息解码到value中
+func (m *Message) Decode(value interface{}) error {
+	return m.serializer.Decode(m.Data, value)
+}
+
+// SubscribeOption 订阅配置
+type SubscribeOption struct {
+	BufferSize int                     // 服务端为该订阅保留的缓冲区大小，0表示使用默认值，超过服务端的上限时订阅失败
+	Policy     protocol.OverflowPolicy // 缓冲区满时的处理策略
+}
+
+// subscription 客户端记录的订阅，用于投递消息和重连后恢复订阅
+type subscription struct {
+	handler func(*Message)
+	opt     SubscribeOption
+}
+
+// pubsubReceiver 接收服务端投递的消息，以protocol.PubSubReceiver为名注册
+type pubsubReceiver struct {
+	client *Client
+}
+
+// Deliver 将消息交给对应主题的处理函数
+func (r *pubsubReceiver) Deliver(msg protocol.TopicMessage, reply *struct{}) error {
+	r.client.subMu.RLock()
+	sub := r.client.subscriptions[msg.Topic]
+	r.client.subMu.RUnlock()
+
+	// 已取消订阅的主题直接忽略
+	if sub == nil {
+		return nil
+	}
+
+	sub.handler(&Message{Topic: msg.Topic, Data: msg.Data, serializer: r.client.serializer})
+	return nil
+}
+
+// Subscribe 订阅主题，同一主题的消息按发布顺序依次交给handler处理
+// 订阅与连接绑定，心跳超时重连后会自动恢复，其他原因断开后需要重新订阅
+func (client *Client) Subscribe(topic string, handler func(*Message), opt *SubscribeOption) error {
+	if opt == nil {
+		opt = &SubscribeOption{}
+	}
+
+	client.subMu.Lock()
+	client.subscriptions[topic] = &subscription{handler: handler, opt: *opt}
+	client.subMu.Unlock()
+
+	args := protocol.SubscribeArgs{
+		Topic:      topic,
+		BufferSize: opt.BufferSize,
+		Policy:     opt.Policy,
+	}
+	if err := client.Call(protocol.PubSubService+".Subscribe", args, &struct{}{}); err != nil {
+		client.subMu.Lock()
+		delete(client.subscriptions, topic)
+		client.subMu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Unsubscribe 取消订阅主题
+func (client *Client) Unsubscribe(topic string) error {
+	client.subMu.Lock()
+	delete(client.subscriptions, topic)
+	client.subMu.Unlock()
+
+	args := protocol.SubscribeArgs{Topic: topic}
+	return client.Call(protocol.PubSubService+".Unsubscribe", args, &struct{}{})
+}
+
+// Publish 发布消息到主题，返回成功放入缓冲区的订阅者数量
+func (client *Client) Publish(topic string, value interface{}) (int, error) {
+	data, err := client.serializer.Encode(value)
+	if err != nil {
+		return 0, fmt.Errorf("encode message error: %v", err)
+	}
+
+	var reply protocol.PublishReply
+	args := protocol.PublishArgs{Topic: topic, Data: data}
+	if err := client.Call(protocol.PubSubService+".Publish", args, &reply); err != nil {
+		return 0, err
+	}
+	return reply.Delivered, nil
+}
+
+// resubscribe 在新连接上恢复所有订阅
+func (client *Client) resubscribe() {
+	client.subMu.RLock()
+	args := make([]protocol.SubscribeArgs, 0, len(client.subscriptions))
+	for topic, sub := range client.subscriptions {
+		args = append(args, protocol.SubscribeArgs{
+			Topic:      topic,
+			BufferSize: sub.opt.BufferSize,
+			Policy:     sub.opt.Policy,
+		})
+	}
+	client.subMu.RUnlock()
+
+	for _, arg := range args {
+		if err := client.Call(protocol.PubSubService+".Subscribe", arg, &struct{}{}); err != nil {
+			log.Printf("Resubscribe topic %s error: %v\n", arg.Topic, err)
+		}
+	}
+}
@@ -4,20 +4,49 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"rpc/auth"
 	"rpc/client"
 	"rpc/codec"
 	"rpc/example"
+	"rpc/protocol"
 	"rpc/transport"
 )
 
 var (
 	serverAddr     = flag.String("addr", "localhost:8972", "服务器地址")
-	transportType  = flag.String("transport", "tcp", "传输协议 (tcp/http)")
+	transportType  = flag.String("transport", "tcp", "传输协议 (tcp/http/h2/h2stream/udp/unix/ws/tunnel)，unix://开头的地址总是使用Unix域套接字")
 	serializerType = flag.String("serializer", "json", "序列化协议 (json/protobuf)")
+	tlsCA          = flag.String("tls-ca", "", "验证服务器证书的CA文件，设置后使用TLS")
+	tlsCert        = flag.String("tls-cert", "", "客户端证书文件（双向TLS）")
+	tlsKey         = flag.String("tls-key", "", "客户端私钥文件")
+	proxy          = flag.String("proxy", "", "HTTP隧道经过的HTTP代理地址")
+	token          = flag.String("token", "", "认证使用的Bearer令牌或JWT")
+	hmacKey        = flag.String("hmac-key", "", "签发HMAC令牌的共享密钥，令牌有效期为一小时")
+	signKeys       = flag.String("sign-key", "", "帧签名密钥，格式为 密钥ID:密钥，多个用逗号分隔，第一个用于签名")
+	breaker        = flag.Int("breaker", 0, "连续失败多少次后熔断，熔断期间调用立即失败，0表示不熔断")
+	priority       = flag.String("priority", "", "请求优先级 (critical/normal/sheddable)，服务端过载时先丢弃低优先级的请求；critical只对服务端信任的调用方有效")
 )
 
+// newSigner 解析 密钥ID:密钥 列表，第一个密钥用于签名，其余的只用于验证（密钥轮换期间）
+func newSigner(spec string) (*protocol.Signer, error) {
+	var signer *protocol.Signer
+	for _, item := range strings.Split(spec, ",") {
+		id, key, ok := strings.Cut(item, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid signing key %q, want id:key", item)
+		}
+		if signer == nil {
+			signer = protocol.NewSigner(id, []byte(key))
+		} else {
+			signer.AddKey(id, []byte(key))
+		}
+	}
+	return signer, nil
+}
+
 func main() {
 	flag.Parse()
 
@@ -30,6 +59,24 @@ func main() {
 	case "http":
 		tType = transport.HTTP
 		fmt.Println("使用HTTP传输协议")
+	case "h2":
+		tType = transport.HTTP2
+		fmt.Println("使用HTTP/2传输协议")
+	case "h2stream":
+		tType = transport.HTTP2Stream
+		fmt.Println("使用HTTP/2流传输协议")
+	case "udp":
+		tType = transport.UDP
+		fmt.Println("使用UDP传输协议")
+	case "unix":
+		tType = transport.Unix
+		fmt.Println("使用Unix域套接字传输协议")
+	case "ws":
+		tType = transport.WebSocket
+		fmt.Println("使用WebSocket传输协议")
+	case "tunnel":
+		tType = transport.HTTPTunnel
+		fmt.Println("使用HTTP隧道传输协议")
 	default:
 		log.Fatalf("不支持的传输协议: %s", *transportType)
 	}
@@ -52,6 +99,39 @@ func main() {
 		TransportType: tType,
 		CodecType:     cType,
 		Timeout:       time.Second * 5,
+		Proxy:         *proxy,
+	}
+	if *tlsCA != "" {
+		opt.TLS = &transport.TLSOption{
+			CAFile:   *tlsCA,
+			CertFile: *tlsCert,
+			KeyFile:  *tlsKey,
+		}
+		fmt.Println("已启用TLS")
+	}
+	switch {
+	case *token != "":
+		opt.Credentials = auth.Bearer(*token)
+	case *hmacKey != "":
+		opt.Credentials = auth.HMACCredentials([]byte(*hmacKey), "example-client", time.Hour)
+	}
+	if *signKeys != "" {
+		signer, err := newSigner(*signKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opt.Signer = signer
+	}
+	if *breaker > 0 {
+		opt.CircuitBreaker = &client.CircuitBreaker{
+			ConsecutiveFailures: *breaker,
+			OnStateChange: func(name string, from, to client.BreakerState) {
+				fmt.Printf("熔断器 %s: %v -> %v\n", name, from, to)
+			},
+		}
+	}
+	if *priority != "" {
+		opt.Metadata = map[string]string{protocol.MetadataPriority: *priority}
 	}
 
 	// 创建客户端
@@ -65,6 +145,9 @@ func main() {
 
 	// 测试Echo服务
 	testEchoService(c)
+
+	// 测试批量调用
+	testBatch(c)
 }
 
 // testArithService 测试算术服务
@@ -121,3 +204,28 @@ func testEchoService(c *client.Client) {
 	}
 	fmt.Printf("EchoService.Echo: 发送 '%s', 接收 '%s'\n", args.Message, reply.Message)
 }
+
+// testBatch 测试批量调用
+func testBatch(c *client.Client) {
+	batch := c.NewBatch()
+	batch.Parallel = true
+
+	var addReply, mulReply, divReply example.Result
+	var echoReply example.EchoResult
+	batch.Add("ArithService.Add", example.Args{A: 3, B: 4}, &addReply)
+	batch.Add("ArithService.Mul", example.Args{A: 3, B: 4}, &mulReply)
+	batch.Add("ArithService.Div", example.Args{A: 3, B: 0}, &divReply)
+	batch.Add("EchoService.Echo", example.EchoArgs{Message: "batch"}, &echoReply)
+
+	if err := batch.Do(); err != nil {
+		log.Fatalf("批量调用错误: %v", err)
+	}
+
+	for _, call := range batch.Calls {
+		if call.Error != nil {
+			fmt.Printf("批量条目 %s 错误: %v\n", call.ServiceMethod, call.Error)
+			continue
+		}
+		fmt.Printf("批量条目 %s 结果: %+v\n", call.ServiceMethod, call.Reply)
+	}
+}
@@ -1,40 +1,164 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"rpc/auth"
 	"rpc/codec"
 	"rpc/example"
+	"rpc/protocol"
 	"rpc/server"
 	"rpc/transport"
 )
 
 var (
-	addr           = flag.String("addr", ":8972", "服务地址")
-	transportType  = flag.String("transport", "tcp", "传输协议 (tcp/http)")
-	serializerType = flag.String("serializer", "json", "序列化协议 (json/protobuf)")
+	addr            = flag.String("addr", ":8972", "服务地址")
+	transportType   = flag.String("transport", "tcp", "传输协议 (tcp/http/h2/h2stream/udp/unix/ws/tunnel)，unix://开头的地址总是使用Unix域套接字")
+	serializerType  = flag.String("serializer", "json", "序列化协议 (json/protobuf)")
+	tlsCert         = flag.String("tls-cert", "", "TLS证书文件，为空时不加密")
+	tlsKey          = flag.String("tls-key", "", "TLS私钥文件")
+	tlsCA           = flag.String("tls-ca", "", "验证客户端证书的CA文件，设置后要求客户端证书（双向TLS）")
+	jsonrpcAddr     = flag.String("jsonrpc", "", "按行分隔的JSON-RPC 2.0 TCP地址，为空时不启动")
+	listen          = flag.String("listen", "", "额外的监听，格式为 传输协议=地址，多个用逗号分隔，如 http=:8974,unix=unix:///tmp/rpc.sock")
+	grace           = flag.Duration("grace", 30*time.Second, "优雅关闭和热重启时等待进行中调用完成的最长时间")
+	authToken       = flag.String("auth-token", "", "接受的静态Bearer令牌，设置任一认证参数后要求客户端认证")
+	hmacKey         = flag.String("hmac-key", "", "验证HMAC令牌和HS256 JWT的共享密钥")
+	jwtKey          = flag.String("jwt-key", "", "验证RS256 JWT的RSA公钥PEM文件")
+	aclFile         = flag.String("acl", "", "访问控制策略文件（YAML或JSON），修改后自动重新加载，为空时不检查")
+	signKeys        = flag.String("sign-key", "", "帧签名密钥，格式为 密钥ID:密钥，多个用逗号分隔，第一个用于签名，设置后要求客户端签名")
+	maxInFlight     = flag.Int("max-inflight", 0, "同时执行的请求数上限，超出时返回Unavailable，0表示不限制")
+	workers         = flag.Int("workers", 0, "执行请求的工作协程数，0表示每个请求一个goroutine")
+	queueSize       = flag.Int("queue", 0, "等待工作协程的请求数上限，队列满时返回Unavailable")
+	criticalWorkers = flag.Int("critical-workers", 0, "为关键请求保留的工作协程数，0表示关键请求与其他请求共用上限")
+	shedTarget      = flag.Duration("shed-target", 0, "按排队时间自适应减载的目标排队时间（需设置-workers），0表示不减载")
+	priorities      = flag.String("priority", "", "按方法指定请求优先级，格式为 方法=优先级，多个用逗号分隔，优先级为critical、normal或sheddable，如 EchoService.*=critical")
+	criticalCallers = flag.String("critical-callers", "", "可以通过请求元数据把请求标记为critical的调用方标识或角色，多个用逗号分隔，需同时设置认证参数")
+	rateLimits      = flag.String("rate-limit", "", "每秒请求数限制，格式为 范围=速率，多个用逗号分隔，范围为global、client或Service.Method（可用Service.*），如 global=1000,client=50,ArithService.Div=10")
 )
 
+// parseTransport 解析传输协议名称，返回传输类型和说明
+func parseTransport(name string) (transport.TransportType, string, bool) {
+	switch name {
+	case "tcp":
+		return transport.TCP, "TCP", true
+	case "http":
+		return transport.HTTP, "HTTP", true
+	case "h2":
+		return transport.HTTP2, "HTTP/2", true
+	case "h2stream":
+		return transport.HTTP2Stream, "HTTP/2流", true
+	case "udp":
+		return transport.UDP, "UDP", true
+	case "unix":
+		return transport.Unix, "Unix域套接字", true
+	case "ws":
+		return transport.WebSocket, "WebSocket", true
+	case "tunnel":
+		return transport.HTTPTunnel, "HTTP隧道", true
+	}
+	return 0, "", false
+}
+
+// newAuthenticator 按参数组合认证器，没有设置认证参数时返回nil
+func newAuthenticator() auth.Authenticator {
+	var authenticators []auth.Authenticator
+	if *authToken != "" {
+		authenticators = append(authenticators, auth.StaticTokens(map[string]string{*authToken: "token"}))
+	}
+	if *hmacKey != "" || *jwtKey != "" {
+		jwt := &auth.JWT{Leeway: time.Minute}
+		if *hmacKey != "" {
+			jwt.HMACKey = []byte(*hmacKey)
+			authenticators = append(authenticators, auth.HMAC([]byte(*hmacKey)))
+		}
+		if *jwtKey != "" {
+			data, err := os.ReadFile(*jwtKey)
+			if err != nil {
+				log.Fatal("读取JWT公钥失败:", err)
+			}
+			if jwt.RSAKey, err = auth.ParseRSAPublicKey(data); err != nil {
+				log.Fatal("解析JWT公钥失败:", err)
+			}
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	if len(authenticators) == 0 {
+		return nil
+	}
+	return auth.Chain(authenticators...)
+}
+
+// newSigner 解析 密钥ID:密钥 列表，第一个密钥用于签名，其余的只用于验证（密钥轮换期间）
+func newSigner(spec string) (*protocol.Signer, error) {
+	var signer *protocol.Signer
+	for _, item := range strings.Split(spec, ",") {
+		id, key, ok := strings.Cut(item, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid signing key %q, want id:key", item)
+		}
+		if signer == nil {
+			signer = protocol.NewSigner(id, []byte(key))
+		} else {
+			signer.AddKey(id, []byte(key))
+		}
+	}
+	return signer, nil
+}
+
+// parseRateLimits 解析限流参数，突发请求数与每秒请求数相同
+func parseRateLimits(spec string) (*server.RateLimits, error) {
+	limits := &server.RateLimits{Methods: make(map[string]server.RateLimit)}
+	for _, item := range strings.Split(spec, ",") {
+		scope, value, _ := strings.Cut(item, "=")
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q, want scope=rate", item)
+		}
+		switch scope {
+		case "global":
+			limits.Global = server.RateLimit{Rate: rate}
+		case "client":
+			limits.Client = server.RateLimit{Rate: rate}
+		default:
+			limits.Methods[scope] = server.RateLimit{Rate: rate}
+		}
+	}
+	return limits, nil
+}
+
+// parsePriorities 解析 -priority 参数
+func parsePriorities(spec string) (map[string]server.Priority, error) {
+	priorities := make(map[string]server.Priority)
+	for _, item := range strings.Split(spec, ",") {
+		method, name, _ := strings.Cut(item, "=")
+		priority, ok := server.ParsePriority(name)
+		if !ok || method == "" {
+			return nil, fmt.Errorf("invalid priority %q, want method=critical|normal|sheddable", item)
+		}
+		priorities[method] = priority
+	}
+	return priorities, nil
+}
+
 func main() {
 	flag.Parse()
 
 	// 解析传输类型
-	var tType transport.TransportType
-	switch *transportType {
-	case "tcp":
-		tType = transport.TCP
-		fmt.Println("使用TCP传输协议")
-	case "http":
-		tType = transport.HTTP
-		fmt.Println("使用HTTP传输协议")
-	default:
+	tType, desc, ok := parseTransport(*transportType)
+	if !ok {
 		log.Fatalf("不支持的传输协议: %s", *transportType)
 	}
+	fmt.Printf("使用%s传输协议\n", desc)
 
 	// 解析序列化类型
 	var cType codec.Type
@@ -50,7 +174,66 @@ func main() {
 	}
 
 	// 创建RPC服务器
-	s := server.NewServer(tType, cType)
+	opt := *server.DefaultOption
+	opt.TransportType = tType
+	opt.CodecType = cType
+	if *tlsCert != "" {
+		opt.TLS = &transport.TLSOption{
+			CertFile:   *tlsCert,
+			KeyFile:    *tlsKey,
+			CAFile:     *tlsCA,
+			ClientAuth: *tlsCA != "",
+		}
+		fmt.Println("已启用TLS")
+	}
+	if authenticator := newAuthenticator(); authenticator != nil {
+		opt.Authenticator = authenticator
+		fmt.Println("已启用认证")
+	}
+	if *aclFile != "" {
+		policy, err := auth.LoadPolicy(*aclFile)
+		if err != nil {
+			log.Fatal("加载访问控制策略失败:", err)
+		}
+		defer policy.Watch(time.Second)()
+		opt.Authorizer = policy
+		fmt.Println("已启用访问控制")
+	}
+	if *signKeys != "" {
+		signer, err := newSigner(*signKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opt.Signer = signer
+		fmt.Println("已启用帧签名")
+	}
+	if *rateLimits != "" {
+		limits, err := parseRateLimits(*rateLimits)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opt.RateLimits = limits
+		fmt.Println("已启用限流")
+	}
+	if *maxInFlight > 0 || *workers > 0 || *criticalWorkers > 0 {
+		opt.Concurrency = &server.ConcurrencyLimits{MaxInFlight: *maxInFlight, Workers: *workers, QueueSize: *queueSize, CriticalWorkers: *criticalWorkers}
+		fmt.Println("已启用并发限制")
+	}
+	if *shedTarget > 0 || *priorities != "" || *criticalCallers != "" {
+		opt.LoadShedding = &server.LoadShedding{Target: *shedTarget}
+		if *criticalCallers != "" {
+			opt.LoadShedding.CriticalCallers = strings.Split(*criticalCallers, ",")
+		}
+		if *priorities != "" {
+			p, err := parsePriorities(*priorities)
+			if err != nil {
+				log.Fatal(err)
+			}
+			opt.LoadShedding.Priorities = p
+		}
+		fmt.Println("已启用自适应减载")
+	}
+	s := server.NewServerWithOption(&opt)
 
 	// 注册服务
 	err := s.Register(new(example.ArithService))
@@ -65,19 +248,74 @@ func main() {
 	}
 	fmt.Println("已注册Echo服务")
 
-	// 优雅退出
+	// 优雅退出；收到重启信号时启动新进程接管监听，本进程处理完进行中的调用后退出
 	quit := make(chan os.Signal, 1)
+	restart := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	if len(restartSignals) > 0 {
+		signal.Notify(restart, restartSignals...)
+	}
 	go func() {
-		<-quit
-		fmt.Println("正在关闭服务器...")
-		s.Close()
-		os.Exit(0)
+		defer close(stopped)
+		for {
+			select {
+			case <-quit:
+				fmt.Println("正在关闭服务器...")
+				ctx, cancel := context.WithTimeout(context.Background(), *grace)
+				if err := s.Shutdown(ctx); err != nil {
+					log.Println("优雅关闭未完成:", err)
+				}
+				cancel()
+				return
+			case <-restart:
+				fmt.Println("正在重启服务器...")
+				ctx, cancel := context.WithTimeout(context.Background(), *grace)
+				err := s.Restart(ctx)
+				cancel()
+				if errors.Is(err, server.ErrRestartFailed) {
+					log.Println("重启失败，继续提供服务:", err)
+					continue
+				}
+				if err != nil {
+					log.Println("优雅关闭未完成:", err)
+				}
+				return
+			}
+		}
 	}()
 
+	// 启动JSON-RPC服务
+	if *jsonrpcAddr != "" {
+		go func() {
+			if err := s.ServeJSONRPC(*jsonrpcAddr); err != nil {
+				log.Fatal("JSON-RPC服务启动失败:", err)
+			}
+		}()
+		fmt.Printf("JSON-RPC服务正在监听 %s\n", *jsonrpcAddr)
+	}
+
+	// 启动额外的监听，与主监听共享同一组服务
+	for _, l := range strings.Split(*listen, ",") {
+		if l == "" {
+			continue
+		}
+		name, listenAddr, _ := strings.Cut(l, "=")
+		t, desc, ok := parseTransport(name)
+		if !ok {
+			log.Fatalf("不支持的传输协议: %s", name)
+		}
+		if err := s.AddListener(t, listenAddr); err != nil {
+			log.Fatal("添加监听失败:", err)
+		}
+		fmt.Printf("%s服务正在监听 %s\n", desc, listenAddr)
+	}
+
 	// 启动服务器
 	fmt.Printf("RPC服务器正在监听 %s\n", *addr)
-	if err := s.Serve(*addr); err != nil {
+	if err := s.Serve(*addr); err != server.ErrServerClosed {
 		log.Fatal("服务器启动失败:", err)
 	}
+	// Serve在关闭开始时即返回，等待所有监听关闭完毕
+	<-stopped
 }
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// restartSignals 该平台不支持热重启
+var restartSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals 触发热重启的信号
+var restartSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
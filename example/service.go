@@ -1,8 +1,9 @@
 package example
 
 import (
-	"errors"
 	"fmt"
+
+	"rpc/protocol"
 )
 
 // Args 计算服务参数
@@ -40,7 +41,7 @@ func (a *ArithService) Mul(args Args, result *Result) error {
 // Div 除法操作
 func (a *ArithService) Div(args Args, result *Result) error {
 	if args.B == 0 {
-		return errors.New("division by zero")
+		return protocol.Errorf(protocol.InvalidArgument, "division by zero")
 	}
 	result.Value = args.A / args.B
 	return nil
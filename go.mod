@@ -1,5 +1,8 @@
 module rpc
 
-go 1.23.5
+go 1.24.0
 
-require google.golang.org/protobuf v1.36.6 // indirect
+require (
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
+)
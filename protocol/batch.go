@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// 批量请求标志位
+const (
+	BatchParallel byte = 1 << 0 // 服务端可以并行执行批量中的各个条目
+)
+
+// EncodeBatch 将多个帧打包为批量负载
+// 格式: flags(1) + count(4) + [length(4) + frame]...
+func EncodeBatch(flags byte, frames [][]byte) []byte {
+	size := 5
+	for _, frame := range frames {
+		size += 4 + len(frame)
+	}
+
+	buffer := make([]byte, 5, size)
+	buffer[0] = flags
+	binary.BigEndian.PutUint32(buffer[1:5], uint32(len(frames)))
+
+	lengthBuf := make([]byte, 4)
+	for _, frame := range frames {
+		binary.BigEndian.PutUint32(lengthBuf, uint32(len(frame)))
+		buffer = append(buffer, lengthBuf...)
+		buffer = append(buffer, frame...)
+	}
+
+	return buffer
+}
+
+// DecodeBatch 从批量负载中解析出标志位和各个帧
+func DecodeBatch(data []byte) (byte, [][]byte, error) {
+	if len(data) < 5 {
+		return 0, nil, errors.New("invalid batch data: too short")
+	}
+
+	flags := data[0]
+	count := binary.BigEndian.Uint32(data[1:5])
+
+	// 每个条目至少占用4字节长度前缀，以此校验count防止过量分配
+	rest := data[5:]
+	if uint64(count)*4 > uint64(len(rest)) {
+		return 0, nil, errors.New("invalid batch data: bad entry count")
+	}
+
+	frames := make([][]byte, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(rest) < 4 {
+			return 0, nil, errors.New("invalid batch data: truncated entry length")
+		}
+		length := binary.BigEndian.Uint32(rest[:4])
+		rest = rest[4:]
+		if uint64(len(rest)) < uint64(length) {
+			return 0, nil, errors.New("invalid batch data: truncated entry")
+		}
+		frames = append(frames, rest[:length])
+		rest = rest[length:]
+	}
+
+	return flags, frames, nil
+}
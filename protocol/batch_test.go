@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	frames := [][]byte{[]byte("first"), {}, []byte("third")}
+	flags, decoded, err := DecodeBatch(EncodeBatch(BatchParallel, frames))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != BatchParallel {
+		t.Errorf("flags = %#x, want %#x", flags, BatchParallel)
+	}
+	if len(decoded) != len(frames) {
+		t.Fatalf("decoded %d frames, want %d", len(decoded), len(frames))
+	}
+	for i := range frames {
+		if !bytes.Equal(decoded[i], frames[i]) {
+			t.Errorf("frame %d = %q, want %q", i, decoded[i], frames[i])
+		}
+	}
+}
+
+func TestDecodeBatchInvalid(t *testing.T) {
+	valid := EncodeBatch(0, [][]byte{[]byte("abc")})
+	tests := map[string][]byte{
+		"short":            {0, 0},
+		"bad count":        {0, 0xff, 0xff, 0xff, 0xff},
+		"truncated entry":  valid[:len(valid)-1],
+		"truncated length": valid[:7],
+	}
+	for name, data := range tests {
+		if _, _, err := DecodeBatch(data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Code 调用错误码，随错误响应返回给调用方
+type Code uint32
+
+const (
+	OK                 Code = iota // 0 成功
+	Canceled                       // 1 调用被取消
+	Unknown                        // 2 未知错误，服务方法返回的普通错误
+	InvalidArgument                // 3 参数错误
+	DeadlineExceeded               // 4 超时
+	NotFound                       // 5 服务或方法不存在
+	AlreadyExists                  // 6 资源已存在
+	PermissionDenied               // 7 没有权限
+	ResourceExhausted              // 8 资源耗尽（内存、限流、帧过大等）
+	FailedPrecondition             // 9 当前状态不允许该操作
+	Aborted                        // 10 操作被中止
+	OutOfRange                     // 11 超出范围
+	Unimplemented                  // 12 未实现
+	Internal                       // 13 内部错误
+	Unavailable                    // 14 服务暂时不可用
+	DataLoss                       // 15 数据丢失
+	Unauthenticated                // 16 未认证
+)
+
+var codeNames = [...]string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound",
+	"AlreadyExists", "PermissionDenied", "ResourceExhausted", "FailedPrecondition",
+	"Aborted", "OutOfRange", "Unimplemented", "Internal", "Unavailable", "DataLoss",
+	"Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", c)
+}
+
+// Error 带错误码的调用错误，服务方法返回它时错误码会传给调用方
+type Error struct {
+	Code       Code
+	Message    string
+	RetryAfter time.Duration // 建议调用方等待多久后重试（如被限流），0表示没有建议
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf 创建带错误码的错误
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// CodeOf 返回错误对应的错误码：nil为OK，context的取消和超时分别为Canceled和DeadlineExceeded，其余未带错误码的为Unknown
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	}
+	return Unknown
+}
+
+// RetryAfter 返回错误中建议的重试等待时间，没有时返回0
+func RetryAfter(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{nil, OK},
+		{errors.New("plain"), Unknown},
+		{Errorf(NotFound, "missing"), NotFound},
+		{fmt.Errorf("wrapped: %w", Errorf(PermissionDenied, "no")), PermissionDenied},
+		{context.Canceled, Canceled},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		if got := CodeOf(tt.err); got != tt.want {
+			t.Errorf("CodeOf(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestResponseMessageErr(t *testing.T) {
+	if err := (&ResponseMessage{}).Err(); err != nil {
+		t.Errorf("Err of a successful response = %v", err)
+	}
+
+	err := (&ResponseMessage{Error: "missing", Code: NotFound}).Err()
+	if CodeOf(err) != NotFound || err.Error() != "missing" {
+		t.Errorf("Err = %v (%v), want missing (NotFound)", err, CodeOf(err))
+	}
+
+	// 旧版本的服务端不返回错误码
+	if err := (&ResponseMessage{Error: "boom"}).Err(); CodeOf(err) != Unknown {
+		t.Errorf("Err without a code = %v, want Unknown", CodeOf(err))
+	}
+}
+
+func TestCodeString(t *testing.T) {
+	if s := Unauthenticated.String(); s != "Unauthenticated" {
+		t.Errorf("String = %q", s)
+	}
+	if s := Code(99).String(); s != "Code(99)" {
+		t.Errorf("String of an unknown code = %q", s)
+	}
+}
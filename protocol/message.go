@@ -3,45 +3,54 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+	"time"
 )
 
 const (
 	MagicNumber uint32 = 0x5C2F3E1D // 魔数
-	Version     byte   = 0x01       // 版本号
+	Version     byte   = 0x02       // 版本号
 )
 
 type MessageType byte
 
 const (
-	Request  MessageType = iota // 0
-	Response                    // 1
+	Request       MessageType = iota // 0
+	Response                         // 1
+	BatchRequest                     // 2 批量请求
+	BatchResponse                    // 3 批量响应
+	Ping                             // 4 心跳请求
+	Pong                             // 5 心跳响应
+	GoAway                           // 6 服务端即将关闭连接：客户端在新连接上发起后续调用，等已发出的调用完成后关闭旧连接
+	Auth                             // 7 认证请求：负载为令牌，服务端按顺序处理并以Response应答，之后的请求以该身份调用
 )
 
 // Header RPC消息头部
 type Header struct {
 	MagicNumber   uint32      // 固定标识（如 0x5RPC）
-	Version       byte        // 协议版本（1）
+	Version       byte        // 协议版本（2）
 	MessageType   MessageType // 消息类型（请求/响应）
 	SerializeType byte        // 0=JSON, 1=Protobuf
+	Seq           uint64      // 请求序号，响应与对应请求相同
 	ServiceLength uint16      // 服务名长度
 	MethodLength  uint16      // 方法名长度
 	PayloadLength uint32      // 参数数据长度
 }
 
 // HeaderSize 消息头大小常量
-const HeaderSize = 15 // 4+1+1+1+2+2+4 = 15 bytes
+const HeaderSize = 23 // 4+1+1+1+8+2+2+4 = 23 bytes
 
 // EncodeHeader 将消息头编码为字节数组
 func EncodeHeader(h *Header) []byte {
-	buffer := make([]byte, HeaderSize) // 4+1+1+1+2+2+4 = 15 bytes
+	buffer := make([]byte, HeaderSize) // 4+1+1+1+8+2+2+4 = 23 bytes
 
 	binary.BigEndian.PutUint32(buffer[0:4], h.MagicNumber)
 	buffer[4] = h.Version
 	buffer[5] = byte(h.MessageType)
 	buffer[6] = h.SerializeType
-	binary.BigEndian.PutUint16(buffer[7:9], h.ServiceLength)
-	binary.BigEndian.PutUint16(buffer[9:11], h.MethodLength)
-	binary.BigEndian.PutUint32(buffer[11:15], h.PayloadLength)
+	binary.BigEndian.PutUint64(buffer[7:15], h.Seq)
+	binary.BigEndian.PutUint16(buffer[15:17], h.ServiceLength)
+	binary.BigEndian.PutUint16(buffer[17:19], h.MethodLength)
+	binary.BigEndian.PutUint32(buffer[19:23], h.PayloadLength)
 
 	return buffer
 }
@@ -57,9 +66,10 @@ func DecodeHeader(data []byte) (*Header, error) {
 		Version:       data[4],
 		MessageType:   MessageType(data[5]),
 		SerializeType: data[6],
-		ServiceLength: binary.BigEndian.Uint16(data[7:9]),
-		MethodLength:  binary.BigEndian.Uint16(data[9:11]),
-		PayloadLength: binary.BigEndian.Uint32(data[11:15]),
+		Seq:           binary.BigEndian.Uint64(data[7:15]),
+		ServiceLength: binary.BigEndian.Uint16(data[15:17]),
+		MethodLength:  binary.BigEndian.Uint16(data[17:19]),
+		PayloadLength: binary.BigEndian.Uint32(data[19:23]),
 	}
 
 	if h.MagicNumber != MagicNumber {
@@ -69,6 +79,71 @@ func DecodeHeader(data []byte) (*Header, error) {
 	return h, nil
 }
 
+// Frame 完整的协议帧：消息头 + 服务名 + 方法名 + [元数据] + 负载
+type Frame struct {
+	Header      *Header
+	ServiceName string
+	MethodName  string
+	Metadata    map[string]string // 请求元数据（如优先级），为空时不编码
+	Payload     []byte
+}
+
+// EncodeFrame 将帧编码为字节数组，消息头中的长度字段和元数据标志会根据内容自动填充
+func EncodeFrame(f *Frame) []byte {
+	var metadata []byte
+	f.Header.Version &^= FlagMetadata
+	if len(f.Metadata) > 0 {
+		metadata = encodeMetadata(f.Metadata)
+		f.Header.Version |= FlagMetadata
+	}
+	f.Header.ServiceLength = uint16(len(f.ServiceName))
+	f.Header.MethodLength = uint16(len(f.MethodName))
+	f.Header.PayloadLength = uint32(len(metadata) + len(f.Payload))
+
+	buffer := make([]byte, 0, HeaderSize+len(f.ServiceName)+len(f.MethodName)+len(metadata)+len(f.Payload))
+	buffer = append(buffer, EncodeHeader(f.Header)...)
+	buffer = append(buffer, f.ServiceName...)
+	buffer = append(buffer, f.MethodName...)
+	buffer = append(buffer, metadata...)
+	buffer = append(buffer, f.Payload...)
+
+	return buffer
+}
+
+// DecodeFrame 从字节数组解码完整的帧
+func DecodeFrame(data []byte) (*Frame, error) {
+	h, err := DecodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 提取服务名和方法名
+	serviceNameEnd := HeaderSize + int(h.ServiceLength)
+	methodNameEnd := serviceNameEnd + int(h.MethodLength)
+	if len(data) < methodNameEnd {
+		return nil, errors.New("invalid frame data: name too short")
+	}
+
+	// 提取负载数据
+	payloadEnd := uint64(methodNameEnd) + uint64(h.PayloadLength)
+	if uint64(len(data)) < payloadEnd {
+		return nil, errors.New("invalid frame data: payload too short")
+	}
+
+	frame := &Frame{
+		Header:      h,
+		ServiceName: string(data[HeaderSize:serviceNameEnd]),
+		MethodName:  string(data[serviceNameEnd:methodNameEnd]),
+		Payload:     data[methodNameEnd:payloadEnd],
+	}
+	if h.Version&FlagMetadata != 0 {
+		if frame.Metadata, frame.Payload, err = decodeMetadata(frame.Payload); err != nil {
+			return nil, err
+		}
+	}
+	return frame, nil
+}
+
 // RequestMessage 请求消息
 type RequestMessage struct {
 	ServiceMethod string      // 格式: "Service.Method"
@@ -77,6 +152,20 @@ type RequestMessage struct {
 
 // ResponseMessage 响应消息
 type ResponseMessage struct {
-	Error  string      // 错误信息，如果调用成功则为空
-	Result interface{} // 结果
+	Error      string        // 错误信息，如果调用成功则为空
+	Code       Code          // 错误码，调用成功时为OK
+	RetryAfter time.Duration // 建议的重试等待时间（如被限流），0表示没有
+	Result     interface{}   // 结果
+}
+
+// Err 返回响应中的错误，调用成功时返回nil
+func (r *ResponseMessage) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	code := r.Code
+	if code == OK {
+		code = Unknown
+	}
+	return &Error{Code: code, Message: r.Error, RetryAfter: r.RetryAfter}
 }
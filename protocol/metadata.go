@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// 版本号字节的低4位为帧格式版本，高4位为帧标志
+const (
+	versionMask  byte = 0x0F
+	FlagMetadata byte = 0x80 // 负载前附带元数据
+)
+
+// MetadataPriority 请求元数据中表示优先级的键，值为critical、normal或sheddable
+const MetadataPriority = "priority"
+
+// 元数据格式: count(2) + [keyLength(2) + key + valueLength(2) + value]...，位于负载之前，消息头的负载长度包含元数据
+
+// encodeMetadata 按键排序编码元数据
+func encodeMetadata(md map[string]string) []byte {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buffer := binary.BigEndian.AppendUint16(nil, uint16(len(keys)))
+	for _, k := range keys {
+		buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(k)))
+		buffer = append(buffer, k...)
+		buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(md[k])))
+		buffer = append(buffer, md[k]...)
+	}
+	return buffer
+}
+
+// decodeMetadata 从负载开头解码元数据，返回元数据和其后的负载
+func decodeMetadata(data []byte) (map[string]string, []byte, error) {
+	errShort := errors.New("invalid frame data: metadata too short")
+	if len(data) < 2 {
+		return nil, nil, errShort
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+
+	md := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		var field [2]string
+		for j := range field {
+			if len(data) < 2 {
+				return nil, nil, errShort
+			}
+			n := int(binary.BigEndian.Uint16(data))
+			if len(data) < 2+n {
+				return nil, nil, errShort
+			}
+			field[j] = string(data[2 : 2+n])
+			data = data[2+n:]
+		}
+		md[field[0]] = field[1]
+	}
+	return md, data, nil
+}
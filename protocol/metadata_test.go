@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameMetadataRoundTrip(t *testing.T) {
+	frame := &Frame{
+		Header:      &Header{MagicNumber: MagicNumber, Version: Version, MessageType: Request},
+		ServiceName: "Arith",
+		MethodName:  "Add",
+		Metadata:    map[string]string{MetadataPriority: "sheddable", "trace": ""},
+		Payload:     []byte(`{"A":1}`),
+	}
+	decoded, err := DecodeFrame(EncodeFrame(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Header.Version&FlagMetadata == 0 {
+		t.Error("metadata flag not set")
+	}
+	if len(decoded.Metadata) != 2 || decoded.Metadata[MetadataPriority] != "sheddable" {
+		t.Errorf("metadata = %v", decoded.Metadata)
+	}
+	if _, ok := decoded.Metadata["trace"]; !ok {
+		t.Error("empty metadata value lost")
+	}
+	if decoded.ServiceName != "Arith" || decoded.MethodName != "Add" || !bytes.Equal(decoded.Payload, frame.Payload) {
+		t.Errorf("decoded %s.%s %q", decoded.ServiceName, decoded.MethodName, decoded.Payload)
+	}
+
+	// 重新编码不带元数据的帧时清除标志
+	frame.Metadata = nil
+	decoded, err = DecodeFrame(EncodeFrame(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Header.Version&FlagMetadata != 0 || decoded.Metadata != nil {
+		t.Errorf("frame without metadata: version %#x, metadata %v", decoded.Header.Version, decoded.Metadata)
+	}
+}
+
+func TestDecodeMetadataInvalid(t *testing.T) {
+	valid := encodeMetadata(map[string]string{"key": "value"})
+	for _, data := range [][]byte{{0}, valid[:len(valid)-1], valid[:4]} {
+		if _, _, err := decodeMetadata(data); err == nil {
+			t.Errorf("decodeMetadata(%q): expected error", data)
+		}
+	}
+}
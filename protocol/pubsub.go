@@ -0,0 +1,41 @@
+package protocol
+
+// 发布订阅使用的内置服务
+const (
+	PubSubService  = "PubSub"                    // 服务端内置服务名
+	PubSubReceiver = "PubSubReceiver"            // 客户端接收消息的服务名
+	PubSubDeliver  = PubSubReceiver + ".Deliver" // 服务端向订阅者投递消息的方法
+)
+
+// OverflowPolicy 订阅者缓冲区满时的处理策略
+type OverflowPolicy byte
+
+const (
+	DropNewest OverflowPolicy = iota // 0 丢弃新消息
+	DropOldest                       // 1 丢弃缓冲区中最旧的消息
+	Block                            // 2 阻塞发布者直到缓冲区有空位
+)
+
+// SubscribeArgs 订阅参数
+type SubscribeArgs struct {
+	Topic      string         // 主题名
+	BufferSize int            // 缓冲区大小，0表示使用默认值
+	Policy     OverflowPolicy // 缓冲区满时的处理策略
+}
+
+// PublishArgs 发布参数
+type PublishArgs struct {
+	Topic string // 主题名
+	Data  []byte // 使用发布方编解码器编码后的消息
+}
+
+// PublishReply 发布结果
+type PublishReply struct {
+	Delivered int // 成功放入缓冲区的订阅者数量
+}
+
+// TopicMessage 投递给订阅者的消息
+type TopicMessage struct {
+	Topic string // 主题名
+	Data  []byte // 编码后的消息
+}
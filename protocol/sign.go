@@ -0,0 +1,214 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SignedVersion 带签名的帧使用的版本号（版本号字节的低4位）：负载末尾附带签名尾部，消息头的负载长度包含尾部
+const SignedVersion byte = 0x03
+
+// 签名尾部格式: keyID + timestamp(8) + nonce(16) + keyIDLength(1) + mac(32)
+// mac为HMAC-SHA256(key, mac之前的全部数据)，覆盖消息头、服务名、方法名、负载以及尾部的其他字段
+const (
+	nonceSize    = 16
+	macSize      = sha256.Size
+	trailerFixed = 8 + nonceSize + 1 + macSize
+)
+
+// DefaultReplayWindow 默认的重放窗口
+const DefaultReplayWindow = time.Minute * 5
+
+// ErrReplay 帧的时间戳超出重放窗口或随机数已经出现过
+var ErrReplay = Errorf(Unauthenticated, "frame replayed or outside replay window")
+
+// IsSigned 判断编码后的帧是否带有签名
+func IsSigned(data []byte) bool {
+	return len(data) >= HeaderSize && data[4]&versionMask == SignedVersion
+}
+
+// Signer 使用HMAC-SHA256签名和验证帧，用于没有TLS的TCP、UDP等不可信网络
+// 一个Signer可以同时持有多个密钥：签名使用当前密钥，验证时按帧中的密钥ID选择，轮换密钥时先在各方AddKey新密钥，
+// 再切换签名密钥，最后RemoveKey旧密钥
+// 验证时检查时间戳在重放窗口内且随机数没有出现过，通信双方的时钟偏差应小于重放窗口
+type Signer struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte // 密钥ID -> 密钥
+	current string            // 签名使用的密钥ID
+	window  time.Duration     // 重放窗口
+
+	nonceMu   sync.Mutex
+	nonces    map[[nonceSize]byte]int64 // 窗口内出现过的随机数 -> 过期时间（UnixNano）
+	lastPrune int64                     // 最近一次清理过期随机数的时间（UnixNano）
+}
+
+// NewSigner 创建以keyID对应的key签名的Signer，重放窗口为DefaultReplayWindow
+func NewSigner(keyID string, key []byte) *Signer {
+	s := &Signer{
+		keys:   make(map[string][]byte),
+		window: DefaultReplayWindow,
+		nonces: make(map[[nonceSize]byte]int64),
+	}
+	s.AddKey(keyID, key)
+	s.current = keyID
+	return s
+}
+
+// SetReplayWindow 设置重放窗口：时间戳与本地时间相差超过window的帧被拒绝
+func (s *Signer) SetReplayWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = window
+}
+
+// AddKey 添加验证时接受的密钥，keyID已存在时替换，keyID最长255字节
+func (s *Signer) AddKey(keyID string, key []byte) {
+	if len(keyID) > 255 {
+		panic("protocol: key ID too long")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = key
+}
+
+// RemoveKey 移除密钥，之后使用该密钥签名的帧被拒绝；不能移除当前的签名密钥
+func (s *Signer) RemoveKey(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if keyID == s.current {
+		return fmt.Errorf("cannot remove signing key %q", keyID)
+	}
+	delete(s.keys, keyID)
+	return nil
+}
+
+// UseKey 切换签名使用的密钥，密钥需已通过AddKey添加
+func (s *Signer) UseKey(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[keyID]; !ok {
+		return fmt.Errorf("unknown key %q", keyID)
+	}
+	s.current = keyID
+	return nil
+}
+
+// Sign 为编码后的帧附加签名尾部，返回新的帧
+func (s *Signer) Sign(data []byte) ([]byte, error) {
+	h, err := DecodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if IsSigned(data) {
+		return nil, errors.New("frame already signed")
+	}
+
+	s.mu.RLock()
+	keyID, key := s.current, s.keys[s.current]
+	s.mu.RUnlock()
+
+	trailer := len(keyID) + trailerFixed
+	signed := make([]byte, len(data), len(data)+trailer)
+	copy(signed, data)
+	signed[4] = SignedVersion | data[4]&^versionMask
+	binary.BigEndian.PutUint32(signed[19:23], h.PayloadLength+uint32(trailer))
+
+	signed = append(signed, keyID...)
+	signed = binary.BigEndian.AppendUint64(signed, uint64(time.Now().UnixNano()))
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	signed = append(signed, nonce[:]...)
+	signed = append(signed, byte(len(keyID)))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(signed)
+	return mac.Sum(signed), nil
+}
+
+// Verify 验证带签名的帧，成功时返回去掉签名尾部的帧（复用data的内存）
+// 未签名、密钥未知或签名错误时返回Unauthenticated错误，重放的帧返回ErrReplay
+func (s *Signer) Verify(data []byte) ([]byte, error) {
+	h, err := DecodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSigned(data) {
+		return nil, Errorf(Unauthenticated, "frame not signed")
+	}
+	end := HeaderSize + int(h.ServiceLength) + int(h.MethodLength) + int(h.PayloadLength)
+	if len(data) < end || int(h.PayloadLength) < trailerFixed {
+		return nil, Errorf(Unauthenticated, "invalid frame signature")
+	}
+	data = data[:end]
+
+	// 从末尾解析尾部
+	macStart := len(data) - macSize
+	keyIDLen := int(data[macStart-1])
+	trailer := keyIDLen + trailerFixed
+	if int(h.PayloadLength) < trailer {
+		return nil, Errorf(Unauthenticated, "invalid frame signature")
+	}
+	trailerStart := len(data) - trailer
+	keyID := string(data[trailerStart : trailerStart+keyIDLen])
+	ts := int64(binary.BigEndian.Uint64(data[trailerStart+keyIDLen:]))
+	var nonce [nonceSize]byte
+	copy(nonce[:], data[trailerStart+keyIDLen+8:])
+
+	s.mu.RLock()
+	key, ok := s.keys[keyID]
+	window := s.window
+	s.mu.RUnlock()
+	if !ok {
+		return nil, Errorf(Unauthenticated, "unknown signing key %q", keyID)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data[:macStart])
+	if !hmac.Equal(mac.Sum(nil), data[macStart:]) {
+		return nil, Errorf(Unauthenticated, "invalid frame signature")
+	}
+
+	if !s.checkNonce(nonce, ts, window) {
+		return nil, ErrReplay
+	}
+
+	// 恢复为未签名的帧
+	data = data[:trailerStart]
+	data[4] = Version | data[4]&^versionMask
+	binary.BigEndian.PutUint32(data[19:23], h.PayloadLength-uint32(trailer))
+	return data, nil
+}
+
+// checkNonce 检查时间戳在重放窗口内，并记录随机数直到它所在的帧超出窗口
+func (s *Signer) checkNonce(nonce [nonceSize]byte, ts int64, window time.Duration) bool {
+	now := time.Now().UnixNano()
+	if ts < now-int64(window) || ts > now+int64(window) {
+		return false
+	}
+
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+
+	// 每隔一个窗口清理一次过期的随机数
+	if now-s.lastPrune > int64(window) {
+		for n, expires := range s.nonces {
+			if expires < now {
+				delete(s.nonces, n)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if _, seen := s.nonces[nonce]; seen {
+		return false
+	}
+	s.nonces[nonce] = ts + int64(window)
+	return true
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testFrame() []byte {
+	return EncodeFrame(&Frame{
+		Header:      &Header{MagicNumber: MagicNumber, Version: Version, MessageType: Request, Seq: 7},
+		ServiceName: "Arith",
+		MethodName:  "Add",
+		Payload:     []byte(`{"A":1,"B":2}`),
+	})
+}
+
+func TestSignVerify(t *testing.T) {
+	signer := NewSigner("k1", []byte("secret"))
+	verifier := NewSigner("k1", []byte("secret"))
+
+	frame := testFrame()
+	signed, err := signer.Sign(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSigned(signed) || IsSigned(frame) {
+		t.Fatal("IsSigned does not match the frame version")
+	}
+	if _, err := DecodeFrame(signed); err != nil {
+		t.Fatalf("signed frame is not a valid frame: %v", err)
+	}
+	if _, err := signer.Sign(signed); err == nil {
+		t.Error("signing an already signed frame succeeded")
+	}
+
+	verified, err := verifier.Verify(signed)
+	if err != nil || !bytes.Equal(verified, frame) {
+		t.Fatalf("Verify = %x, %v, want the original frame", verified, err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := NewSigner("k1", []byte("secret"))
+	sign := func() []byte {
+		signed, err := signer.Sign(testFrame())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tampered := sign()
+	tampered[HeaderSize] ^= 1 // 服务名
+	tests := []struct {
+		name     string
+		data     []byte
+		verifier *Signer
+	}{
+		{"unsigned", testFrame(), NewSigner("k1", []byte("secret"))},
+		{"tampered", tampered, NewSigner("k1", []byte("secret"))},
+		{"wrong key", sign(), NewSigner("k1", []byte("other"))},
+		{"unknown key", sign(), NewSigner("k2", []byte("secret"))},
+		{"truncated", sign()[:HeaderSize+20], NewSigner("k1", []byte("secret"))},
+	}
+	for _, tt := range tests {
+		if _, err := tt.verifier.Verify(tt.data); CodeOf(err) != Unauthenticated {
+			t.Errorf("%s: %v, want Unauthenticated", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	signer := NewSigner("k1", []byte("secret"))
+	verifier := NewSigner("k1", []byte("secret"))
+
+	signed, _ := signer.Sign(testFrame())
+	replayed := append([]byte(nil), signed...)
+	if _, err := verifier.Verify(signed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(replayed); !errors.Is(err, ErrReplay) {
+		t.Errorf("replayed frame: %v, want ErrReplay", err)
+	}
+
+	// 超出重放窗口的帧被拒绝
+	verifier.SetReplayWindow(10 * time.Millisecond)
+	old, _ := signer.Sign(testFrame())
+	time.Sleep(30 * time.Millisecond)
+	if _, err := verifier.Verify(old); !errors.Is(err, ErrReplay) {
+		t.Errorf("frame outside the window: %v, want ErrReplay", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	client := NewSigner("k1", []byte("old"))
+	server := NewSigner("k1", []byte("old"))
+
+	// 先在双方添加新密钥，再切换签名密钥，最后移除旧密钥
+	client.AddKey("k2", []byte("new"))
+	server.AddKey("k2", []byte("new"))
+	pending, _ := client.Sign(testFrame())
+	if err := client.UseKey("k2"); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := client.Sign(testFrame())
+	for _, data := range [][]byte{pending, rotated} {
+		if _, err := server.Verify(data); err != nil {
+			t.Errorf("Verify during rotation: %v", err)
+		}
+	}
+
+	if err := client.RemoveKey("k2"); err == nil {
+		t.Error("removed the current signing key")
+	}
+	if err := client.UseKey("k3"); err == nil {
+		t.Error("switched to an unknown key")
+	}
+	if err := server.UseKey("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RemoveKey("k1"); err != nil {
+		t.Fatal(err)
+	}
+	stale, _ := NewSigner("k1", []byte("old")).Sign(testFrame())
+	if _, err := server.Verify(stale); CodeOf(err) != Unauthenticated {
+		t.Errorf("frame signed with a removed key: %v, want Unauthenticated", err)
+	}
+}
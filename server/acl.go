@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"rpc/auth"
+	"rpc/protocol"
+)
+
+// auditRecord 被拒绝调用的审计记录
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject,omitempty"` // 调用方标识，未认证时为空
+	Method  string    `json:"method"`            // 调用的方法 "Service.Method"
+	Peer    uint64    `json:"peer,omitempty"`    // 客户端编号，HTTP网关和JSON-RPC调用为0
+	Reason  string    `json:"reason"`
+}
+
+// authorize 配置了授权器时检查调用方能否调用方法，拒绝时写审计日志
+// 授权器返回的错误没有错误码时视为PermissionDenied
+func (server *Server) authorize(ctx context.Context, serviceMethod string) error {
+	if server.opt.Authorizer == nil {
+		return nil
+	}
+	principal := auth.FromContext(ctx)
+	err := server.opt.Authorizer.Authorize(ctx, principal, serviceMethod)
+	if err == nil {
+		return nil
+	}
+	if protocol.CodeOf(err) == protocol.Unknown {
+		err = protocol.Errorf(protocol.PermissionDenied, "%v", err)
+	}
+
+	record := auditRecord{Time: time.Now(), Method: serviceMethod, Reason: err.Error()}
+	if principal != nil {
+		record.Subject = principal.Subject
+	}
+	if p := PeerFromContext(ctx); p != nil {
+		record.Peer = p.id
+	}
+	server.audit(&record)
+	return err
+}
+
+// audit 写一条审计记录
+func (server *Server) audit(record *auditRecord) {
+	data, _ := json.Marshal(record)
+	if server.opt.AuditLog == nil {
+		log.Printf("Audit: %s\n", data)
+		return
+	}
+
+	server.auditMu.Lock()
+	defer server.auditMu.Unlock()
+	if _, err := server.opt.AuditLog.Write(append(data, '\n')); err != nil {
+		log.Printf("Write audit log error: %v\n", err)
+	}
+}
@@ -0,0 +1,87 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"rpc/auth"
+	"rpc/client"
+	"rpc/protocol"
+	"rpc/server"
+)
+
+// syncBuffer 可并发写入和读取的缓冲区
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAccessControl(t *testing.T) {
+	policy, err := auth.ParsePolicy([]byte(`
+rules:
+  - principals: [alice]
+    allow: ["Arith.*"]
+    deny: ["Arith.Fail"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &syncBuffer{}
+	opt := *server.DefaultOption
+	opt.Authenticator = auth.StaticTokens(map[string]string{"a": "alice", "b": "bob"})
+	opt.Authorizer = policy
+	opt.AuditLog = audit
+	s := server.NewServerWithOption(&opt)
+	s.Register(&Arith{})
+	addr := serve(t, s)
+	defer s.Close()
+
+	call := func(token, method string) error {
+		copt := *client.DefaultOption
+		copt.Credentials = auth.Bearer(token)
+		c := client.NewClient(addr, &copt)
+		defer c.Close()
+		var sum int
+		return c.Call(method, Args{A: 1, B: 2}, &sum)
+	}
+
+	if err := call("a", "Arith.Add"); err != nil {
+		t.Errorf("allowed call: %v", err)
+	}
+	if err := call("a", "Arith.Fail"); protocol.CodeOf(err) != protocol.PermissionDenied {
+		t.Errorf("denied method: %v, want PermissionDenied", err)
+	}
+	if err := call("b", "Arith.Add"); protocol.CodeOf(err) != protocol.PermissionDenied {
+		t.Errorf("principal without rules: %v, want PermissionDenied", err)
+	}
+
+	// 每次拒绝写一行JSON审计记录
+	lines := bytes.Split(bytes.TrimSpace([]byte(audit.String())), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d records, want 2:\n%s", len(lines), audit)
+	}
+	var record struct {
+		Subject, Method, Reason string
+		Peer                    uint64
+	}
+	if err := json.Unmarshal(lines[1], &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Subject != "bob" || record.Method != "Arith.Add" || record.Peer == 0 || record.Reason == "" {
+		t.Errorf("unexpected audit record: %+v", record)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"rpc/auth"
+	"rpc/protocol"
+	"rpc/transport"
+)
+
+// Principal 返回连接上已认证的调用方，未认证时返回nil
+func (p *Peer) Principal() *auth.Principal {
+	return p.principal.Load()
+}
+
+// callContext 返回处理连接上一个请求的context，携带当前已认证的调用方
+func (p *Peer) callContext() context.Context {
+	if principal := p.principal.Load(); principal != nil {
+		return auth.NewContext(p.ctx, principal)
+	}
+	return p.ctx
+}
+
+// authenticate 处理认证请求，返回应答帧；认证失败时清除连接上原有的身份
+func (server *Server) authenticate(p *Peer, frame *protocol.Frame) []byte {
+	if err := server.authenticatePeer(p, string(frame.Payload)); err != nil {
+		log.Printf("Peer %d authentication failed: %v\n", p.id, err)
+		return server.errorResponse(frame.Header, err)
+	}
+	payload, _ := server.serializer.Encode(&protocol.ResponseMessage{})
+	return server.response(frame.Header, protocol.Response, payload)
+}
+
+// authenticatePeer 验证令牌并记录为连接的调用方，未配置认证器时忽略
+func (server *Server) authenticatePeer(p *Peer, token string) error {
+	if server.opt.Authenticator == nil {
+		return nil
+	}
+	principal, err := server.authenticateToken(p.ctx, token)
+	p.principal.Store(principal)
+	return err
+}
+
+// authenticateHTTP 验证HTTP请求Authorization头中的Bearer令牌，返回携带调用方的context
+// 没有令牌时原样返回，由调用时的检查拒绝
+func (server *Server) authenticateHTTP(ctx context.Context, r *http.Request) (context.Context, error) {
+	token := transport.BearerToken(r)
+	if server.opt.Authenticator == nil || token == "" {
+		return ctx, nil
+	}
+	principal, err := server.authenticateToken(ctx, token)
+	if err != nil {
+		return ctx, err
+	}
+	return auth.NewContext(ctx, principal), nil
+}
+
+// authenticateToken 调用认证器验证令牌，认证器返回的错误没有错误码时视为Unauthenticated
+func (server *Server) authenticateToken(ctx context.Context, token string) (*auth.Principal, error) {
+	principal, err := server.opt.Authenticator.Authenticate(ctx, token)
+	switch {
+	case errors.Is(err, auth.ErrUnrecognized):
+		return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token")
+	case err != nil && protocol.CodeOf(err) == protocol.Unknown:
+		return nil, protocol.Errorf(protocol.Unauthenticated, "%v", err)
+	case err != nil:
+		return nil, err
+	case principal == nil:
+		return nil, protocol.Errorf(protocol.Unauthenticated, "invalid token")
+	}
+	return principal, nil
+}
+
+// checkAuth 配置了认证器时，要求调用方已认证且凭证未过期
+func (server *Server) checkAuth(ctx context.Context) error {
+	if server.opt.Authenticator == nil {
+		return nil
+	}
+	principal := auth.FromContext(ctx)
+	if principal == nil {
+		return protocol.Errorf(protocol.Unauthenticated, "missing credentials")
+	}
+	if principal.Expired(time.Now()) {
+		return protocol.Errorf(protocol.Unauthenticated, "credentials expired")
+	}
+	return nil
+}
+
+// verifyFrame 配置了签名时验证帧并返回去掉签名尾部的帧
+// 签名无效的请求返回Unauthenticated错误响应，重放的帧只记录日志后丢弃；未配置签名时拒绝带签名的帧
+func (server *Server) verifyFrame(p *Peer, data []byte) ([]byte, error) {
+	if server.opt.Signer == nil {
+		if protocol.IsSigned(data) {
+			err := protocol.Errorf(protocol.Unauthenticated, "frame signing not enabled on server")
+			server.rejectFrame(p, data, err)
+			return nil, err
+		}
+		return data, nil
+	}
+	verified, err := server.opt.Signer.Verify(data)
+	switch {
+	case errors.Is(err, protocol.ErrReplay):
+		log.Printf("Drop frame from peer %d: %v\n", p.id, err)
+	case err != nil:
+		server.rejectFrame(p, data, err)
+	}
+	return verified, err
+}
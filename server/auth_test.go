@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rpc/auth"
+	"rpc/client"
+	"rpc/protocol"
+	"rpc/server"
+	"rpc/transport"
+)
+
+// Caller 返回已认证的调用方
+type Caller struct{}
+
+func (Caller) Subject(ctx context.Context, args struct{}, reply *string) error {
+	if p := auth.FromContext(ctx); p != nil {
+		*reply = p.Subject
+	}
+	return nil
+}
+
+// newAuthServer 创建只接受令牌 secret（调用方alice）的服务端
+func newAuthServer(t *testing.T, transportType transport.TransportType) *server.Server {
+	t.Helper()
+	opt := *server.DefaultOption
+	opt.TransportType = transportType
+	opt.Authenticator = auth.StaticTokens(map[string]string{"secret": "alice"})
+	s := server.NewServerWithOption(&opt)
+	s.Register(Caller{})
+	s.Register(&Arith{})
+	return s
+}
+
+func TestAuthentication(t *testing.T) {
+	for _, transportType := range []transport.TransportType{transport.TCP, transport.HTTP} {
+		s := newAuthServer(t, transportType)
+		addr := freeAddr(t)
+		go s.Serve(addr)
+		waitListening(t, addr)
+
+		call := func(creds auth.Credentials, method string) (string, error) {
+			opt := *client.DefaultOption
+			opt.TransportType = transportType
+			opt.Credentials = creds
+			c := client.NewClient(addr, &opt)
+			defer c.Close()
+			var subject string
+			err := c.Call(method, struct{}{}, &subject)
+			return subject, err
+		}
+
+		if subject, err := call(auth.Bearer("secret"), "Caller.Subject"); err != nil || subject != "alice" {
+			t.Errorf("transport %d: authenticated call = %q, %v", transportType, subject, err)
+		}
+		tests := []struct {
+			name   string
+			creds  auth.Credentials
+			method string
+		}{
+			{"no credentials", nil, "Caller.Subject"},
+			{"wrong token", auth.Bearer("wrong"), "Caller.Subject"},
+			// 未认证时不暴露方法是否存在
+			{"unknown method", nil, "Caller.Missing"},
+		}
+		for _, tt := range tests {
+			if _, err := call(tt.creds, tt.method); protocol.CodeOf(err) != protocol.Unauthenticated {
+				t.Errorf("transport %d, %s: %v, want Unauthenticated", transportType, tt.name, err)
+			}
+		}
+		s.Close()
+	}
+}
+
+func TestGatewayAuthentication(t *testing.T) {
+	ts := httptest.NewServer(newAuthServer(t, transport.HTTP).Gateway())
+	defer ts.Close()
+
+	tests := []struct {
+		path, token string
+		status      int
+	}{
+		{"/Arith/Add", "secret", http.StatusOK},
+		{"/Arith/Missing", "secret", http.StatusNotFound},
+		{"/Arith/Add", "", http.StatusUnauthorized},
+		{"/Arith/Add", "wrong", http.StatusUnauthorized},
+		// 先认证再查找方法，未认证的调用方不能探测有哪些服务和方法
+		{"/Arith/Missing", "", http.StatusUnauthorized},
+		{"/Nope/Add", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+tt.path, strings.NewReader(`{"A":1,"B":2}`))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s with token %q: status %d, want %d", tt.path, tt.token, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s with token %q: missing WWW-Authenticate header", tt.path, tt.token)
+		}
+	}
+}
+
+func TestJSONRPCAuthentication(t *testing.T) {
+	ts := httptest.NewServer(newAuthServer(t, transport.HTTP).JSONRPC())
+	defer ts.Close()
+
+	post := func(token, body string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var sb strings.Builder
+		buf := make([]byte, 4096)
+		for {
+			n, err := resp.Body.Read(buf)
+			sb.Write(buf[:n])
+			if err != nil {
+				break
+			}
+		}
+		return resp.StatusCode, sb.String()
+	}
+
+	if status, body := post("secret", `{"jsonrpc":"2.0","method":"Caller.Subject","id":1}`); status != http.StatusOK || !strings.Contains(body, `"result":"alice"`) {
+		t.Errorf("authenticated call: %d %s", status, body)
+	}
+	if status, body := post("wrong", `{"jsonrpc":"2.0","method":"Caller.Subject","id":1}`); status != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d %s", status, body)
+	}
+	if _, body := post("", `{"jsonrpc":"2.0","method":"Caller.Missing","id":1}`); !strings.Contains(body, "Unauthenticated") {
+		t.Errorf("unknown method without credentials: %s, want Unauthenticated", body)
+	}
+}
+
+func TestFrameSigning(t *testing.T) {
+	opt := *server.DefaultOption
+	opt.Signer = protocol.NewSigner("k1", []byte("secret"))
+	s := server.NewServerWithOption(&opt)
+	s.Register(&Arith{})
+	addr := serve(t, s)
+	defer s.Close()
+
+	call := func(signer *protocol.Signer) (int, error) {
+		copt := *client.DefaultOption
+		copt.Signer = signer
+		copt.Timeout = 500 * time.Millisecond
+		c := client.NewClient(addr, &copt)
+		defer c.Close()
+		var sum int
+		err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum)
+		return sum, err
+	}
+
+	if sum, err := call(protocol.NewSigner("k1", []byte("secret"))); err != nil || sum != 3 {
+		t.Errorf("signed call = %d, %v", sum, err)
+	}
+	if _, err := call(nil); protocol.CodeOf(err) != protocol.Unauthenticated {
+		t.Errorf("unsigned call: %v, want Unauthenticated", err)
+	}
+	// 服务端的错误响应同样无法通过验证，客户端丢弃后调用超时
+	if _, err := call(protocol.NewSigner("k1", []byte("wrong"))); err == nil {
+		t.Error("call signed with the wrong key succeeded")
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"rpc/protocol"
+)
+
+// DefaultBatchParallelism 并行执行的批量请求中默认同时执行的条目数
+const DefaultBatchParallelism = 8
+
+// handleBatch 处理批量请求帧，逐条（或并行）执行后在一个响应帧中返回所有结果
+func (server *Server) handleBatch(ctx context.Context, frame *protocol.Frame) []byte {
+	flags, entries, err := protocol.DecodeBatch(frame.Payload)
+	if err != nil {
+		log.Printf("Decode batch error: %v\n", err)
+		return server.errorResponse(frame.Header, err)
+	}
+
+	results := make([][]byte, len(entries))
+	if flags&protocol.BatchParallel != 0 {
+		// 最多BatchParallelism个goroutine依次领取条目执行，不随批量大小增加goroutine
+		var (
+			wg   sync.WaitGroup
+			next atomic.Int64
+		)
+		for range min(server.opt.BatchParallelism, len(entries)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := int(next.Add(1)) - 1; i < len(entries); i = int(next.Add(1)) - 1 {
+					results[i] = server.handleBatchEntry(ctx, frame.Header, entries[i])
+				}
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i, entry := range entries {
+			results[i] = server.handleBatchEntry(ctx, frame.Header, entry)
+		}
+	}
+
+	return server.response(frame.Header, protocol.BatchResponse, protocol.EncodeBatch(0, results))
+}
+
+// dispatchBatch 并行执行连接上读取的批量请求：每个条目作为单独的请求交给工作池，与其他请求一样受工作协程数、
+// 等待队列、减载和内存预算的限制，同时执行的条目不超过BatchParallelism；被拒绝的条目返回错误响应，不影响其他条目
+// 不等待条目完成，全部完成后以批量响应帧调用reply
+func (server *Server) dispatchBatch(ctx context.Context, header *protocol.Header, entries [][]byte, connMem *atomic.Int64, maxResponse int, reply func([]byte)) {
+	if len(entries) == 0 {
+		reply(server.response(header, protocol.BatchResponse, protocol.EncodeBatch(0, nil)))
+		return
+	}
+
+	results := make([][]byte, len(entries))
+	var next, pending atomic.Int64
+	pending.Store(int64(len(entries)))
+
+	// done 记录条目的结果，返回是否为最后一个完成的条目
+	done := func(i int, resp []byte) bool {
+		results[i] = resp
+		if pending.Add(-1) > 0 {
+			return false
+		}
+		reply(server.limitResponse(header, server.response(header, protocol.BatchResponse, protocol.EncodeBatch(0, results)), maxResponse))
+		return true
+	}
+
+	// start 提交下一个尚未执行的条目，条目被拒绝或无效时直接记录结果并继续提交
+	var start func()
+	start = func() {
+		for {
+			i := int(next.Add(1)) - 1
+			if i >= len(entries) {
+				return
+			}
+			entry, err := server.batchEntry(header, entries[i])
+			if err != nil {
+				if done(i, server.errorResponse(entry.Header, err)) {
+					return
+				}
+				continue
+			}
+
+			priority := server.priority(ctx, entry.ServiceName+"."+entry.MethodName)
+			err = server.dispatch(priority, connMem, int64(len(entries[i])), func(err error) {
+				var resp []byte
+				if err != nil {
+					resp = server.errorResponse(entry.Header, err)
+				} else {
+					resp = server.handleRequest(ctx, entry)
+				}
+				if !done(i, resp) {
+					start()
+				}
+			})
+			if err == nil {
+				return
+			}
+			log.Printf("Reject batch entry %s.%s: %v\n", entry.ServiceName, entry.MethodName, err)
+			if done(i, server.errorResponse(entry.Header, err)) {
+				return
+			}
+		}
+	}
+
+	for range min(server.opt.BatchParallelism, len(entries)) {
+		start()
+	}
+}
+
+// batchEntry 解析批量中的单个条目，条目无效时返回的帧只有消息头（无法解析时为批量请求的消息头）
+func (server *Server) batchEntry(batchHeader *protocol.Header, data []byte) (*protocol.Frame, error) {
+	entry, err := protocol.DecodeFrame(data)
+	if err != nil {
+		return &protocol.Frame{Header: batchHeader}, err
+	}
+	if entry.Header.MessageType != protocol.Request {
+		return entry, protocol.Errorf(protocol.InvalidArgument, "invalid batch entry: unexpected message type %d", entry.Header.MessageType)
+	}
+	return entry, nil
+}
+
+// handleBatchEntry 执行批量中的单个条目，条目本身是一个完整的请求帧
+func (server *Server) handleBatchEntry(ctx context.Context, batchHeader *protocol.Header, data []byte) []byte {
+	entry, err := server.batchEntry(batchHeader, data)
+	if err != nil {
+		return server.errorResponse(entry.Header, err)
+	}
+	return server.handleRequest(ctx, entry)
+}
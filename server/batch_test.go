@@ -0,0 +1,93 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/server"
+	"rpc/transport"
+)
+
+func newBatchClient(t *testing.T, arith *Arith) *client.Client {
+	t.Helper()
+	s := server.NewServer(transport.TCP, codec.JSON)
+	if err := s.Register(arith); err != nil {
+		t.Fatal(err)
+	}
+	c := client.NewClient(serve(t, s), nil)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestBatchResultsInOrder(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		c := newBatchClient(t, &Arith{})
+
+		batch := c.NewBatch()
+		batch.Parallel = parallel
+		replies := make([]int, 20)
+		for i := range replies {
+			batch.Add("Arith.Add", Args{A: i, B: 100}, &replies[i])
+		}
+		if err := batch.Do(); err != nil {
+			t.Fatalf("parallel=%v: %v", parallel, err)
+		}
+		for i, call := range batch.Calls {
+			if call.Error != nil {
+				t.Fatalf("parallel=%v: entry %d: %v", parallel, i, call.Error)
+			}
+			if replies[i] != i+100 {
+				t.Errorf("parallel=%v: entry %d = %d, want %d", parallel, i, replies[i], i+100)
+			}
+		}
+	}
+}
+
+func TestBatchEntryErrors(t *testing.T) {
+	c := newBatchClient(t, &Arith{})
+
+	var sum, failed, missing int
+	batch := c.NewBatch()
+	batch.Add("Arith.Add", Args{A: 1, B: 2}, &sum)
+	batch.Add("Arith.Fail", Args{}, &failed)
+	batch.Add("Arith.Missing", Args{}, &missing)
+	if err := batch.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	if batch.Calls[0].Error != nil || sum != 3 {
+		t.Errorf("Add = %d, %v; want 3, nil", sum, batch.Calls[0].Error)
+	}
+	if err := batch.Calls[1].Error; err == nil || err.Error() != "boom" {
+		t.Errorf("Fail error = %v, want boom", err)
+	}
+	if batch.Calls[2].Error == nil {
+		t.Error("call to missing method succeeded")
+	}
+}
+
+func TestBatchParallelismBounded(t *testing.T) {
+	arith := &Arith{delay: 20 * time.Millisecond}
+	c := newBatchClient(t, arith)
+
+	batch := c.NewBatch()
+	batch.Parallel = true
+	replies := make([]int, 4*server.DefaultBatchParallelism)
+	for i := range replies {
+		batch.Add("Arith.Slow", Args{A: i}, &replies[i])
+	}
+	if err := batch.Do(); err != nil {
+		t.Fatal(err)
+	}
+	for i, call := range batch.Calls {
+		if call.Error != nil || replies[i] != i {
+			t.Fatalf("entry %d = %d, %v", i, replies[i], call.Error)
+		}
+	}
+
+	if peak := arith.Peak(); peak < 2 || peak > server.DefaultBatchParallelism {
+		t.Errorf("peak concurrency = %d, want between 2 and %d", peak, server.DefaultBatchParallelism)
+	}
+}
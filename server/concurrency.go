@@ -0,0 +1,218 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"rpc/protocol"
+)
+
+// ConcurrencyLimits 服务端并发限制，超出限制的请求立即返回Unavailable错误（减载），而不是无限制地占用goroutine和内存
+type ConcurrencyLimits struct {
+	MaxInFlight     int            // 所有入口（连接、网关、JSON-RPC）同时执行和排队的请求数上限，0表示不限制
+	Methods         map[string]int // 按方法同时执行的请求数上限，键为 "Service.Method" 或 "Service.*"（整个服务共享），前者优先
+	Workers         int            // 执行请求的工作协程数，所有入口（连接、网关、JSON-RPC及其批量中的每个请求）共用，随服务器创建并一直运行；0表示每个请求一个goroutine（仍受MaxInFlight限制）；服务方法回调客户端且客户端在回调中再调用服务端时，工作协程数需留有余量
+	QueueSize       int            // 等待空闲工作协程的请求数上限，队列满时拒绝新请求；仅在Workers或CriticalWorkers大于0时有效
+	CriticalWorkers int            // 为关键请求保留的工作协程数，关键请求只在这些协程上排队执行，不占用Workers、QueueSize和MaxInFlight；0表示关键请求与其他请求一样计入这些上限（但不会被减载）
+}
+
+// ErrOverloaded 服务端正在处理的请求过多，请求被拒绝
+var ErrOverloaded error = protocol.Errorf(protocol.Unavailable, "server overloaded")
+
+// concurrency 并发限制的状态
+type concurrency struct {
+	maxInFlight int64
+	inFlight    atomic.Int64 // 已接受、正在执行或排队的请求数，不包括保留给关键请求的
+
+	methodLimits map[string]int64         // 方法 -> 上限
+	methods      map[string]*atomic.Int64 // 方法 -> 正在执行的请求数
+
+	pool     *pool // 普通和可丢弃请求的工作池，nil表示每个请求一个goroutine
+	critical *pool // 关键请求的保留工作池，nil表示关键请求与其他请求共用上限
+}
+
+// pool 固定数量的工作协程和有界的等待队列
+type pool struct {
+	workers  int64
+	capacity int64        // 工作协程数加队列长度
+	tasks    chan *task   // 交给工作协程的请求，容量为capacity
+	queued   atomic.Int64 // 已接受、正在执行或排队的请求数
+	shed     bool         // 取出请求时是否按排队时间减载
+}
+
+// newPool 创建工作池，workers不大于0时返回nil
+func newPool(workers, queueSize int, shed bool) *pool {
+	if workers <= 0 {
+		return nil
+	}
+	capacity := int64(workers + max(queueSize, 0))
+	return &pool{workers: int64(workers), capacity: capacity, tasks: make(chan *task, capacity), shed: shed}
+}
+
+// reserve 为请求预留位置，工作协程和队列都已满时返回false
+func (p *pool) reserve() bool {
+	if p.queued.Add(1) > p.capacity {
+		p.queued.Add(-1)
+		return false
+	}
+	return true
+}
+
+// task 交给工作协程执行的请求
+type task struct {
+	priority Priority
+	queuedAt time.Time       // 进入队列的时间
+	run      func(err error) // 执行请求，err非nil时请求因排队过久被丢弃，只需返回该错误
+}
+
+// newConcurrency 根据配置创建并发限制，没有任何限制时返回nil
+func newConcurrency(limits *ConcurrencyLimits) *concurrency {
+	if limits == nil {
+		return nil
+	}
+	c := &concurrency{
+		maxInFlight:  int64(limits.MaxInFlight),
+		methodLimits: make(map[string]int64),
+		methods:      make(map[string]*atomic.Int64),
+		pool:         newPool(limits.Workers, limits.QueueSize, true),
+		critical:     newPool(limits.CriticalWorkers, limits.QueueSize, false),
+	}
+	for method, limit := range limits.Methods {
+		if limit > 0 {
+			c.methodLimits[method] = int64(limit)
+			c.methods[method] = new(atomic.Int64)
+		}
+	}
+	if c.maxInFlight <= 0 && len(c.methods) == 0 && c.pool == nil && c.critical == nil {
+		return nil
+	}
+	return c
+}
+
+// startWorkers 启动工作协程
+func (server *Server) startWorkers() {
+	c := server.concurrency
+	if c == nil {
+		return
+	}
+	for _, p := range []*pool{c.pool, c.critical} {
+		if p == nil {
+			continue
+		}
+		for i := int64(0); i < p.workers; i++ {
+			go server.work(p)
+		}
+	}
+}
+
+// work 工作协程：依次执行队列中的请求，取出请求时按排队时间决定是否丢弃
+func (server *Server) work(p *pool) {
+	for t := range p.tasks {
+		var err error
+		if p.shed {
+			err = server.shedder.shed(t.priority, time.Since(t.queuedAt))
+		}
+		t.run(err)
+	}
+}
+
+// dispatch 所有入口执行请求的统一路径：检查并发上限、在工作池中为请求预留位置并预留内存预算，再交给工作协程执行
+// 未配置工作池时在新的goroutine中执行；connMem为请求所在连接的内存计数，nil表示只计入全局预算
+// 正在减载、超过并发上限、工作池和队列都已满或超过内存预算时返回错误，run不会被调用；否则run在执行时调用，参数非nil表示请求在执行前被丢弃
+func (server *Server) dispatch(priority Priority, connMem *atomic.Int64, size int64, run func(err error)) error {
+	p, release, err := server.enqueue(priority)
+	if err != nil {
+		return err
+	}
+	if err := server.reserveMemory(connMem, size); err != nil {
+		release()
+		return err
+	}
+
+	t := &task{priority: priority, queuedAt: time.Now(), run: func(err error) {
+		defer release()
+		defer server.releaseMemory(connMem, size)
+		run(err)
+	}}
+	if p != nil {
+		// enqueue已保证队列中的请求数不超过容量，不会阻塞
+		p.tasks <- t
+		return nil
+	}
+	go t.run(nil)
+	return nil
+}
+
+// execute 通过dispatch执行请求并等待完成，用于同步处理的HTTP请求
+func (server *Server) execute(priority Priority, size int64, fn func() error) error {
+	done := make(chan error, 1)
+	err := server.dispatch(priority, nil, size, func(err error) {
+		if err == nil {
+			err = fn()
+		}
+		done <- err
+	})
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+// enqueue 为请求预留全局并发名额和工作池中的位置，返回执行请求的工作池（nil表示在新的goroutine中执行）和请求结束后的释放函数
+// 关键请求在配置了保留工作池时只占用保留的位置；正在减载时拒绝可丢弃的请求，超过上限或工作池已满时返回ErrOverloaded
+func (server *Server) enqueue(priority Priority) (*pool, func(), error) {
+	c := server.concurrency
+	if c == nil {
+		return nil, func() {}, nil
+	}
+
+	if priority == Critical && c.critical != nil {
+		if !c.critical.reserve() {
+			return nil, nil, ErrOverloaded
+		}
+		return c.critical, func() { c.critical.queued.Add(-1) }, nil
+	}
+
+	p := c.pool
+	if priority == Sheddable && p != nil && server.shedder.shedding(p.queued.Load() >= p.workers) {
+		return nil, nil, ErrOverloaded
+	}
+	global := c.maxInFlight > 0
+	if global && c.inFlight.Add(1) > c.maxInFlight {
+		c.inFlight.Add(-1)
+		return nil, nil, ErrOverloaded
+	}
+	if p != nil && !p.reserve() {
+		if global {
+			c.inFlight.Add(-1)
+		}
+		return nil, nil, ErrOverloaded
+	}
+
+	return p, func() {
+		if p != nil {
+			p.queued.Add(-1)
+		}
+		if global {
+			c.inFlight.Add(-1)
+		}
+	}, nil
+}
+
+// admit 检查方法的并发上限，通过时返回执行完毕后调用的释放函数
+func (server *Server) admit(serviceMethod string) (func(), error) {
+	c := server.concurrency
+	if c == nil {
+		return func() {}, nil
+	}
+
+	key, ok := methodKey(c.methods, serviceMethod)
+	if !ok {
+		return func() {}, nil
+	}
+	if c.methods[key].Add(1) > c.methodLimits[key] {
+		c.methods[key].Add(-1)
+		return nil, protocol.Errorf(protocol.Unavailable, "too many concurrent requests for %s", serviceMethod)
+	}
+	return func() { c.methods[key].Add(-1) }, nil
+}
@@ -0,0 +1,227 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/protocol"
+	"rpc/server"
+)
+
+// newLimitedServer 创建带并发限制的服务端，Arith.Slow每次调用耗时delay
+func newLimitedServer(t *testing.T, limits *server.ConcurrencyLimits, delay time.Duration) (*server.Server, *Arith) {
+	return newSheddingServer(t, limits, nil, delay)
+}
+
+// newSheddingServer 创建带并发限制和减载配置的服务端
+func newSheddingServer(t *testing.T, limits *server.ConcurrencyLimits, shedding *server.LoadShedding, delay time.Duration) (*server.Server, *Arith) {
+	t.Helper()
+	opt := *server.DefaultOption
+	opt.Concurrency = limits
+	opt.LoadShedding = shedding
+	s := server.NewServerWithOption(&opt)
+	arith := &Arith{delay: delay}
+	if err := s.Register(arith); err != nil {
+		t.Fatal(err)
+	}
+	return s, arith
+}
+
+// goSlow 在后台调用Arith.Slow，并等待其开始执行
+func goSlow(t *testing.T, c *client.Client, arith *Arith) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		var sum int
+		done <- c.Call("Arith.Slow", Args{A: 1, B: 2}, &sum)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for arith.Peak() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Arith.Slow did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return done
+}
+
+func TestMaxInFlight(t *testing.T) {
+	s, arith := newLimitedServer(t, &server.ConcurrencyLimits{MaxInFlight: 1}, 200*time.Millisecond)
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	done := goSlow(t, c, arith)
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); protocol.CodeOf(err) != protocol.Unavailable {
+		t.Fatalf("call over MaxInFlight: %v, want Unavailable", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("call after the slow one finished = %d, %v", sum, err)
+	}
+}
+
+func TestMethodConcurrency(t *testing.T) {
+	limits := &server.ConcurrencyLimits{Methods: map[string]int{"Arith.Slow": 1}}
+	s, arith := newLimitedServer(t, limits, 200*time.Millisecond)
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	done := goSlow(t, c, arith)
+	var sum int
+	if err := c.Call("Arith.Slow", Args{A: 1, B: 2}, &sum); protocol.CodeOf(err) != protocol.Unavailable {
+		t.Fatalf("second Arith.Slow: %v, want Unavailable", err)
+	}
+	// 其他方法不受该方法的上限影响
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil {
+		t.Fatalf("Arith.Add: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkerPoolOverload(t *testing.T) {
+	limits := &server.ConcurrencyLimits{Workers: 1, QueueSize: 1}
+	s, arith := newLimitedServer(t, limits, 200*time.Millisecond)
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	running := goSlow(t, c, arith)
+	queued := make(chan error, 1)
+	go func() {
+		var sum int
+		queued <- c.Call("Arith.Slow", Args{A: 1, B: 2}, &sum)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	var sum int
+	err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum)
+	if err == nil || !strings.Contains(err.Error(), server.ErrOverloaded.Error()) {
+		t.Fatalf("call with a full queue: %v, want %v", err, server.ErrOverloaded)
+	}
+	if err := <-running; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-queued; err != nil {
+		t.Fatalf("queued call: %v", err)
+	}
+	if peak := arith.Peak(); peak != 1 {
+		t.Errorf("peak concurrency = %d, want 1 with a single worker", peak)
+	}
+}
+
+func TestHTTPEntryPointsUseWorkerPool(t *testing.T) {
+	s, arith := newLimitedServer(t, &server.ConcurrencyLimits{Workers: 1}, 200*time.Millisecond)
+	gw := httptest.NewServer(s.Gateway())
+	defer gw.Close()
+	rpc := httptest.NewServer(s.JSONRPC())
+	defer rpc.Close()
+
+	done := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(gw.URL+"/Arith/Slow", "application/json", strings.NewReader(`{"A":1,"B":2}`))
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	deadline := time.Now().Add(time.Second)
+	for arith.Peak() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Arith.Slow did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	status, body := gatewayCall(t, "POST", gw.URL+"/Arith/Add", `{"A":1,"B":2}`)
+	if status != http.StatusServiceUnavailable || body["code"] != "Unavailable" {
+		t.Errorf("gateway call with a busy worker: %d %v, want 503 Unavailable", status, body)
+	}
+
+	resp, err := http.Post(rpc.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":2},"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply jsonrpcReply
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil || reply.Error.Data["code"] != "Unavailable" {
+		t.Errorf("JSON-RPC call with a busy worker: %+v, want Unavailable", reply.Error)
+	}
+
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("slow gateway call: status %d", status)
+	}
+}
+
+func TestBatchEntriesUseWorkerPool(t *testing.T) {
+	limits := &server.ConcurrencyLimits{Workers: 1, QueueSize: 1}
+	s, arith := newLimitedServer(t, limits, 50*time.Millisecond)
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	batch := c.NewBatch()
+	batch.Parallel = true
+	replies := make([]int, 4)
+	for i := range replies {
+		batch.Add("Arith.Slow", Args{A: i}, &replies[i])
+	}
+	if err := batch.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 一个工作协程加一个队列位置，其余条目被拒绝，不影响已接受的条目
+	for i, call := range batch.Calls {
+		if i < 2 {
+			if call.Error != nil || replies[i] != i {
+				t.Errorf("entry %d = %d, %v", i, replies[i], call.Error)
+			}
+			continue
+		}
+		if protocol.CodeOf(call.Error) != protocol.Unavailable {
+			t.Errorf("entry %d: %v, want Unavailable", i, call.Error)
+		}
+	}
+	if peak := arith.Peak(); peak != 1 {
+		t.Errorf("peak concurrency = %d, want 1 with a single worker", peak)
+	}
+}
+
+func TestCriticalWorkers(t *testing.T) {
+	limits := &server.ConcurrencyLimits{Workers: 1, CriticalWorkers: 1}
+	shedding := &server.LoadShedding{Priorities: map[string]server.Priority{"Arith.Add": server.Critical}}
+	s, arith := newSheddingServer(t, limits, shedding, 200*time.Millisecond)
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	done := goSlow(t, c, arith)
+	// 普通请求的工作协程已被占用，关键请求仍在保留的工作协程上执行
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("critical call = %d, %v", sum, err)
+	}
+	if err := c.Call("Arith.Fail", Args{}, &sum); protocol.CodeOf(err) != protocol.Unavailable {
+		t.Errorf("normal call with a busy worker: %v, want Unavailable", err)
+	}
+	// 元数据不能把请求提升为关键
+	md := map[string]string{protocol.MetadataPriority: "critical"}
+	if err := c.CallWithMetadata("Arith.Fail", md, Args{}, &sum); protocol.CodeOf(err) != protocol.Unavailable {
+		t.Errorf("untrusted critical call with a busy worker: %v, want Unavailable", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"rpc/protocol"
+)
+
+// Gateway 返回HTTP/JSON网关，以 .../{Service}/{Method} 的形式调用已注册的服务方法：
+// POST以JSON请求体作为参数，GET把查询参数绑定到结构体参数的字段；
+// 结果以JSON返回，错误按错误码映射为HTTP状态码，响应体为 {"error": ..., "code": ...}
+// HTTP传输的服务端已在 HTTPPath 下自动挂载网关，也可以把它挂载到其他http.ServeMux
+func (server *Server) Gateway() http.Handler {
+	return http.HandlerFunc(server.serveGateway)
+}
+
+// serveGateway 处理一个网关请求
+func (server *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	// 路径的最后两段为服务名和方法名
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 {
+		writeGatewayError(w, protocol.Errorf(protocol.NotFound, "expected path .../{Service}/{Method}"))
+		return
+	}
+	serviceName, methodName := parts[len(parts)-2], parts[len(parts)-1]
+
+	// 先认证再查找方法，未认证的调用方不能探测有哪些服务和方法
+	ctx, cancel := server.httpContext(r)
+	defer cancel()
+	ctx, err := server.authenticateHTTP(withRemoteAddr(ctx, r.RemoteAddr), r)
+	if err == nil {
+		err = server.checkAuth(ctx)
+	}
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+
+	service, mtype, err := server.lookup(serviceName, methodName)
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	if err := server.rateLimit(ctx, serviceName+"."+methodName); err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+
+	argv := reflect.New(mtype.ArgType)
+	var size int64
+	switch r.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(server.opt.MaxRequestSize)))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeJSON(w, http.StatusRequestEntityTooLarge, gatewayError(protocol.Errorf(protocol.ResourceExhausted, "%v", err)))
+				return
+			}
+			writeGatewayError(w, protocol.Errorf(protocol.InvalidArgument, "read body error: %v", err))
+			return
+		}
+		size = int64(len(body))
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, argv.Interface()); err != nil {
+				writeGatewayError(w, protocol.Errorf(protocol.InvalidArgument, "decode argument error: %v", err))
+				return
+			}
+		}
+	case http.MethodGet:
+		if err := bindQuery(argv.Elem(), r.URL.Query()); err != nil {
+			writeGatewayError(w, protocol.Errorf(protocol.InvalidArgument, "%v", err))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, gatewayError(protocol.Errorf(protocol.Unimplemented, "method not allowed: %s", r.Method)))
+		return
+	}
+
+	// 与其他入口一样在工作池中执行，受工作协程数、等待队列和内存预算的限制
+	var replyv reflect.Value
+	err = server.execute(server.priority(ctx, serviceName+"."+methodName), size, func() (err error) {
+		replyv, err = server.invoke(ctx, service, mtype, argv)
+		return err
+	})
+	if err != nil {
+		log.Printf("Call error: %v\n", err)
+		writeGatewayError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, replyv.Interface())
+}
+
+// gatewayError 错误响应体
+func gatewayError(err error) interface{} {
+	return map[string]string{
+		"error": err.Error(),
+		"code":  protocol.CodeOf(err).String(),
+	}
+}
+
+// writeGatewayError 按错误码写入错误响应
+func writeGatewayError(w http.ResponseWriter, err error) {
+	if protocol.CodeOf(err) == protocol.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	if retryAfter := protocol.RetryAfter(err); retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	writeJSON(w, httpStatus(protocol.CodeOf(err)), gatewayError(err))
+}
+
+// writeJSON 写入JSON响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(gatewayError(protocol.Errorf(protocol.Internal, "encode response error: %v", err)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+// httpStatus 错误码对应的HTTP状态码
+func httpStatus(code protocol.Code) int {
+	switch code {
+	case protocol.OK:
+		return http.StatusOK
+	case protocol.Canceled:
+		return 499 // 客户端关闭了请求
+	case protocol.InvalidArgument, protocol.FailedPrecondition, protocol.OutOfRange:
+		return http.StatusBadRequest
+	case protocol.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case protocol.NotFound:
+		return http.StatusNotFound
+	case protocol.AlreadyExists, protocol.Aborted:
+		return http.StatusConflict
+	case protocol.PermissionDenied:
+		return http.StatusForbidden
+	case protocol.Unauthenticated:
+		return http.StatusUnauthorized
+	case protocol.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case protocol.Unimplemented:
+		return http.StatusNotImplemented
+	case protocol.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// bindQuery 将查询参数绑定到结构体参数的字段，字段名不区分大小写，有json标签时使用标签名
+// 切片字段接受重复的参数，未知的参数返回错误
+func bindQuery(v reflect.Value, query url.Values) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		if len(query) == 0 {
+			return nil
+		}
+		return fmt.Errorf("query parameters require a struct argument, got %s; use POST with a JSON body", v.Type())
+	}
+
+	fields := make(map[string]reflect.Value)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			name = tag
+		}
+		fields[strings.ToLower(name)] = v.Field(i)
+	}
+
+	for key, values := range query {
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			return fmt.Errorf("unknown query parameter: %s", key)
+		}
+		if err := setField(field, values); err != nil {
+			return fmt.Errorf("invalid query parameter %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// setField 将查询参数的值写入字段
+func setField(field reflect.Value, values []string) error {
+	switch field.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, s := range values {
+			if err := setScalar(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setScalar(elem.Elem(), values[len(values)-1]); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	default:
+		return setScalar(field, values[len(values)-1])
+	}
+}
+
+// setScalar 解析字符串并写入基本类型的值
+func setScalar(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
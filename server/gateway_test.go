@@ -0,0 +1,91 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/protocol"
+	"rpc/server"
+	"rpc/transport"
+)
+
+// gatewayCall 调用网关，返回状态码和解码后的响应体
+func gatewayCall(t *testing.T, method, url, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var v interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("%s %s: decode response: %v", method, url, err)
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		return resp.StatusCode, m
+	}
+	return resp.StatusCode, map[string]interface{}{"result": v}
+}
+
+func TestGateway(t *testing.T) {
+	s := server.NewServer(transport.HTTP, codec.JSON)
+	if err := s.Register(&Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Gateway())
+	defer ts.Close()
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+		result             float64
+	}{
+		{"POST", "/Arith/Add", `{"A":1,"B":2}`, http.StatusOK, "", 3},
+		{"GET", "/Arith/Add?a=4&B=5", "", http.StatusOK, "", 9},
+		{"GET", "/Arith/Add?c=1", "", http.StatusBadRequest, "InvalidArgument", 0},
+		{"POST", "/Arith/Add", `{"A":`, http.StatusBadRequest, "InvalidArgument", 0},
+		{"POST", "/Arith/Missing", `{}`, http.StatusNotFound, "NotFound", 0},
+		{"POST", "/Nope/Add", `{}`, http.StatusNotFound, "NotFound", 0},
+		{"POST", "/Arith/Fail", `{}`, http.StatusInternalServerError, "Unknown", 0},
+		{"PUT", "/Arith/Add", `{}`, http.StatusMethodNotAllowed, "Unimplemented", 0},
+	}
+	for _, tt := range tests {
+		status, body := gatewayCall(t, tt.method, ts.URL+tt.path, tt.body)
+		if status != tt.status {
+			t.Errorf("%s %s: status %d, want %d (%v)", tt.method, tt.path, status, tt.status, body)
+			continue
+		}
+		if tt.code != "" && body["code"] != tt.code {
+			t.Errorf("%s %s: code %v, want %s", tt.method, tt.path, body["code"], tt.code)
+		}
+		if tt.code == "" && body["result"] != tt.result {
+			t.Errorf("%s %s: result %v, want %v", tt.method, tt.path, body["result"], tt.result)
+		}
+	}
+}
+
+func TestErrorCodeOverRPC(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	s.Register(&Arith{})
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	var reply int
+	if err := c.Call("Arith.Missing", Args{}, &reply); protocol.CodeOf(err) != protocol.NotFound {
+		t.Errorf("unknown method: %v (%v), want NotFound", err, protocol.CodeOf(err))
+	}
+	if err := c.Call("Arith.Fail", Args{}, &reply); protocol.CodeOf(err) != protocol.Unknown || err.Error() != "boom" {
+		t.Errorf("failing method: %v (%v), want boom (Unknown)", err, protocol.CodeOf(err))
+	}
+}
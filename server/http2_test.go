@@ -0,0 +1,47 @@
+package server_test
+
+import (
+	"testing"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/server"
+	"rpc/transport"
+)
+
+func TestHTTP2StreamCallback(t *testing.T) {
+	s := server.NewServer(transport.HTTP2, codec.JSON)
+	s.Register(&Arith{})
+	addr := serve(t, s)
+
+	opt := *client.DefaultOption
+	opt.TransportType = transport.HTTP2Stream
+	c := client.NewClient(addr, &opt)
+	if err := c.Register(Callback{}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 2, B: 3}, &sum); err != nil || sum != 5 {
+		t.Fatalf("Call = %d, %v; want 5", sum, err)
+	}
+
+	// 流是持久连接，服务端可以通过它回调客户端
+	peers := s.Peers()
+	if len(peers) != 1 {
+		t.Fatalf("got %d peers, want 1", len(peers))
+	}
+	var reply string
+	if err := peers[0].Call("Callback.Upper", "stream", &reply); err != nil || reply != "STREAM" {
+		t.Errorf("callback = %q, %v; want STREAM", reply, err)
+	}
+
+	// 一元HTTP/2客户端也可以连接同一个服务端
+	opt.TransportType = transport.HTTP2
+	unary := client.NewClient(addr, &opt)
+	defer unary.Close()
+	if err := unary.Call("Arith.Add", Args{A: 1, B: 1}, &sum); err != nil || sum != 2 {
+		t.Errorf("unary Call = %d, %v; want 2", sum, err)
+	}
+}
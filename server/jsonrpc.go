@@ -0,0 +1,506 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"rpc/auth"
+	"rpc/protocol"
+)
+
+// JSONRPCVersion JSON-RPC协议版本
+const JSONRPCVersion = "2.0"
+
+// JSON-RPC 2.0 规范定义的错误码，服务方法返回的其他错误使用 jsonrpcServerError
+const (
+	jsonrpcParseError     = -32700 // 请求不是合法的JSON
+	jsonrpcInvalidRequest = -32600 // 请求不是合法的请求对象
+	jsonrpcMethodNotFound = -32601 // 方法不存在
+	jsonrpcInvalidParams  = -32602 // 参数错误
+	jsonrpcInternalError  = -32603 // 内部错误
+	jsonrpcServerError    = -32000 // 服务方法返回的错误
+)
+
+// jsonrpcRequest JSON-RPC请求对象，ID为nil（字段不存在）时为通知
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonrpcResponse JSON-RPC响应对象，Result和Error有且只有一个
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonrpcError JSON-RPC错误对象，Data中带有调用错误码
+type jsonrpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// jsonrpcAuthMethod 按行分隔的JSON-RPC连接上的认证方法，params为 {"token": ...}，
+// 在读取循环中按顺序处理，之后的请求以认证得到的身份调用；HTTP上使用Authorization头
+const jsonrpcAuthMethod = "rpc.authenticate"
+
+// nullID 无法确定请求ID时响应中使用的ID
+var nullID = json.RawMessage("null")
+
+// JSONRPC 返回JSON-RPC 2.0的HTTP处理器，每个POST请求体是一个请求对象或批量数组，
+// method为 "Service.Method"，params可以是对象或只有一个元素的数组；只包含通知的请求返回204
+// HTTP传输的服务端已在 transport.DefaultJSONRPCPath 下自动挂载，也可以把它挂载到其他http.ServeMux
+func (server *Server) JSONRPC() http.Handler {
+	return http.HandlerFunc(server.serveJSONRPC)
+}
+
+// serveJSONRPC 处理一个JSON-RPC的HTTP请求
+func (server *Server) serveJSONRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(server.opt.MaxRequestSize)))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, jsonrpcFailure(nullID, jsonrpcInvalidRequest, err.Error(), nil))
+			return
+		}
+		http.Error(w, "read body error", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := server.httpContext(r)
+	defer cancel()
+	ctx, err = server.authenticateHTTP(withRemoteAddr(ctx, r.RemoteAddr), r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, jsonrpcFailure(nullID, jsonrpcServerError, err.Error(), map[string]string{"code": protocol.CodeOf(err).String()}))
+		return
+	}
+
+	respc := make(chan []byte, 1)
+	server.handleJSONRPC(ctx, nil, body, func(resp []byte) { respc <- resp })
+	resp := <-respc
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// ServeJSONRPC 在TCP地址上提供按行分隔的JSON-RPC 2.0服务：每行一个请求对象或批量数组，
+// 每个响应占一行；同一连接上的请求并发处理，响应顺序可能与请求不同，通过id对应
+func (server *Server) ServeJSONRPC(addr string) error {
+	key := "jsonrpc/" + addr
+	listener, err := inheritedListener(key)
+	if listener == nil && err == nil {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	server.mu.Lock()
+	select {
+	case <-server.closed:
+		server.mu.Unlock()
+		listener.Close()
+		return nil
+	default:
+	}
+	server.jsonrpcListener = listener
+	server.jsonrpcKey = key
+	server.mu.Unlock()
+
+	log.Printf("JSON-RPC server listening on %s\n", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("JSON-RPC accept error: %v\n", err)
+			continue
+		}
+
+		go server.serveJSONRPCConn(conn)
+	}
+}
+
+// serveJSONRPCConn 处理一个按行分隔的JSON-RPC连接，对端关闭写入后等待正在处理的请求完成再关闭连接
+func (server *Server) serveJSONRPCConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(withRemoteAddr(context.Background(), conn.RemoteAddr().String()))
+
+	var (
+		wg      sync.WaitGroup
+		writeMu sync.Mutex
+		memUsed atomic.Int64 // 连接上正在处理的请求占用的字节数
+	)
+	defer func() {
+		wg.Wait()
+		cancel()
+		conn.Close()
+	}()
+
+	write := func(resp []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if _, err := conn.Write(append(resp, '\n')); err != nil {
+			log.Printf("Write error: %v\n", err)
+		}
+	}
+
+	var principal *auth.Principal // 连接上已认证的调用方
+	reader := bufio.NewReader(conn)
+	for {
+		line, tooLarge, err := readLine(reader, server.opt.MaxRequestSize)
+		if tooLarge {
+			resp, _ := json.Marshal(jsonrpcFailure(nullID, jsonrpcInvalidRequest, "request too large", nil))
+			write(resp)
+		} else if req, ok := jsonrpcAuthRequest(line); ok {
+			var resp *jsonrpcResponse
+			principal, resp = server.jsonrpcAuthenticate(ctx, req)
+			if resp != nil {
+				out, _ := json.Marshal(resp)
+				write(out)
+			}
+		} else if len(bytes.TrimSpace(line)) > 0 {
+			callCtx := ctx
+			if principal != nil {
+				callCtx = auth.NewContext(ctx, principal)
+			}
+			wg.Add(1)
+			server.handleJSONRPC(callCtx, &memUsed, line, func(resp []byte) {
+				defer wg.Done()
+				if resp != nil {
+					write(resp)
+				}
+			})
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Read error: %v\n", err)
+			}
+			return
+		}
+	}
+}
+
+// jsonrpcAuthRequest 判断一行是否为单个认证请求
+func jsonrpcAuthRequest(line []byte) (*jsonrpcRequest, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' || !bytes.Contains(line, []byte(jsonrpcAuthMethod)) {
+		return nil, false
+	}
+	var req jsonrpcRequest
+	if json.Unmarshal(line, &req) != nil || req.Method != jsonrpcAuthMethod {
+		return nil, false
+	}
+	return &req, true
+}
+
+// jsonrpcAuthParams 认证请求的参数
+type jsonrpcAuthParams struct {
+	Token string `json:"token"`
+}
+
+// jsonrpcAuthenticate 处理认证请求，返回认证得到的调用方（失败时为nil）和响应（通知为nil）
+func (server *Server) jsonrpcAuthenticate(ctx context.Context, req *jsonrpcRequest) (*auth.Principal, *jsonrpcResponse) {
+	argv, err := decodeJSONRPCParams(reflect.TypeOf(jsonrpcAuthParams{}), req.Params)
+	if err != nil {
+		return nil, jsonrpcFailure(req.idOrNull(), jsonrpcInvalidParams, err.Error(), nil)
+	}
+	params := argv.Interface().(*jsonrpcAuthParams)
+
+	var principal *auth.Principal
+	if server.opt.Authenticator != nil {
+		p, err := server.authenticateToken(ctx, params.Token)
+		if err != nil {
+			if req.ID == nil {
+				return nil, nil
+			}
+			return nil, jsonrpcFailure(req.ID, jsonrpcServerError, err.Error(), map[string]string{"code": protocol.CodeOf(err).String()})
+		}
+		principal = p
+	}
+	if req.ID == nil {
+		return principal, nil
+	}
+
+	var subject string
+	if principal != nil {
+		subject = principal.Subject
+	}
+	result, _ := json.Marshal(map[string]string{"subject": subject})
+	return principal, &jsonrpcResponse{JSONRPC: JSONRPCVersion, Result: result, ID: req.ID}
+}
+
+// readLine 读取一行（包括换行符），超过limit字节的行被丢弃并返回tooLarge
+func readLine(r *bufio.Reader, limit int) (line []byte, tooLarge bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			if len(line)+len(chunk) > limit {
+				tooLarge, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return line, tooLarge, err
+		}
+	}
+}
+
+// handleJSONRPC 处理一个请求对象或批量数组，其中的每个请求都交给工作池执行，不等待请求完成
+// 全部完成后以编码后的响应调用reply，没有需要返回的响应（全是通知）时参数为nil；connMem为所在连接的内存计数，nil表示不属于持久连接
+func (server *Server) handleJSONRPC(ctx context.Context, connMem *atomic.Int64, data []byte, reply func([]byte)) {
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		resp, _ := json.Marshal(jsonrpcFailure(nullID, jsonrpcParseError, "parse error", nil))
+		reply(resp)
+		return
+	}
+
+	if data[0] != '[' {
+		server.jsonrpcDispatch(ctx, connMem, data, func(resp *jsonrpcResponse) {
+			if resp == nil {
+				reply(nil)
+				return
+			}
+			out, _ := json.Marshal(resp)
+			reply(out)
+		})
+		return
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(data, &batch)
+	if len(batch) == 0 {
+		resp, _ := json.Marshal(jsonrpcFailure(nullID, jsonrpcInvalidRequest, "empty batch", nil))
+		reply(resp)
+		return
+	}
+
+	// 批量中的请求分别交给工作池并行执行，最后一个完成时返回所有响应
+	results := make([]*jsonrpcResponse, len(batch))
+	var pending atomic.Int64
+	pending.Store(int64(len(batch)))
+	for i, raw := range batch {
+		server.jsonrpcDispatch(ctx, connMem, raw, func(resp *jsonrpcResponse) {
+			results[i] = resp
+			if pending.Add(-1) > 0 {
+				return
+			}
+
+			responses := make([]*jsonrpcResponse, 0, len(results))
+			for _, resp := range results {
+				if resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if len(responses) == 0 {
+				reply(nil)
+				return
+			}
+			out, _ := json.Marshal(responses)
+			reply(out)
+		})
+	}
+}
+
+// jsonrpcDispatch 解析单个请求对象并交给工作池执行，完成后以响应调用done，通知的响应为nil
+// 工作池和队列已满、正在减载或超过内存预算时以错误响应调用done，请求不会执行
+func (server *Server) jsonrpcDispatch(ctx context.Context, connMem *atomic.Int64, raw json.RawMessage, done func(*jsonrpcResponse)) {
+	var req jsonrpcRequest
+	if raw[0] != '{' || json.Unmarshal(raw, &req) != nil {
+		done(jsonrpcFailure(nullID, jsonrpcInvalidRequest, "invalid request", nil))
+		return
+	}
+	if req.ID != nil && !validJSONRPCID(req.ID) {
+		done(jsonrpcFailure(nullID, jsonrpcInvalidRequest, "invalid request id", nil))
+		return
+	}
+	if req.JSONRPC != JSONRPCVersion || req.Method == "" {
+		done(jsonrpcFailure(req.idOrNull(), jsonrpcInvalidRequest, "invalid request", nil))
+		return
+	}
+
+	err := server.dispatch(server.priority(ctx, req.Method), connMem, int64(len(raw)), func(err error) {
+		if err != nil {
+			done(req.failure(err))
+			return
+		}
+		done(server.jsonrpcCall(ctx, &req))
+	})
+	if err != nil {
+		log.Printf("Reject JSON-RPC request %s: %v\n", req.Method, err)
+		done(req.failure(err))
+	}
+}
+
+// jsonrpcCall 执行单个已解析的请求对象，通知返回nil
+func (server *Server) jsonrpcCall(ctx context.Context, req *jsonrpcRequest) *jsonrpcResponse {
+	notification := req.ID == nil
+
+	// 未认证的调用方不能通过方法不存在的错误探测有哪些服务和方法
+	if err := server.checkAuth(ctx); err != nil {
+		if notification {
+			return nil
+		}
+		return jsonrpcFailure(req.ID, jsonrpcServerError, err.Error(), jsonrpcErrorData(err))
+	}
+	service, mtype, err := server.findMethod(req.Method)
+	if err != nil {
+		if notification {
+			return nil
+		}
+		return jsonrpcFailure(req.ID, jsonrpcMethodNotFound, err.Error(), nil)
+	}
+	if err := server.rateLimit(ctx, service.name+"."+mtype.method.Name); err != nil {
+		if notification {
+			return nil
+		}
+		return jsonrpcFailure(req.ID, jsonrpcServerError, err.Error(), jsonrpcErrorData(err))
+	}
+
+	argv, err := decodeJSONRPCParams(mtype.ArgType, req.Params)
+	if err != nil {
+		if notification {
+			return nil
+		}
+		return jsonrpcFailure(req.ID, jsonrpcInvalidParams, err.Error(), nil)
+	}
+
+	replyv, err := server.invoke(ctx, service, mtype, argv)
+	if err != nil {
+		log.Printf("Call error: %v\n", err)
+		if notification {
+			return nil
+		}
+		return jsonrpcFailure(req.ID, jsonrpcErrorCode(err), err.Error(), jsonrpcErrorData(err))
+	}
+	if notification {
+		return nil
+	}
+
+	result, err := json.Marshal(replyv.Interface())
+	if err != nil {
+		return jsonrpcFailure(req.ID, jsonrpcInternalError, "encode response error: "+err.Error(), nil)
+	}
+	return &jsonrpcResponse{JSONRPC: JSONRPCVersion, Result: result, ID: req.ID}
+}
+
+// failure 请求未执行时的错误响应，通知返回nil
+func (req *jsonrpcRequest) failure(err error) *jsonrpcResponse {
+	if req.ID == nil {
+		return nil
+	}
+	return jsonrpcFailure(req.ID, jsonrpcErrorCode(err), err.Error(), jsonrpcErrorData(err))
+}
+
+// idOrNull 返回请求ID，通知返回null
+func (req *jsonrpcRequest) idOrNull() json.RawMessage {
+	if req.ID == nil {
+		return nullID
+	}
+	return req.ID
+}
+
+// validJSONRPCID 请求ID只能是字符串、数字或null
+func validJSONRPCID(id json.RawMessage) bool {
+	switch c := id[0]; {
+	case c == '"', c == '-', c >= '0' && c <= '9':
+		return true
+	default:
+		return bytes.Equal(id, nullID)
+	}
+}
+
+// decodeJSONRPCParams 解析参数：对象直接解析为参数；数组只有一个元素时解析该元素，
+// 参数本身是切片或数组时解析整个数组；没有参数时使用零值
+func decodeJSONRPCParams(argType reflect.Type, params json.RawMessage) (reflect.Value, error) {
+	argv := reflect.New(argType)
+	if len(params) == 0 || bytes.Equal(params, nullID) {
+		return argv, nil
+	}
+
+	switch params[0] {
+	case '{':
+		if err := json.Unmarshal(params, argv.Interface()); err != nil {
+			return argv, err
+		}
+	case '[':
+		if kind := argType.Kind(); kind == reflect.Slice || kind == reflect.Array {
+			if err := json.Unmarshal(params, argv.Interface()); err != nil {
+				return argv, err
+			}
+			return argv, nil
+		}
+		var list []json.RawMessage
+		json.Unmarshal(params, &list)
+		switch len(list) {
+		case 0:
+		case 1:
+			if err := json.Unmarshal(list[0], argv.Interface()); err != nil {
+				return argv, err
+			}
+		default:
+			return argv, errors.New("params array must contain a single argument")
+		}
+	default:
+		return argv, errors.New("params must be an object or an array")
+	}
+	return argv, nil
+}
+
+// jsonrpcErrorCode 服务方法返回的错误对应的JSON-RPC错误码
+func jsonrpcErrorCode(err error) int {
+	switch protocol.CodeOf(err) {
+	case protocol.InvalidArgument:
+		return jsonrpcInvalidParams
+	case protocol.Internal:
+		return jsonrpcInternalError
+	default:
+		return jsonrpcServerError
+	}
+}
+
+// jsonrpcErrorData 错误响应的data：错误码名称，被限流时还有建议的重试等待毫秒数
+func jsonrpcErrorData(err error) map[string]interface{} {
+	data := map[string]interface{}{"code": protocol.CodeOf(err).String()}
+	if retryAfter := protocol.RetryAfter(err); retryAfter > 0 {
+		data["retryAfterMs"] = retryAfter.Milliseconds()
+	}
+	return data
+}
+
+// jsonrpcFailure 构造错误响应
+func jsonrpcFailure(id json.RawMessage, code int, message string, data interface{}) *jsonrpcResponse {
+	return &jsonrpcResponse{
+		JSONRPC: JSONRPCVersion,
+		Error:   &jsonrpcError{Code: code, Message: message, Data: data},
+		ID:      id,
+	}
+}
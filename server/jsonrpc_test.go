@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rpc/codec"
+	"rpc/server"
+	"rpc/transport"
+)
+
+// jsonrpcReply 测试中解码的JSON-RPC响应
+type jsonrpcReply struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    int               `json:"code"`
+		Message string            `json:"message"`
+		Data    map[string]string `json:"data"`
+	} `json:"error"`
+	ID json.RawMessage `json:"id"`
+}
+
+func newJSONRPCServer(t *testing.T) *server.Server {
+	t.Helper()
+	s := server.NewServer(transport.TCP, codec.JSON)
+	if err := s.Register(&Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJSONRPCOverHTTP(t *testing.T) {
+	ts := httptest.NewServer(newJSONRPCServer(t).JSONRPC())
+	defer ts.Close()
+
+	post := func(body string) (int, string) {
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var sb strings.Builder
+		bufio.NewReader(resp.Body).WriteTo(&sb)
+		return resp.StatusCode, sb.String()
+	}
+	decode := func(body string, v interface{}) {
+		t.Helper()
+		if err := json.Unmarshal([]byte(body), v); err != nil {
+			t.Fatalf("decode %q: %v", body, err)
+		}
+	}
+
+	var reply jsonrpcReply
+	_, body := post(`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":2},"id":1}`)
+	decode(body, &reply)
+	if string(reply.Result) != "3" || string(reply.ID) != "1" || reply.Error != nil {
+		t.Errorf("object params: %s", body)
+	}
+
+	reply = jsonrpcReply{}
+	_, body = post(`{"jsonrpc":"2.0","method":"Arith.Add","params":[{"A":2,"B":2}],"id":"a"}`)
+	decode(body, &reply)
+	if string(reply.Result) != "4" || string(reply.ID) != `"a"` {
+		t.Errorf("array params: %s", body)
+	}
+
+	if status, body := post(`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":2}}`); status != http.StatusNoContent {
+		t.Errorf("notification: status %d, body %q; want 204", status, body)
+	}
+
+	reply = jsonrpcReply{}
+	_, body = post(`{"jsonrpc":"2.0","method":"Arith.Missing","id":2}`)
+	decode(body, &reply)
+	if reply.Error == nil || reply.Error.Code != -32601 {
+		t.Errorf("unknown method: %s", body)
+	}
+
+	reply = jsonrpcReply{}
+	_, body = post(`{"jsonrpc":"2.0","method":"Arith.Fail","id":3}`)
+	decode(body, &reply)
+	if reply.Error == nil || reply.Error.Code != -32000 || reply.Error.Message != "boom" || reply.Error.Data["code"] != "Unknown" {
+		t.Errorf("failing method: %s", body)
+	}
+
+	reply = jsonrpcReply{}
+	_, body = post(`{"jsonrpc":`)
+	decode(body, &reply)
+	if reply.Error == nil || reply.Error.Code != -32700 || string(reply.ID) != "null" {
+		t.Errorf("parse error: %s", body)
+	}
+
+	// 批量请求中的通知没有响应，其余响应的顺序与请求相同
+	var batch []jsonrpcReply
+	_, body = post(`[
+		{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":1},"id":1},
+		{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":5,"B":5}},
+		{"jsonrpc":"1.0","method":"Arith.Add","id":2},
+		{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":3,"B":3},"id":3}
+	]`)
+	decode(body, &batch)
+	if len(batch) != 3 || string(batch[0].Result) != "2" || batch[1].Error == nil || batch[1].Error.Code != -32600 || string(batch[2].Result) != "6" {
+		t.Errorf("batch: %s", body)
+	}
+}
+
+func TestJSONRPCOverTCP(t *testing.T) {
+	s := newJSONRPCServer(t)
+	addr := freeAddr(t)
+	go s.ServeJSONRPC(addr)
+
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":2},"id":1}` + "\n"))
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":10,"B":20},"id":2}` + "\n"))
+
+	// 同一连接上的请求并发处理，按id对应响应
+	results := make(map[string]string)
+	reader := bufio.NewReader(conn)
+	for range 2 {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply jsonrpcReply
+		if err := json.Unmarshal(line, &reply); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		results[string(reply.ID)] = string(reply.Result)
+	}
+	if results["1"] != "3" || results["2"] != "30" {
+		t.Errorf("results = %v", results)
+	}
+}
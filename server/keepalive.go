@@ -0,0 +1,81 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"rpc/protocol"
+)
+
+// touch 记录连接上收到了数据
+func (p *Peer) touch() {
+	p.lastRead.Store(time.Now().UnixNano())
+}
+
+// acquire 标记连接正在被使用（处理请求、回调客户端或持有订阅），使用中的连接不会因空闲被关闭
+func (p *Peer) acquire() {
+	p.lastRequest.Store(time.Now().UnixNano())
+	p.busy.Add(1)
+}
+
+// release 释放acquire的标记
+func (p *Peer) release() {
+	p.lastRequest.Store(time.Now().UnixNano())
+	p.busy.Add(-1)
+}
+
+// keepalive 定期检查连接：空闲时发送心跳，心跳无应答或长时间没有请求时关闭连接
+// 关闭连接会使handleConn中阻塞的Read返回，从而释放连接相关的goroutine
+func (server *Server) keepalive(p *Peer) {
+	interval := server.opt.KeepaliveInterval
+	timeout := server.opt.KeepaliveTimeout
+	idleTimeout := server.opt.IdleTimeout
+
+	// 检查周期取心跳间隔和空闲超时一半中的较小者
+	period := interval
+	if idleTimeout > 0 && (period <= 0 || idleTimeout/2 < period) {
+		period = idleTimeout / 2
+	}
+	if period <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Done():
+			return
+		case now := <-ticker.C:
+			sinceRead := now.Sub(time.Unix(0, p.lastRead.Load()))
+			sinceRequest := now.Sub(time.Unix(0, p.lastRequest.Load()))
+
+			if interval > 0 && sinceRead >= interval+timeout {
+				log.Printf("Peer %d keepalive timeout, closing connection\n", p.id)
+				p.conn.Close()
+				return
+			}
+
+			if idleTimeout > 0 && p.busy.Load() == 0 && sinceRequest >= idleTimeout {
+				log.Printf("Peer %d idle for %v, closing connection\n", p.id, sinceRequest)
+				p.conn.Close()
+				return
+			}
+
+			if interval > 0 && sinceRead >= interval {
+				ping := protocol.EncodeFrame(&protocol.Frame{
+					Header: &protocol.Header{
+						MagicNumber:   protocol.MagicNumber,
+						Version:       protocol.Version,
+						MessageType:   protocol.Ping,
+						SerializeType: byte(server.codecType),
+					},
+				})
+				if err := p.write(ping); err != nil {
+					log.Printf("Peer %d keepalive write error: %v\n", p.id, err)
+				}
+			}
+		}
+	}
+}
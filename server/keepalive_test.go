@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/server"
+)
+
+// waitPeers 等待服务端的连接数变为n
+func waitPeers(t *testing.T, s *server.Server, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if len(s.Peers()) == n {
+			return
+		}
+	}
+	t.Fatalf("server has %d peers, want %d", len(s.Peers()), n)
+}
+
+func TestIdleTimeoutClosesConnection(t *testing.T) {
+	opt := *server.DefaultOption
+	opt.KeepaliveInterval = 0
+	opt.IdleTimeout = 100 * time.Millisecond
+	s := server.NewServerWithOption(&opt)
+	s.Register(&Arith{})
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, s, 0)
+
+	// 客户端重新连接后可以继续调用
+	if err := c.Call("Arith.Add", Args{A: 2, B: 3}, &sum); err != nil || sum != 5 {
+		t.Fatalf("Call after idle close = %d, %v", sum, err)
+	}
+}
+
+func TestKeepaliveKeepsIdleConnection(t *testing.T) {
+	opt := *server.DefaultOption
+	opt.KeepaliveInterval = 50 * time.Millisecond
+	opt.KeepaliveTimeout = 100 * time.Millisecond
+	opt.IdleTimeout = 0
+	s := server.NewServerWithOption(&opt)
+	s.Register(&Arith{})
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	peer := s.Peers()[0]
+
+	// 客户端应答心跳，连接在多个心跳周期后仍然存在
+	time.Sleep(400 * time.Millisecond)
+	if peers := s.Peers(); len(peers) != 1 || peers[0] != peer {
+		t.Fatalf("connection was not kept alive: %d peers", len(peers))
+	}
+}
+
+func TestKeepaliveTimeoutClosesSilentConnection(t *testing.T) {
+	opt := *server.DefaultOption
+	opt.KeepaliveInterval = 50 * time.Millisecond
+	opt.KeepaliveTimeout = 50 * time.Millisecond
+	opt.IdleTimeout = 0
+	s := server.NewServerWithOption(&opt)
+	addr := serve(t, s)
+
+	// 不应答心跳的连接
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitPeers(t, s, 1)
+	waitPeers(t, s, 0)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+
+	"rpc/protocol"
+)
+
+// ErrMemoryExhausted 正在处理的请求占用的内存超过预算
+var ErrMemoryExhausted error = protocol.Errorf(protocol.ResourceExhausted, "server memory budget exceeded")
+
+// reserveMemory 为读取到的请求预留内存预算，超过连接或全局预算时返回错误
+// connUsed为请求所在连接的计数，nil表示请求不属于持久连接（如HTTP请求），只计入全局预算
+func (server *Server) reserveMemory(connUsed *atomic.Int64, size int64) error {
+	used := server.memUsed.Add(size)
+	if connUsed != nil {
+		if n := connUsed.Add(size); server.opt.MaxConnMemory > 0 && n > server.opt.MaxConnMemory {
+			server.releaseMemory(connUsed, size)
+			return ErrMemoryExhausted
+		}
+	}
+
+	if server.opt.MaxMemory > 0 && used > server.opt.MaxMemory {
+		server.releaseMemory(connUsed, size)
+		return ErrMemoryExhausted
+	}
+
+	return nil
+}
+
+// releaseMemory 释放请求占用的内存预算
+func (server *Server) releaseMemory(connUsed *atomic.Int64, size int64) {
+	if connUsed != nil {
+		connUsed.Add(-size)
+	}
+	server.memUsed.Add(-size)
+}
+
+// rejectFrame 拒绝无法处理的帧，head为帧的开头部分
+// 请求返回错误响应，回调的响应则让等待中的回调返回错误；无法解析出消息头时只记录日志
+func (server *Server) rejectFrame(p *Peer, head []byte, reason error) {
+	log.Printf("Reject request from peer %d: %v\n", p.id, reason)
+
+	// 没有错误码的原因都是超过了大小或内存限制
+	if protocol.CodeOf(reason) == protocol.Unknown {
+		reason = &protocol.Error{Code: protocol.ResourceExhausted, Message: reason.Error()}
+	}
+
+	header, err := protocol.DecodeHeader(head)
+	if err != nil {
+		return
+	}
+
+	switch header.MessageType {
+	case protocol.Request, protocol.BatchRequest, protocol.Auth:
+		// 认证请求同样返回错误，客户端的认证立即失败而不是等到超时
+		if err := p.write(server.errorResponse(header, reason)); err != nil {
+			log.Printf("Write error: %v\n", err)
+		}
+	case protocol.Response:
+		// 客户端对回调的响应超限，让等待中的回调返回错误
+		if frame, err := protocol.DecodeFrame(server.errorResponse(header, reason)); err == nil {
+			p.deliver(frame)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/protocol"
+	"rpc/server"
+)
+
+// Echo 原样返回参数
+type Echo struct{}
+
+func (Echo) Echo(s string, reply *string) error {
+	*reply = s
+	return nil
+}
+
+func (Echo) Repeat(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+func TestFrameSizeLimits(t *testing.T) {
+	opt := *server.DefaultOption
+	opt.MaxRequestSize = 1024
+	opt.MaxResponseSize = 1024
+	s := server.NewServerWithOption(&opt)
+	s.Register(Echo{})
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	var reply string
+	if err := c.Call("Echo.Echo", strings.Repeat("x", 4096), &reply); err == nil {
+		t.Error("request above MaxRequestSize succeeded")
+	}
+	if err := c.Call("Echo.Repeat", 4096, &reply); err == nil {
+		t.Error("response above MaxResponseSize succeeded")
+	}
+
+	// 超限的请求和响应不影响连接上之后的调用
+	if err := c.Call("Echo.Echo", "ok", &reply); err != nil || reply != "ok" {
+		t.Fatalf("Call after rejected frames = %q, %v", reply, err)
+	}
+}
+
+func TestClientMaxRequestSize(t *testing.T) {
+	s := server.NewServerWithOption(nil)
+	s.Register(Echo{})
+	copt := *client.DefaultOption
+	copt.MaxRequestSize = 256
+	c := client.NewClient(serve(t, s), &copt)
+	defer c.Close()
+
+	var reply string
+	if err := c.Call("Echo.Echo", strings.Repeat("x", 1024), &reply); err == nil {
+		t.Error("request above the client's MaxRequestSize was sent")
+	}
+	if err := c.Call("Echo.Echo", "ok", &reply); err != nil || reply != "ok" {
+		t.Fatalf("Call = %q, %v", reply, err)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	opt := *server.DefaultOption
+	opt.MaxConnMemory = 16
+	s := server.NewServerWithOption(&opt)
+	s.Register(Echo{})
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	var reply string
+	err := c.Call("Echo.Echo", "larger than the connection budget", &reply)
+	if err == nil || !strings.Contains(err.Error(), server.ErrMemoryExhausted.Error()) {
+		t.Fatalf("Call = %v, want %v", err, server.ErrMemoryExhausted)
+	}
+}
+
+// newRateLimitedServer 创建Arith.Add每秒只允许5次（无突发）的服务端
+func newRateLimitedServer(t *testing.T) *server.Server {
+	t.Helper()
+	opt := *server.DefaultOption
+	opt.RateLimits = &server.RateLimits{
+		Methods: map[string]server.RateLimit{"Arith.Add": {Rate: 5, Burst: 1}},
+	}
+	s := server.NewServerWithOption(&opt)
+	s.Register(&Arith{})
+	return s
+}
+
+func TestRateLimit(t *testing.T) {
+	addr := serve(t, newRateLimitedServer(t))
+
+	c := client.NewClient(addr, nil)
+	defer c.Close()
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum)
+	if protocol.CodeOf(err) != protocol.ResourceExhausted {
+		t.Fatalf("call over the limit: %v, want ResourceExhausted", err)
+	}
+	if wait := protocol.RetryAfter(err); wait <= 0 || wait > 200*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want (0, 200ms]", wait)
+	}
+	// 其他方法不受限制
+	if err := c.Call("Arith.Fail", Args{}, &sum); protocol.CodeOf(err) == protocol.ResourceExhausted {
+		t.Errorf("unlimited method was rate limited: %v", err)
+	}
+}
+
+func TestRateLimitRetries(t *testing.T) {
+	addr := serve(t, newRateLimitedServer(t))
+
+	opt := *client.DefaultOption
+	opt.RateLimitRetries = 3
+	c := client.NewClient(addr, &opt)
+	defer c.Close()
+
+	// 客户端按建议的时间等待后重试，连续的调用都成功
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		var sum int
+		if err := c.Call("Arith.Add", Args{A: i, B: 1}, &sum); err != nil || sum != i+1 {
+			t.Fatalf("call %d = %d, %v", i, sum, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("3 calls at 5/s took %v, want at least 300ms", elapsed)
+	}
+
+	// 等待会超过调用超时时不再重试
+	opt.Timeout = 50 * time.Millisecond
+	short := client.NewClient(addr, &opt)
+	defer short.Close()
+	var sum int
+	short.Call("Arith.Add", Args{}, &sum)
+	start = time.Now()
+	err := short.Call("Arith.Add", Args{}, &sum)
+	if protocol.CodeOf(err) != protocol.ResourceExhausted || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("call with a short timeout = %v after %v, want an immediate ResourceExhausted", err, time.Since(start))
+	}
+}
+
+func TestGatewayRetryAfter(t *testing.T) {
+	ts := httptest.NewServer(newRateLimitedServer(t).Gateway())
+	defer ts.Close()
+
+	var resp *http.Response
+	for i := 0; i < 2; i++ {
+		var err error
+		resp, err = http.Post(ts.URL+"/Arith/Add", "application/json", strings.NewReader(`{"A":1,"B":2}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("status %d, Retry-After %q, want 429 and 1", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/server"
+	"rpc/transport"
+)
+
+func TestAddListener(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	arith := &Arith{}
+	s.Register(arith)
+
+	httpAddr := freeAddr(t)
+	if err := s.AddListener(transport.HTTP, httpAddr); err != nil {
+		t.Fatal(err)
+	}
+	// mem:// 地址总是使用进程内传输
+	if err := s.AddListener(transport.TCP, "mem://add-listener"); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := freeAddr(t)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(addr) }()
+	waitListening(t, addr)
+
+	targets := []struct {
+		addr          string
+		transportType transport.TransportType
+	}{
+		{addr, transport.TCP},
+		{httpAddr, transport.HTTP},
+		{"mem://add-listener", transport.TCP},
+	}
+	for _, target := range targets {
+		opt := *client.DefaultOption
+		opt.TransportType = target.transportType
+		c := client.NewClient(target.addr, &opt)
+		var sum int
+		if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil || sum != 3 {
+			t.Errorf("call via %s = %d, %v", target.addr, sum, err)
+		}
+		c.Close()
+	}
+	// 所有监听共享同一组服务
+	if n := arith.calls.Load(); n != int64(len(targets)) {
+		t.Errorf("service saw %d calls, want %d", n, len(targets))
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, server.ErrServerClosed) {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+
+	if err := s.AddListener(transport.TCP, freeAddr(t)); !errors.Is(err, server.ErrServerClosed) {
+		t.Errorf("AddListener after Close = %v, want ErrServerClosed", err)
+	}
+	if err := s.Serve(freeAddr(t)); !errors.Is(err, server.ErrServerClosed) {
+		t.Errorf("Serve after Close = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServeListener(t *testing.T) {
+	for _, transportType := range []transport.TransportType{transport.TCP, transport.HTTP} {
+		s := server.NewServer(transportType, codec.JSON)
+		s.Register(&Arith{})
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := make(chan error, 1)
+		go func() { served <- s.ServeListener(l) }()
+
+		opt := *client.DefaultOption
+		opt.TransportType = transportType
+		c := client.NewClient(l.Addr().String(), &opt)
+		var sum int
+		if err := c.Call("Arith.Add", Args{A: 2, B: 2}, &sum); err != nil || sum != 4 {
+			t.Errorf("transport %d: Call = %d, %v", transportType, sum, err)
+		}
+		c.Close()
+
+		s.Close()
+		if err := <-served; !errors.Is(err, server.ErrServerClosed) {
+			t.Errorf("transport %d: ServeListener returned %v, want ErrServerClosed", transportType, err)
+		}
+	}
+
+	// 数据报传输不能在流式监听器上提供服务
+	s := server.NewServer(transport.UDP, codec.JSON)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := s.ServeListener(l); err == nil {
+		t.Error("ServeListener succeeded for the UDP transport")
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	s.Register(&Arith{})
+
+	// 调用方自己接受连接，再逐个交给服务端
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.ServeConn(conn)
+		}
+	}()
+
+	c := client.NewClient(l.Addr().String(), nil)
+	defer c.Close()
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 3, B: 4}, &sum); err != nil || sum != 7 {
+		t.Errorf("Call = %d, %v; want 7", sum, err)
+	}
+}
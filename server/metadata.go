@@ -0,0 +1,21 @@
+package server
+
+import "context"
+
+// metadataContextKey 在context中保存请求元数据的键
+type metadataContextKey struct{}
+
+// withMetadata 返回携带请求元数据的context
+func withMetadata(ctx context.Context, md map[string]string) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, metadataContextKey{}, md)
+}
+
+// MetadataFromContext 从服务方法收到的context中获取客户端随请求发送的元数据，没有时返回nil
+// 返回的map不可修改
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataContextKey{}).(map[string]string)
+	return md
+}
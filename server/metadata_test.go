@@ -0,0 +1,51 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/server"
+	"rpc/transport"
+)
+
+// Meta 返回请求元数据的测试服务
+type Meta struct{}
+
+func (Meta) Get(ctx context.Context, key string, reply *string) error {
+	*reply = server.MetadataFromContext(ctx)[key]
+	return nil
+}
+
+func TestMetadataFromContext(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	if err := s.Register(Meta{}); err != nil {
+		t.Fatal(err)
+	}
+	opt := *client.DefaultOption
+	opt.Metadata = map[string]string{"tenant": "a", "region": "eu"}
+	c := client.NewClient(serve(t, s), &opt)
+	defer c.Close()
+
+	var reply string
+	if err := c.Call("Meta.Get", "tenant", &reply); err != nil || reply != "a" {
+		t.Fatalf("Option.Metadata: %q, %v", reply, err)
+	}
+	// 调用时的元数据与Option.Metadata合并，同名的键以调用时为准
+	md := map[string]string{"tenant": "b"}
+	if err := c.CallWithMetadata("Meta.Get", md, "tenant", &reply); err != nil || reply != "b" {
+		t.Fatalf("CallWithMetadata: %q, %v", reply, err)
+	}
+	if err := c.CallWithMetadata("Meta.Get", md, "region", &reply); err != nil || reply != "eu" {
+		t.Fatalf("merged metadata: %q, %v", reply, err)
+	}
+
+	// 批量调用的条目同样携带元数据
+	reply = ""
+	batch := c.NewBatch()
+	batch.Add("Meta.Get", "region", &reply)
+	if err := batch.Do(); err != nil || batch.Calls[0].Error != nil || reply != "eu" {
+		t.Fatalf("batch: %q, %v, %v", reply, err, batch.Calls[0].Error)
+	}
+}
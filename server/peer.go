@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rpc/auth"
+	"rpc/protocol"
+	"rpc/transport"
+)
+
+// ErrPeerClosed 客户端连接已关闭
+var ErrPeerClosed = errors.New("peer connection closed")
+
+// DefaultCallbackTimeout Peer.Call等待客户端响应的默认最长时间
+const DefaultCallbackTimeout = 30 * time.Second
+
+// peerContextKey 在context中保存Peer的键
+type peerContextKey struct{}
+
+// PeerFromContext 从服务方法收到的context中获取发起调用的客户端
+func PeerFromContext(ctx context.Context) *Peer {
+	p, _ := ctx.Value(peerContextKey{}).(*Peer)
+	return p
+}
+
+// Peer 表示一个已连接的客户端，服务端可以通过它调用客户端注册的服务
+type Peer struct {
+	id      uint64
+	server  *Server
+	conn    transport.Conn
+	tls     *tls.ConnectionState            // TLS连接状态，未使用TLS时为nil
+	addr    net.Addr                        // 对端地址，传输层不提供时为nil
+	ctx     context.Context                 // 连接级context，连接关闭时取消
+	cancel  context.CancelFunc              // 取消ctx
+	sending sync.Mutex                      // 保证帧写入的完整性
+	mu      sync.Mutex                      // 保护以下字段
+	seq     uint64                          // 最近使用的请求序号
+	pending map[uint64]chan *protocol.Frame // 等待响应的回调
+	closed  bool                            // 连接是否已关闭
+
+	lastRead    atomic.Int64 // 最近一次收到数据的时间（UnixNano）
+	lastRequest atomic.Int64 // 最近一次收到请求的时间（UnixNano）
+	busy        atomic.Int32 // 正在使用连接的请求、回调和订阅数
+	requests    atomic.Int32 // 正在处理的请求数
+	goaway      atomic.Bool  // 已通知客户端服务端即将关闭
+	memUsed     atomic.Int64 // 正在处理的请求占用的字节数
+
+	principal atomic.Pointer[auth.Principal] // 连接上已认证的调用方
+	authErr   error                          // 连接自带的令牌（如HTTP请求头）认证失败的原因，此时拒绝连接上的请求
+}
+
+// ID 返回客户端编号，在服务器生命周期内唯一
+func (p *Peer) ID() uint64 {
+	return p.id
+}
+
+// TLS 返回连接的TLS状态，未使用TLS时返回nil
+func (p *Peer) TLS() *tls.ConnectionState {
+	return p.tls
+}
+
+// Identity 返回双向TLS中已验证的客户端身份：证书的CommonName，为空时取第一个DNS或URI名称
+// 客户端未提供证书或证书未经验证时返回空字符串
+func (p *Peer) Identity() string {
+	if p.tls == nil || len(p.tls.VerifiedChains) == 0 || len(p.tls.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := p.tls.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// RemoteAddr 返回客户端的网络地址，传输层不提供（如进程内传输）时返回nil
+func (p *Peer) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+// Done 返回一个在连接关闭时关闭的通道
+func (p *Peer) Done() <-chan struct{} {
+	return p.ctx.Done()
+}
+
+// Call 调用客户端注册的服务方法，阻塞直到客户端响应、连接关闭或超过Option.CallbackTimeout
+func (p *Peer) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	ctx := context.Background()
+	if timeout := p.server.opt.CallbackTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return p.CallContext(ctx, serviceMethod, args, reply)
+}
+
+// CallContext 调用客户端注册的服务方法，阻塞直到客户端响应、连接关闭或ctx结束
+// ctx结束时返回DeadlineExceeded或Canceled错误，之后到达的响应被丢弃
+func (p *Peer) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	// 分割服务名和方法名
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return errors.New("service/method request ill-formed: " + serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+
+	// 序列化参数
+	argBytes, err := p.server.serializer.Encode(args)
+	if err != nil {
+		return fmt.Errorf("encode arguments error: %v", err)
+	}
+
+	p.acquire()
+	defer p.release()
+
+	// 登记等待中的回调
+	ch := make(chan *protocol.Frame, 1)
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPeerClosed
+	}
+	p.seq++
+	seq := p.seq
+	p.pending[seq] = ch
+	p.mu.Unlock()
+
+	reqData := protocol.EncodeFrame(&protocol.Frame{
+		Header: &protocol.Header{
+			MagicNumber:   protocol.MagicNumber,
+			Version:       protocol.Version,
+			MessageType:   protocol.Request,
+			SerializeType: byte(p.server.codecType),
+			Seq:           seq,
+		},
+		ServiceName: serviceName,
+		MethodName:  methodName,
+		Payload:     argBytes,
+	})
+
+	if err := p.write(reqData); err != nil {
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+		return fmt.Errorf("send request error: %v", err)
+	}
+
+	var resp *protocol.Frame
+	select {
+	case frame, ok := <-ch:
+		if !ok {
+			return ErrPeerClosed
+		}
+		resp = frame
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+		if ctx.Err() == context.DeadlineExceeded {
+			return protocol.Errorf(protocol.DeadlineExceeded, "callback %s timed out", serviceMethod)
+		}
+		return protocol.Errorf(protocol.Canceled, "callback %s canceled", serviceMethod)
+	}
+
+	// 解码响应
+	var response protocol.ResponseMessage
+	if err := p.server.serializer.Decode(resp.Payload, &response); err != nil {
+		return fmt.Errorf("decode response error: %v", err)
+	}
+
+	if err := response.Err(); err != nil {
+		return err
+	}
+
+	// 将结果解码到reply中
+	if response.Result != nil {
+		resultBytes, err := p.server.serializer.Encode(response.Result)
+		if err != nil {
+			return fmt.Errorf("encode result error: %v", err)
+		}
+		return p.server.serializer.Decode(resultBytes, reply)
+	}
+
+	return nil
+}
+
+// write 向连接写入一个完整的帧，配置了签名时先签名
+func (p *Peer) write(data []byte) error {
+	if signer := p.server.opt.Signer; signer != nil {
+		var err error
+		if data, err = signer.Sign(data); err != nil {
+			return err
+		}
+	}
+	p.sending.Lock()
+	defer p.sending.Unlock()
+	return p.conn.Write(data)
+}
+
+// deliver 将客户端的响应交给等待中的回调
+func (p *Peer) deliver(frame *protocol.Frame) {
+	p.mu.Lock()
+	ch, ok := p.pending[frame.Header.Seq]
+	delete(p.pending, frame.Header.Seq)
+	p.mu.Unlock()
+
+	if ok {
+		ch <- frame
+	}
+}
+
+// close 标记连接关闭，唤醒所有等待中的回调
+func (p *Peer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.cancel()
+	for seq, ch := range p.pending {
+		close(ch)
+		delete(p.pending, seq)
+	}
+}
+
+// Peers 返回当前所有已连接的客户端，按编号排序
+func (server *Server) Peers() []*Peer {
+	server.peerMu.Lock()
+	peers := make([]*Peer, 0, len(server.peers))
+	for _, p := range server.peers {
+		peers = append(peers, p)
+	}
+	server.peerMu.Unlock()
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].id < peers[j].id })
+	return peers
+}
+
+// Peer 根据编号查找已连接的客户端
+func (server *Server) Peer(id uint64) *Peer {
+	server.peerMu.Lock()
+	defer server.peerMu.Unlock()
+	return server.peers[id]
+}
+
+// addPeer 登记新连接
+func (server *Server) addPeer(conn transport.Conn, tlsState *tls.ConnectionState) *Peer {
+	server.peerMu.Lock()
+	defer server.peerMu.Unlock()
+
+	server.nextPeerID++
+	p := &Peer{
+		id:      server.nextPeerID,
+		server:  server,
+		conn:    conn,
+		tls:     tlsState,
+		pending: make(map[uint64]chan *protocol.Frame),
+	}
+	// HTTP等只承载单个请求的连接，请求超时或客户端断开时取消ctx
+	base := context.Background()
+	if cc, ok := conn.(transport.ContextConn); ok {
+		base = cc.Context()
+	}
+	if ac, ok := conn.(transport.AddrConn); ok {
+		p.addr = ac.RemoteAddr()
+	}
+	p.ctx, p.cancel = context.WithCancel(context.WithValue(base, peerContextKey{}, p))
+	now := time.Now().UnixNano()
+	p.lastRead.Store(now)
+	p.lastRequest.Store(now)
+	server.peers[p.id] = p
+	return p
+}
+
+// removePeer 注销连接并关闭
+func (server *Server) removePeer(p *Peer) {
+	server.peerMu.Lock()
+	delete(server.peers, p.id)
+	server.peerMu.Unlock()
+
+	p.close()
+	p.conn.Close()
+}
@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/protocol"
+	"rpc/server"
+	"rpc/transport"
+)
+
+// Callback 客户端注册的服务，供服务端回调
+type Callback struct{}
+
+func (Callback) Upper(s string, reply *string) error {
+	*reply = strings.ToUpper(s)
+	return nil
+}
+
+func (Callback) Block(d time.Duration, reply *string) error {
+	time.Sleep(d)
+	return nil
+}
+
+// Whoami 通过context返回调用方连接的编号
+type Whoami struct{}
+
+func (Whoami) ID(ctx context.Context, args struct{}, reply *uint64) error {
+	p := server.PeerFromContext(ctx)
+	if p == nil {
+		return errors.New("no peer in context")
+	}
+	*reply = p.ID()
+	return nil
+}
+
+// connectPeer 启动服务端并连接一个注册了Callback的客户端，返回服务端看到的Peer
+func connectPeer(t *testing.T) (*server.Server, *client.Client, *server.Peer) {
+	t.Helper()
+	s := server.NewServer(transport.TCP, codec.JSON)
+	s.Register(&Arith{})
+	s.Register(Whoami{})
+	c := client.NewClient(serve(t, s), nil)
+	if err := c.Register(Callback{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 2}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	peers := s.Peers()
+	if len(peers) != 1 {
+		t.Fatalf("got %d peers, want 1", len(peers))
+	}
+	return s, c, peers[0]
+}
+
+func TestPeerCall(t *testing.T) {
+	s, _, peer := connectPeer(t)
+
+	var reply string
+	if err := peer.Call("Callback.Upper", "hello", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "HELLO" {
+		t.Errorf("reply = %q, want HELLO", reply)
+	}
+	if s.Peer(peer.ID()) != peer {
+		t.Error("Peer(id) did not return the connected peer")
+	}
+	if err := peer.Call("Callback.Missing", "x", &reply); err == nil {
+		t.Error("call to missing client method succeeded")
+	}
+}
+
+func TestPeerCallContextTimeout(t *testing.T) {
+	_, _, peer := connectPeer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var reply string
+	if err := peer.CallContext(ctx, "Callback.Block", time.Second, &reply); protocol.CodeOf(err) != protocol.DeadlineExceeded {
+		t.Fatalf("blocked callback = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("CallContext returned after %v, want about 50ms", elapsed)
+	}
+
+	// 超时的回调不影响之后的调用
+	if err := peer.Call("Callback.Upper", "again", &reply); err != nil || reply != "AGAIN" {
+		t.Errorf("Call after timeout = %q, %v", reply, err)
+	}
+}
+
+func TestPeerClosed(t *testing.T) {
+	_, c, peer := connectPeer(t)
+	c.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var reply string
+	err := peer.Call("Callback.Upper", "x", &reply)
+	for !errors.Is(err, server.ErrPeerClosed) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		err = peer.Call("Callback.Upper", "x", &reply)
+	}
+	if !errors.Is(err, server.ErrPeerClosed) {
+		t.Errorf("Call on closed peer = %v, want ErrPeerClosed", err)
+	}
+}
+
+func TestPeerFromContext(t *testing.T) {
+	_, c, peer := connectPeer(t)
+
+	var id uint64
+	if err := c.Call("Whoami.ID", struct{}{}, &id); err != nil {
+		t.Fatal(err)
+	}
+	if id != peer.ID() {
+		t.Errorf("peer from context = %d, want %d", id, peer.ID())
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"rpc/protocol"
+)
+
+const (
+	DefaultSubscriberBuffer    = 64   // 订阅者默认缓冲区大小
+	DefaultMaxSubscriberBuffer = 4096 // 客户端可请求的订阅缓冲区大小的默认上限
+)
+
+// subscriber 一个连接对某个主题的订阅
+type subscriber struct {
+	peer   *Peer
+	topic  string
+	policy protocol.OverflowPolicy
+	queue  chan []byte   // 待投递的消息
+	done   chan struct{} // 取消订阅时关闭
+	once   sync.Once
+}
+
+// enqueue 按订阅的溢出策略将消息放入缓冲区，返回消息是否被接收
+func (sub *subscriber) enqueue(data []byte) bool {
+	select {
+	case <-sub.done:
+		return false
+	default:
+	}
+
+	switch sub.policy {
+	case protocol.Block:
+		select {
+		case sub.queue <- data:
+			return true
+		case <-sub.done:
+			return false
+		}
+	case protocol.DropOldest:
+		for {
+			select {
+			case sub.queue <- data:
+				return true
+			default:
+				// 缓冲区已满，丢弃最旧的一条后重试
+				select {
+				case <-sub.queue:
+				default:
+				}
+			}
+		}
+	default:
+		select {
+		case sub.queue <- data:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// pubsub 内置的发布订阅服务，以protocol.PubSubService为名注册
+type pubsub struct {
+	server *Server
+	mu     sync.RWMutex
+	topics map[string]map[uint64]*subscriber // 主题 -> 客户端编号 -> 订阅
+}
+
+// newPubSub 创建发布订阅服务
+func newPubSub(server *Server) *pubsub {
+	return &pubsub{
+		server: server,
+		topics: make(map[string]map[uint64]*subscriber),
+	}
+}
+
+// Subscribe 订阅主题，消息通过调用方所在连接回调投递
+func (ps *pubsub) Subscribe(ctx context.Context, args protocol.SubscribeArgs, reply *struct{}) error {
+	peer := PeerFromContext(ctx)
+	if peer == nil {
+		return protocol.Errorf(protocol.FailedPrecondition, "subscribe requires a client connection")
+	}
+	if args.Topic == "" {
+		return protocol.Errorf(protocol.InvalidArgument, "topic is empty")
+	}
+
+	size := args.BufferSize
+	if size <= 0 {
+		size = DefaultSubscriberBuffer
+	}
+	// 缓冲区由客户端指定，需限制大小，避免一个请求占用大量内存
+	if size > ps.server.opt.MaxSubscriberBuffer {
+		return protocol.Errorf(protocol.InvalidArgument, "buffer size %d exceeds limit of %d", size, ps.server.opt.MaxSubscriberBuffer)
+	}
+
+	sub := &subscriber{
+		peer:   peer,
+		topic:  args.Topic,
+		policy: args.Policy,
+		queue:  make(chan []byte, size),
+		done:   make(chan struct{}),
+	}
+
+	ps.mu.Lock()
+	subs := ps.topics[args.Topic]
+	if subs == nil {
+		subs = make(map[uint64]*subscriber)
+		ps.topics[args.Topic] = subs
+	}
+	if _, exists := subs[peer.id]; exists {
+		ps.mu.Unlock()
+		return protocol.Errorf(protocol.AlreadyExists, "topic already subscribed: %s", args.Topic)
+	}
+	subs[peer.id] = sub
+	ps.mu.Unlock()
+
+	// 持有订阅的连接不会因空闲被关闭
+	peer.acquire()
+	go ps.deliver(sub)
+	return nil
+}
+
+// Unsubscribe 取消订阅主题
+func (ps *pubsub) Unsubscribe(ctx context.Context, args protocol.SubscribeArgs, reply *struct{}) error {
+	peer := PeerFromContext(ctx)
+	if peer == nil {
+		return protocol.Errorf(protocol.FailedPrecondition, "unsubscribe requires a client connection")
+	}
+
+	ps.mu.RLock()
+	sub := ps.topics[args.Topic][peer.id]
+	ps.mu.RUnlock()
+
+	if sub == nil {
+		return protocol.Errorf(protocol.NotFound, "topic not subscribed: %s", args.Topic)
+	}
+
+	ps.remove(sub)
+	return nil
+}
+
+// Publish 发布消息到主题
+func (ps *pubsub) Publish(args protocol.PublishArgs, reply *protocol.PublishReply) error {
+	if args.Topic == "" {
+		return protocol.Errorf(protocol.InvalidArgument, "topic is empty")
+	}
+	reply.Delivered = ps.publish(args.Topic, args.Data)
+	return nil
+}
+
+// publish 将消息分发给主题的所有订阅者
+func (ps *pubsub) publish(topic string, data []byte) int {
+	ps.mu.RLock()
+	subs := make([]*subscriber, 0, len(ps.topics[topic]))
+	for _, sub := range ps.topics[topic] {
+		subs = append(subs, sub)
+	}
+	ps.mu.RUnlock()
+
+	delivered := 0
+	for _, sub := range subs {
+		if sub.enqueue(data) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// deliver 按顺序将订阅者缓冲区中的消息回调给客户端，连接关闭或取消订阅时退出
+func (ps *pubsub) deliver(sub *subscriber) {
+	defer ps.remove(sub)
+
+	for {
+		select {
+		case data := <-sub.queue:
+			msg := &protocol.TopicMessage{Topic: sub.topic, Data: data}
+			err := sub.peer.Call(protocol.PubSubDeliver, msg, &struct{}{})
+			if err == ErrPeerClosed {
+				return
+			}
+			if err != nil {
+				log.Printf("Deliver topic %s to peer %d error: %v\n", sub.topic, sub.peer.id, err)
+			}
+		case <-sub.done:
+			return
+		case <-sub.peer.Done():
+			return
+		}
+	}
+}
+
+// remove 移除订阅
+func (ps *pubsub) remove(sub *subscriber) {
+	ps.mu.Lock()
+	if subs := ps.topics[sub.topic]; subs[sub.peer.id] == sub {
+		delete(subs, sub.peer.id)
+		if len(subs) == 0 {
+			delete(ps.topics, sub.topic)
+		}
+	}
+	ps.mu.Unlock()
+
+	sub.once.Do(func() {
+		close(sub.done)
+		sub.peer.release()
+	})
+}
+
+// Publish 从服务端向主题发布消息，返回成功放入缓冲区的订阅者数量
+func (server *Server) Publish(topic string, value interface{}) (int, error) {
+	data, err := server.serializer.Encode(value)
+	if err != nil {
+		return 0, fmt.Errorf("encode message error: %v", err)
+	}
+	return server.pubsub.publish(topic, data), nil
+}
@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/protocol"
+	"rpc/server"
+	"rpc/transport"
+)
+
+// subscribe 订阅主题，返回接收消息的通道
+func subscribe(t *testing.T, c *client.Client, topic string, opt *client.SubscribeOption) <-chan string {
+	t.Helper()
+	ch := make(chan string, 16)
+	err := c.Subscribe(topic, func(msg *client.Message) {
+		var s string
+		if err := msg.Decode(&s); err != nil {
+			t.Errorf("decode message: %v", err)
+		}
+		ch <- s
+	}, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func receive(t *testing.T, ch <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Errorf("received %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	addr := serve(t, s)
+	subscriber := client.NewClient(addr, nil)
+	publisher := client.NewClient(addr, nil)
+	defer subscriber.Close()
+	defer publisher.Close()
+
+	ch := subscribe(t, subscriber, "news", nil)
+
+	if n, err := s.Publish("news", "from server"); err != nil || n != 1 {
+		t.Fatalf("server Publish = %d, %v; want 1, nil", n, err)
+	}
+	receive(t, ch, "from server")
+
+	if n, err := publisher.Publish("news", "from client"); err != nil || n != 1 {
+		t.Fatalf("client Publish = %d, %v; want 1, nil", n, err)
+	}
+	receive(t, ch, "from client")
+
+	if n, err := s.Publish("other", "ignored"); err != nil || n != 0 {
+		t.Errorf("Publish to topic without subscribers = %d, %v", n, err)
+	}
+	if err := subscriber.Subscribe("news", func(*client.Message) {}, nil); err == nil {
+		t.Error("subscribing twice to the same topic succeeded")
+	}
+
+	if err := subscriber.Unsubscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Publish("news", "after unsubscribe"); err != nil || n != 0 {
+		t.Errorf("Publish after Unsubscribe = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestSubscribeBufferLimit(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	c := client.NewClient(serve(t, s), nil)
+	defer c.Close()
+
+	err := c.Subscribe("big", func(*client.Message) {}, &client.SubscribeOption{BufferSize: server.DefaultMaxSubscriberBuffer + 1})
+	if protocol.CodeOf(err) != protocol.InvalidArgument {
+		t.Fatalf("subscribing with a buffer above the limit = %v, want InvalidArgument", err)
+	}
+	subscribe(t, c, "big", &client.SubscribeOption{BufferSize: server.DefaultMaxSubscriberBuffer})
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"rpc/auth"
+	"rpc/protocol"
+)
+
+// RateLimit 令牌桶限流：每秒补充Rate个令牌，桶中最多积累Burst个，每个请求消耗一个
+type RateLimit struct {
+	Rate  float64 // 每秒允许的请求数，0表示不限制
+	Burst int     // 允许的突发请求数，0表示取Rate（至少为1）
+}
+
+// RateLimits 服务端限流配置，在解码参数之前检查，超过限制的请求返回带重试等待时间的ResourceExhausted错误
+type RateLimits struct {
+	Global  RateLimit            // 所有客户端的全部请求
+	Methods map[string]RateLimit // 按方法限流，键为 "Service.Method" 或 "Service.*"，前者优先
+	Client  RateLimit            // 每个客户端的请求：按认证的调用方标识、TLS证书身份或客户端IP区分
+}
+
+// clientBucketIdle 客户端的令牌桶补满后保留的时间，超过后回收
+const clientBucketIdle = time.Minute
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64   // 每秒补充的令牌数
+	burst  float64   // 桶容量
+	tokens float64   // 当前令牌数
+	last   time.Time // 上次补充的时间
+}
+
+// newTokenBucket 创建装满令牌的令牌桶
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take 取一个令牌，令牌不足时返回还需等待的时间
+func (b *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 并发的调用方可能带着更早的now到达，只向前推进补充时间
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), false
+}
+
+// refund 归还一个令牌，用于后续的限流检查未通过时
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// full 判断令牌桶在now时是否已补满
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+max(now.Sub(b.last), 0).Seconds()*b.rate >= b.burst
+}
+
+// rateLimiter 按RateLimits限流
+type rateLimiter struct {
+	global  *tokenBucket
+	methods map[string]*tokenBucket
+	client  RateLimit
+
+	mu        sync.Mutex
+	clients   map[string]*tokenBucket // 客户端标识 -> 令牌桶
+	lastSweep time.Time               // 最近一次回收空闲令牌桶的时间
+}
+
+// newRateLimiter 根据配置创建限流器，没有任何限制时返回nil
+func newRateLimiter(limits *RateLimits) *rateLimiter {
+	if limits == nil {
+		return nil
+	}
+	l := &rateLimiter{
+		methods:   make(map[string]*tokenBucket),
+		client:    limits.Client,
+		clients:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	if limits.Global.Rate > 0 {
+		l.global = newTokenBucket(limits.Global)
+	}
+	for method, limit := range limits.Methods {
+		if limit.Rate > 0 {
+			l.methods[method] = newTokenBucket(limit)
+		}
+	}
+	if l.global == nil && len(l.methods) == 0 && l.client.Rate <= 0 {
+		return nil
+	}
+	return l
+}
+
+// allow 依次检查客户端、方法和全局的限制，任一不通过时归还已取的令牌并返回ResourceExhausted错误
+func (l *rateLimiter) allow(client, serviceMethod string) error {
+	now := time.Now()
+
+	var buckets []*tokenBucket
+	if l.client.Rate > 0 {
+		buckets = append(buckets, l.clientBucket(client, now))
+	}
+	if b := l.methodBucket(serviceMethod); b != nil {
+		buckets = append(buckets, b)
+	}
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+
+	for i, b := range buckets {
+		wait, ok := b.take(now)
+		if ok {
+			continue
+		}
+		for _, taken := range buckets[:i] {
+			taken.refund()
+		}
+		return &protocol.Error{
+			Code:       protocol.ResourceExhausted,
+			Message:    fmt.Sprintf("rate limit exceeded for %s, retry after %v", serviceMethod, wait.Round(time.Millisecond)),
+			RetryAfter: wait,
+		}
+	}
+	return nil
+}
+
+// methodBucket 返回方法对应的令牌桶，没有按方法限流时返回nil
+func (l *rateLimiter) methodBucket(serviceMethod string) *tokenBucket {
+	if key, ok := methodKey(l.methods, serviceMethod); ok {
+		return l.methods[key]
+	}
+	return nil
+}
+
+// methodKey 在按方法配置的表中查找方法对应的键，先按 "Service.Method" 再按 "Service.*" 查找
+func methodKey[V any](m map[string]V, serviceMethod string) (string, bool) {
+	if _, ok := m[serviceMethod]; ok {
+		return serviceMethod, true
+	}
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		key := serviceMethod[:dot] + ".*"
+		if _, ok := m[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// clientBucket 返回客户端的令牌桶，并定期回收已补满且空闲的令牌桶
+func (l *rateLimiter) clientBucket(client string, now time.Time) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > clientBucketIdle {
+		for key, b := range l.clients {
+			if b.full(now.Add(-clientBucketIdle)) {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = newTokenBucket(l.client)
+		l.clients[client] = b
+	}
+	return b
+}
+
+// remoteAddrKey 在context中保存不经过Peer的请求（网关、JSON-RPC）的客户端地址
+type remoteAddrKey struct{}
+
+// withRemoteAddr 返回携带客户端地址的context
+func withRemoteAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, remoteAddrKey{}, addr)
+}
+
+// clientKey 返回限流时区分客户端的标识：认证的调用方标识、TLS证书身份、客户端IP，都没有时使用连接编号
+func clientKey(ctx context.Context) string {
+	if principal := auth.FromContext(ctx); principal != nil && principal.Subject != "" {
+		return "subject:" + principal.Subject
+	}
+	if p := PeerFromContext(ctx); p != nil {
+		if id := p.Identity(); id != "" {
+			return "tls:" + id
+		}
+		if ip := addrIP(p.addr); ip != "" {
+			return "ip:" + ip
+		}
+		return fmt.Sprintf("peer:%d", p.id)
+	}
+	if addr, ok := ctx.Value(remoteAddrKey{}).(string); ok {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return "ip:" + host
+		}
+		return "ip:" + addr
+	}
+	return ""
+}
+
+// addrIP 返回网络地址中的IP，Unix域套接字等没有IP的地址返回空字符串
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return ""
+}
+
+// rateLimit 配置了限流时检查请求是否超过限制
+func (server *Server) rateLimit(ctx context.Context, serviceMethod string) error {
+	if server.limiter == nil {
+		return nil
+	}
+	return server.limiter.allow(clientKey(ctx), serviceMethod)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"rpc/auth"
+	"rpc/protocol"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 2})
+	b.last = start
+
+	for i := 0; i < 2; i++ {
+		if _, ok := b.take(start); !ok {
+			t.Fatalf("take %d within burst failed", i)
+		}
+	}
+	wait, ok := b.take(start)
+	if ok || wait != 100*time.Millisecond {
+		t.Fatalf("take beyond burst = %v, %v, want wait 100ms", wait, ok)
+	}
+
+	if _, ok := b.take(start.Add(100 * time.Millisecond)); !ok {
+		t.Error("token not refilled after 1/rate")
+	}
+	b.refund()
+	if _, ok := b.take(start.Add(100 * time.Millisecond)); !ok {
+		t.Error("refunded token not available")
+	}
+}
+
+func TestTokenBucketStaleNow(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 1})
+	b.last = start
+	b.take(start.Add(time.Second))
+
+	// 带着更早时间的调用不能让补充时间倒退，否则之后会重复补充同一段时间的令牌
+	b.take(start)
+	if !b.last.Equal(start.Add(time.Second)) {
+		t.Fatalf("last moved backwards to %v", b.last.Sub(start))
+	}
+	if _, ok := b.take(start.Add(time.Second + 50*time.Millisecond)); ok {
+		t.Error("bucket refilled time that had already been accounted for")
+	}
+}
+
+func TestTokenBucketConcurrent(t *testing.T) {
+	b := newTokenBucket(RateLimit{Rate: 1, Burst: 100})
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := b.take(time.Now()); ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed < 100 || allowed > 101 {
+		t.Errorf("%d of 200 concurrent takes allowed, want about 100", allowed)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(&RateLimits{}) != nil {
+		t.Error("limiter without limits is not nil")
+	}
+
+	l := newRateLimiter(&RateLimits{
+		Methods: map[string]RateLimit{
+			"Arith.*":   {Rate: 0.001, Burst: 2},
+			"Arith.Add": {Rate: 0.001, Burst: 1},
+		},
+		Client: RateLimit{Rate: 0.001, Burst: 3},
+	})
+
+	// "Service.Method" 优先于 "Service.*"
+	if err := l.allow("a", "Arith.Add"); err != nil {
+		t.Fatal(err)
+	}
+	err := l.allow("a", "Arith.Add")
+	if protocol.CodeOf(err) != protocol.ResourceExhausted || protocol.RetryAfter(err) <= 0 {
+		t.Fatalf("second Arith.Add = %v, want ResourceExhausted with a retry hint", err)
+	}
+
+	// 方法限制未通过时归还客户端的令牌：客户端a还剩两个
+	for i := 0; i < 2; i++ {
+		if err := l.allow("a", "Arith.Mul"); err != nil {
+			t.Fatalf("Arith.Mul %d: %v", i, err)
+		}
+	}
+	if err := l.allow("a", "Echo.Echo"); protocol.CodeOf(err) != protocol.ResourceExhausted {
+		t.Errorf("client a over its limit: %v", err)
+	}
+	if err := l.allow("b", "Echo.Echo"); err != nil {
+		t.Errorf("client b limited by client a: %v", err)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	ctx := withRemoteAddr(context.Background(), "192.0.2.1:5000")
+	if key := clientKey(ctx); key != "ip:192.0.2.1" {
+		t.Errorf("remote address key = %q", key)
+	}
+	ctx = auth.NewContext(ctx, &auth.Principal{Subject: "alice"})
+	if key := clientKey(ctx); key != "subject:alice" {
+		t.Errorf("principal key = %q", key)
+	}
+}
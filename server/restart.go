@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"rpc/transport"
+)
+
+// 热重启时父进程传给新进程的环境变量
+const (
+	envInheritedListeners = "RPC_INHERITED_LISTENERS" // 继承的监听器标识（JSON数组），依次对应从3开始的文件描述符
+	envReadyFD            = "RPC_READY_FD"            // 新进程继承所有监听器后向该描述符写入一个字节
+)
+
+// ErrRestartFailed 新进程未能启动或就绪，本进程继续提供服务
+var ErrRestartFailed = errors.New("rpc: restart failed")
+
+// inherited 从父进程继承的监听器，进程内所有服务器共享
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files map[string]*os.File // 标识 -> 尚未认领的监听套接字
+	ready *os.File            // 通知父进程已就绪的管道
+}
+
+// loadInherited 读取父进程传递的监听器，并清除环境变量，避免再传给本进程启动的子进程
+func loadInherited() {
+	keysEnv, fdEnv := os.Getenv(envInheritedListeners), os.Getenv(envReadyFD)
+	os.Unsetenv(envInheritedListeners)
+	os.Unsetenv(envReadyFD)
+	if keysEnv == "" {
+		return
+	}
+
+	var keys []string
+	if err := json.Unmarshal([]byte(keysEnv), &keys); err != nil {
+		log.Printf("Invalid %s: %v\n", envInheritedListeners, err)
+		return
+	}
+	inherited.files = make(map[string]*os.File, len(keys))
+	for i, key := range keys {
+		inherited.files[key] = os.NewFile(uintptr(3+i), key)
+	}
+	if fd, err := strconv.Atoi(fdEnv); err == nil {
+		inherited.ready = os.NewFile(uintptr(fd), "ready")
+	}
+}
+
+// inheritedFile 认领从父进程继承的监听套接字，没有时返回nil
+// 所有继承的监听器都被认领后通知父进程，父进程随即开始优雅关闭
+func inheritedFile(key string) *os.File {
+	inherited.once.Do(loadInherited)
+
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	f, ok := inherited.files[key]
+	if !ok {
+		return nil
+	}
+	delete(inherited.files, key)
+	if len(inherited.files) == 0 && inherited.ready != nil {
+		inherited.ready.Write([]byte{1})
+		inherited.ready.Close()
+		inherited.ready = nil
+	}
+	return f
+}
+
+// inheritedListener 认领从父进程继承的流式监听器，没有时返回nil
+func inheritedListener(key string) (net.Listener, error) {
+	f := inheritedFile(key)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// listen 在地址上监听；热重启后的新进程继承父进程相同标识的监听器，不重新绑定地址
+func listen(t transport.Transport, key, addr string) error {
+	if udp, ok := t.(*transport.UDPTransport); ok {
+		f := inheritedFile(key)
+		if f == nil {
+			return t.Listen(addr)
+		}
+		defer f.Close()
+		pc, err := net.FilePacketConn(f)
+		if err != nil {
+			return err
+		}
+		conn, ok := pc.(*net.UDPConn)
+		if !ok {
+			pc.Close()
+			return fmt.Errorf("inherited listener %s is not a UDP socket", key)
+		}
+		return udp.ListenOnConn(conn)
+	}
+
+	lt, ok := t.(transport.ListenerTransport)
+	if !ok {
+		return t.Listen(addr)
+	}
+	l, err := inheritedListener(key)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return t.Listen(addr)
+	}
+	// 继承的套接字文件归新进程所有，关闭时删除（再次热重启导出时会取消）
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+	return lt.ListenOn(l)
+}
+
+// Restart 零停机重启：以相同的参数启动新的可执行文件，把监听套接字作为继承的文件描述符传给它，
+// 新进程以相同的传输和地址调用Serve、AddListener和ServeJSONRPC时直接使用这些套接字；
+// 新进程认领全部监听器后，本进程通过Shutdown优雅关闭，ctx同时限制等待新进程和优雅关闭的时间
+// 新进程启动失败或ctx结束前未就绪时，终止新进程并返回包装了ErrRestartFailed的错误，本进程继续提供服务
+func (server *Server) Restart(ctx context.Context) error {
+	keys, files, err := server.listenerFiles()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRestartFailed, err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%w: no listeners to hand off", ErrRestartFailed)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRestartFailed, err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRestartFailed, err)
+	}
+	defer r.Close()
+
+	keysEnv, _ := json.Marshal(keys)
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envInheritedListeners+"=") && !strings.HasPrefix(kv, envReadyFD+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		envInheritedListeners+"="+string(keysEnv),
+		envReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+
+	procFiles := append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...)
+	proc, err := os.StartProcess(exe, os.Args, &os.ProcAttr{Env: env, Files: append(procFiles, w)})
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRestartFailed, err)
+	}
+
+	// 新进程就绪时写入一个字节，退出时管道关闭
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		if n, _ := r.Read(b[:]); n == 1 {
+			ready <- nil
+			return
+		}
+		ready <- fmt.Errorf("%w: new process %d exited before serving", ErrRestartFailed, proc.Pid)
+	}()
+
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = fmt.Errorf("%w: new process %d not ready: %v", ErrRestartFailed, proc.Pid, ctx.Err())
+	}
+	if err != nil {
+		proc.Kill()
+		go proc.Wait()
+		return err
+	}
+	log.Printf("New process %d serving, shutting down\n", proc.Pid)
+	proc.Release()
+	server.handoffListeners()
+
+	return server.Shutdown(ctx)
+}
+
+// handoffListeners 新进程就绪后通知已交接的传输层，之前失败时各传输层保持原样继续服务
+func (server *Server) handoffListeners() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, l := range server.listeners {
+		if ht, ok := l.Transport.(transport.HandoffTransport); ok && l.key != "" {
+			ht.Handoff()
+		}
+	}
+}
+
+// listenerFiles 导出所有需要交接的监听套接字及其标识
+func (server *Server) listenerFiles() ([]string, []*os.File, error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	select {
+	case <-server.closed:
+		return nil, nil, ErrServerClosed
+	default:
+	}
+
+	var (
+		keys  []string
+		files []*os.File
+	)
+	for _, l := range server.listeners {
+		ft, ok := l.Transport.(transport.FileTransport)
+		if !ok || l.key == "" {
+			continue
+		}
+		f, err := ft.File()
+		if err != nil {
+			return keys, files, fmt.Errorf("export listener %s: %v", l.key, err)
+		}
+		keys, files = append(keys, l.key), append(files, f)
+	}
+
+	if tl, ok := server.jsonrpcListener.(*net.TCPListener); ok {
+		f, err := tl.File()
+		if err != nil {
+			return keys, files, fmt.Errorf("export listener %s: %v", server.jsonrpcKey, err)
+		}
+		keys, files = append(keys, server.jsonrpcKey), append(files, f)
+	}
+	return keys, files, nil
+}
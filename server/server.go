@@ -1,23 +1,39 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"rpc/auth"
 	"rpc/codec"
 	"rpc/protocol"
 	"rpc/transport"
 )
 
+// ErrServerClosed 服务器已关闭，Serve在Close后返回该错误
+var ErrServerClosed = errors.New("rpc: server closed")
+
+// typeOfContext context.Context的反射类型
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 // methodType 保存方法的信息
 type methodType struct {
-	method    reflect.Method // 方法本身
-	ArgType   reflect.Type   // 第一个参数类型
-	ReplyType reflect.Type   // 第二个参数类型（返回值）
+	method      reflect.Method // 方法本身
+	ArgType     reflect.Type   // 第一个参数类型
+	ReplyType   reflect.Type   // 第二个参数类型（返回值）
+	withContext bool           // 是否以context.Context作为首个参数
 }
 
 // service 保存服务的信息
@@ -30,24 +46,164 @@ type service struct {
 
 // Server RPC服务器
 type Server struct {
-	mu         sync.RWMutex        // 保护services
-	services   map[string]*service // 注册的服务
-	transport  transport.Transport // 传输层
-	serializer codec.Codec         // 序列化工具
+	mu              sync.RWMutex        // 保护services、listeners和jsonrpcListener
+	services        map[string]*service // 注册的服务
+	transport       transport.Transport // Serve使用的传输层
+	listeners       []listener          // 正在监听的传输层，包括Serve和AddListener的
+	jsonrpcListener net.Listener        // 按行分隔的JSON-RPC监听器
+	jsonrpcKey      string              // JSON-RPC监听器热重启时的标识
+	closed          chan struct{}       // 服务器关闭信号
+	closeOnce       sync.Once
+	opt             Option           // 配置选项
+	codecType       codec.Type       // 编解码类型
+	serializer      codec.Codec      // 序列化工具
+	peerMu          sync.Mutex       // 保护peers
+	peers           map[uint64]*Peer // 已连接的客户端
+	nextPeerID      uint64           // 下一个客户端编号
+	pubsub          *pubsub          // 发布订阅
+	memUsed         atomic.Int64     // 所有连接上正在处理的请求占用的字节数
+	auditMu         sync.Mutex       // 保证审计记录写入的完整性
+	limiter         *rateLimiter     // 请求限流，nil表示不限流
+	concurrency     *concurrency     // 并发限制，nil表示不限制
+	shedder         *shedder         // 自适应减载，nil表示不减载
+}
+
+// listener 正在监听的传输层
+type listener struct {
+	transport.Transport
+	key string // 热重启时交给新进程的标识，由传输类型和地址组成，空表示不交接
+}
+
+// listenerKey 监听器热重启时的标识，新进程以相同的传输类型和地址监听时继承该监听器
+func listenerKey(transportType transport.TransportType, addr string) string {
+	return fmt.Sprintf("%d/%s", transportType, addr)
 }
 
-// NewServer 创建RPC服务器
+// Option 服务端配置选项
+type Option struct {
+	TransportType       transport.TransportType // 传输类型
+	CodecType           codec.Type              // 编解码类型
+	KeepaliveInterval   time.Duration           // 连接空闲多久后发送心跳，0表示不发送
+	KeepaliveTimeout    time.Duration           // 发送心跳后等待对端任意数据的时间，超时则关闭连接
+	IdleTimeout         time.Duration           // 连接上没有请求的最长时间，超过后关闭连接，0表示不限制
+	MaxRequestSize      int                     // 单个请求帧的最大字节数，0表示使用transport.DefaultMaxFrameSize
+	MaxResponseSize     int                     // 单个响应帧的最大字节数，0表示使用transport.DefaultMaxFrameSize
+	MaxConnMemory       int64                   // 单个连接上正在处理的请求最多占用的字节数，0表示不限制
+	MaxMemory           int64                   // 所有连接上正在处理的请求最多占用的字节数，0表示不限制
+	SocketMode          os.FileMode             // Unix域套接字文件的权限，0表示不修改
+	SocketOwner         string                  // Unix域套接字文件的属主（用户名或UID），空表示不修改
+	SocketGroup         string                  // Unix域套接字文件的属组（组名或GID），空表示不修改
+	MaxUDPConns         int                     // UDP传输：同时存在的伪连接（远程地址）数上限，0表示使用transport.DefaultMaxUDPConns
+	TLS                 *transport.TLSOption    // TCP和HTTP传输的TLS配置，nil表示不加密
+	HTTPPath            string                  // HTTP和WebSocket传输的路径，空表示使用transport.DefaultHTTPPath
+	HTTPTimeout         time.Duration           // 处理单个HTTP请求的最长时间，超时返回504，0表示使用transport.DefaultRequestTimeout，负数表示不限制
+	WebSocketOrigins    []string                // WebSocket升级请求允许的Origin（"*"表示任意），空表示只允许与Host相同的Origin，见transport.Option.AllowedOrigins
+	Authenticator       auth.Authenticator      // 验证客户端令牌，设置后未认证的调用返回Unauthenticated，nil表示不认证
+	Authorizer          auth.Authorizer         // 调用方法前检查调用方的权限，拒绝时返回PermissionDenied，nil表示不检查
+	AuditLog            io.Writer               // 被拒绝调用的审计日志（每行一条JSON记录），nil表示写入标准日志
+	Signer              *protocol.Signer        // 帧签名：设置后要求客户端的每一帧都带有有效签名且未被重放，发出的帧同样签名，nil表示不签名
+	RateLimits          *RateLimits             // 全局、按方法和按客户端的请求限流，nil表示不限流
+	Concurrency         *ConcurrencyLimits      // 全局和按方法的并发上限、工作协程池和等待队列，nil表示不限制
+	LoadShedding        *LoadShedding           // 按请求优先级和排队时间自适应减载，nil表示不减载（请求元数据不能把请求标记为关键）
+	BatchParallelism    int                     // 并行执行的批量请求中同时执行的条目数上限，0表示使用DefaultBatchParallelism
+	MaxSubscriberBuffer int                     // 客户端订阅主题时可请求的缓冲区大小上限，超过时返回InvalidArgument，0表示使用DefaultMaxSubscriberBuffer
+	CallbackTimeout     time.Duration           // Peer.Call等待客户端响应的最长时间（包括发布订阅的投递），0表示使用DefaultCallbackTimeout，负数表示不限制
+}
+
+// DefaultOption 默认配置
+var DefaultOption = &Option{
+	TransportType:     transport.TCP,
+	CodecType:         codec.JSON,
+	KeepaliveInterval: time.Second * 30,
+	KeepaliveTimeout:  time.Second * 10,
+	IdleTimeout:       time.Minute * 5,
+	MaxRequestSize:    transport.DefaultMaxFrameSize,
+	MaxResponseSize:   transport.DefaultMaxFrameSize,
+	MaxConnMemory:     64 << 20,
+	MaxMemory:         512 << 20,
+}
+
+// NewServer 使用默认配置创建RPC服务器
 func NewServer(transportType transport.TransportType, codecType codec.Type) *Server {
-	return &Server{
-		services:   make(map[string]*service),
-		transport:  transport.NewTransport(transportType),
-		serializer: codec.NewCodec(codecType),
+	opt := *DefaultOption
+	opt.TransportType = transportType
+	opt.CodecType = codecType
+	return NewServerWithOption(&opt)
+}
+
+// NewServerWithOption 根据配置创建RPC服务器
+func NewServerWithOption(opt *Option) *Server {
+	if opt == nil {
+		opt = DefaultOption
 	}
+
+	server := &Server{
+		services:    make(map[string]*service),
+		closed:      make(chan struct{}),
+		opt:         *opt,
+		codecType:   opt.CodecType,
+		serializer:  codec.NewCodec(opt.CodecType),
+		peers:       make(map[uint64]*Peer),
+		limiter:     newRateLimiter(opt.RateLimits),
+		concurrency: newConcurrency(opt.Concurrency),
+		shedder:     newShedder(opt.LoadShedding),
+	}
+	server.transport = server.newTransport(opt.TransportType)
+	if server.opt.MaxRequestSize <= 0 {
+		server.opt.MaxRequestSize = transport.DefaultMaxFrameSize
+	}
+	if server.opt.MaxResponseSize <= 0 {
+		server.opt.MaxResponseSize = transport.DefaultMaxFrameSize
+	}
+	if server.opt.BatchParallelism <= 0 {
+		server.opt.BatchParallelism = DefaultBatchParallelism
+	}
+	if server.opt.MaxSubscriberBuffer <= 0 {
+		server.opt.MaxSubscriberBuffer = DefaultMaxSubscriberBuffer
+	}
+	if server.opt.CallbackTimeout == 0 {
+		server.opt.CallbackTimeout = DefaultCallbackTimeout
+	}
+
+	server.startWorkers()
+
+	// 注册内置的发布订阅服务
+	server.pubsub = newPubSub(server)
+	server.RegisterName(protocol.PubSubService, server.pubsub)
+
+	return server
+}
+
+// newTransport 按配置创建指定类型的传输层
+func (server *Server) newTransport(transportType transport.TransportType) transport.Transport {
+	return transport.NewTransportWithOption(transportType, server.transportOption())
 }
 
-// Register 注册服务
+// transportOption 由服务端配置生成传输层配置
+func (server *Server) transportOption() *transport.Option {
+	return &transport.Option{
+		MaxFrameSize:   server.opt.MaxRequestSize,
+		SocketMode:     server.opt.SocketMode,
+		SocketOwner:    server.opt.SocketOwner,
+		SocketGroup:    server.opt.SocketGroup,
+		MaxUDPConns:    server.opt.MaxUDPConns,
+		TLS:            server.opt.TLS,
+		HTTPPath:       server.opt.HTTPPath,
+		RequestTimeout: server.opt.HTTPTimeout,
+		Gateway:        server.Gateway(),
+		JSONRPC:        server.JSONRPC(),
+		AllowedOrigins: server.opt.WebSocketOrigins,
+	}
+}
+
+// Register 注册服务，服务名为接收者的类型名
 func (server *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr)
+	return server.RegisterName("", rcvr)
+}
+
+// RegisterName 以指定的服务名注册服务，name为空时使用接收者的类型名
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	s := newService(name, rcvr)
 	if s == nil {
 		return errors.New("invalid service")
 	}
@@ -64,11 +220,14 @@ func (server *Server) Register(rcvr interface{}) error {
 }
 
 // newService 创建服务信息
-func newService(rcvr interface{}) *service {
+func newService(name string, rcvr interface{}) *service {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
 	s.typ = reflect.TypeOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
+	s.name = name
+	if s.name == "" {
+		s.name = reflect.Indirect(s.rcvr).Type().Name()
+	}
 
 	if s.name == "" {
 		return nil
@@ -87,26 +246,34 @@ func newService(rcvr interface{}) *service {
 		}
 
 		// 检查方法签名：func(receiver, args, *reply) error
-		if mtype.NumIn() != 3 || mtype.NumOut() != 1 {
+		// 或 func(receiver, context.Context, args, *reply) error
+		if mtype.NumOut() != 1 {
+			continue
+		}
+
+		withContext := mtype.NumIn() == 4 && mtype.In(1) == typeOfContext
+		if mtype.NumIn() != 3 && !withContext {
 			continue
 		}
+		argIndex := mtype.NumIn() - 2
 
 		// 返回值类型必须是error
 		if returnType := mtype.Out(0); returnType != reflect.TypeOf((*error)(nil)).Elem() {
 			continue
 		}
 
-		// 第三个参数必须是指针类型（用于返回值）
-		replyType := mtype.In(2)
+		// 最后一个参数必须是指针类型（用于返回值）
+		replyType := mtype.In(argIndex + 1)
 		if replyType.Kind() != reflect.Ptr {
 			continue
 		}
 
 		// 记录有效的方法
 		s.methods[method.Name] = &methodType{
-			method:    method,
-			ArgType:   mtype.In(1),
-			ReplyType: replyType,
+			method:      method,
+			ArgType:     mtype.In(argIndex),
+			ReplyType:   replyType,
+			withContext: withContext,
 		}
 	}
 
@@ -117,18 +284,118 @@ func newService(rcvr interface{}) *service {
 	return s
 }
 
-// Serve 启动RPC服务
+// Serve 启动RPC服务，阻塞直到服务器关闭，关闭后返回ErrServerClosed
+// 需要同时在其他传输或地址上提供服务时，在Serve之前调用AddListener
 func (server *Server) Serve(addr string) error {
-	err := server.transport.Listen(addr)
-	if err != nil {
+	// unix:// 和 mem:// 地址总是使用对应的传输
+	select {
+	case <-server.closed:
+		return ErrServerClosed
+	default:
+	}
+
+	server.mu.Lock()
+	if t, ok := transport.AddrType(addr); ok && server.opt.TransportType != t {
+		server.opt.TransportType = t
+		server.transport = server.newTransport(t)
+	}
+	t := server.transport
+	key := listenerKey(server.opt.TransportType, addr)
+	server.mu.Unlock()
+
+	if err := listen(t, key, addr); err != nil {
+		return err
+	}
+	if err := server.track(t, key); err != nil {
 		return err
 	}
 
 	log.Printf("RPC Server listening on %s\n", addr)
 
+	return server.accept(t)
+}
+
+// AddListener 在另一个传输和地址上提供同一组服务，监听成功后在后台接受连接
+// 所有监听共享服务注册、客户端连接、发布订阅和内存预算，Close时一并关闭
+// unix:// 和 mem:// 地址总是使用对应的传输
+func (server *Server) AddListener(transportType transport.TransportType, addr string) error {
+	if t, ok := transport.AddrType(addr); ok {
+		transportType = t
+	}
+
+	t := server.newTransport(transportType)
+	key := listenerKey(transportType, addr)
+	if err := listen(t, key, addr); err != nil {
+		return err
+	}
+	if err := server.track(t, key); err != nil {
+		return err
+	}
+
+	log.Printf("RPC Server listening on %s\n", addr)
+
+	go server.accept(t)
+	return nil
+}
+
+// ServeListener 在调用方提供的监听器上提供服务，阻塞直到服务器关闭，关闭后返回ErrServerClosed
+// 用于systemd套接字激活、父进程传递的监听器、SO_REUSEPORT等场景；按配置的传输类型处理连接，
+// TCP和Unix域套接字接受任意流式监听器，HTTP类传输在其上启动HTTP服务；监听器在Close时关闭
+func (server *Server) ServeListener(listener net.Listener) error {
+	t, ok := server.newTransport(server.opt.TransportType).(transport.ListenerTransport)
+	if !ok {
+		return fmt.Errorf("transport type %d cannot serve a net.Listener", server.opt.TransportType)
+	}
+	if err := t.ListenOn(listener); err != nil {
+		return err
+	}
+	// 监听器由调用方管理，热重启时不交给新进程
+	if err := server.track(t, ""); err != nil {
+		return err
+	}
+
+	log.Printf("RPC Server listening on %s\n", listener.Addr())
+
+	return server.accept(t)
+}
+
+// ServeConn 在一个已建立的连接上提供服务（如自定义多路复用器拆分出的连接），阻塞直到连接关闭
+// 连接按长度前缀收发帧，与TCP传输相同；配置了TLS时先作为TLS服务端握手
+func (server *Server) ServeConn(conn net.Conn) {
+	c, err := transport.ServerConn(conn, server.transportOption())
+	if err != nil {
+		log.Printf("RPC server conn error: %v\n", err)
+		conn.Close()
+		return
+	}
+	server.handleConn(c)
+}
+
+// track 登记正在监听的传输层，使Close时一并关闭；服务器已关闭时关闭该传输层并返回ErrServerClosed
+func (server *Server) track(t transport.Transport, key string) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	select {
+	case <-server.closed:
+		t.Close()
+		return ErrServerClosed
+	default:
+	}
+	server.listeners = append(server.listeners, listener{Transport: t, key: key})
+	return nil
+}
+
+// accept 持续接受传输层上的连接，服务器关闭后返回ErrServerClosed
+func (server *Server) accept(t transport.Transport) error {
 	for {
-		conn, err := server.transport.Accept()
+		conn, err := t.Accept()
 		if err != nil {
+			select {
+			case <-server.closed:
+				return ErrServerClosed
+			default:
+			}
 			log.Printf("RPC server accept error: %v\n", err)
 			continue
 		}
@@ -139,111 +406,237 @@ func (server *Server) Serve(addr string) error {
 
 // handleConn 处理连接请求
 func (server *Server) handleConn(conn transport.Conn) {
-	defer conn.Close()
+	// TLS连接先完成握手，获取客户端证书
+	var tlsState *tls.ConnectionState
+	if tc, ok := conn.(transport.TLSConn); ok {
+		state, err := tc.ConnectionState()
+		if err != nil {
+			log.Printf("TLS handshake error: %v\n", err)
+			conn.Close()
+			return
+		}
+		tlsState = state
+	}
+
+	peer := server.addPeer(conn, tlsState)
+
+	// HTTP请求等随连接携带令牌的连接，在处理请求前完成认证
+	if cc, ok := conn.(transport.CredentialConn); ok {
+		if token := cc.Credentials(); token != "" {
+			peer.authErr = server.authenticatePeer(peer, token)
+		}
+	}
+
+	// UDP的一个数据报承载一帧，超过数据报上限的响应改为返回错误
+	maxResponse := server.opt.MaxResponseSize
+	if _, ok := conn.(*transport.UDPConn); ok {
+		maxResponse = min(maxResponse, transport.MaxDatagramFrameSize)
+	}
+
+	// HTTP请求只承载单个请求，不需要心跳
+	if _, ok := conn.(*transport.HTTPConn); !ok {
+		go server.keepalive(peer)
+	}
+
+	// 请求在工作协程（或独立的goroutine）中处理，读取循环不等待请求完成，使处理过程中可以回调客户端
+	var wg sync.WaitGroup
+	defer func() {
+		// 先唤醒等待回调响应的请求，待所有请求处理完毕后再关闭连接
+		peer.close()
+		wg.Wait()
+		server.removePeer(peer)
+	}()
 
 	for {
 		// 读取请求数据
 		data, err := conn.Read()
 		if err != nil {
-			log.Printf("Read error: %v\n", err)
+			// 超过大小限制的帧已被传输层丢弃，返回错误响应后继续处理后续请求
+			var tooLarge *transport.FrameTooLargeError
+			if errors.As(err, &tooLarge) {
+				peer.touch()
+				server.rejectFrame(peer, tooLarge.Head, tooLarge)
+				continue
+			}
+			// 对端正常关闭（HTTP请求处理完毕）时不记录
+			if err != io.EOF {
+				log.Printf("Read error: %v\n", err)
+			}
 			return
 		}
 
-		// 解析请求头
-		if len(data) < protocol.HeaderSize {
-			log.Println("Invalid request: header too small")
+		peer.touch()
+
+		// 验证签名并去掉签名尾部
+		if data, err = server.verifyFrame(peer, data); err != nil {
 			continue
 		}
 
-		header, err := protocol.DecodeHeader(data[:protocol.HeaderSize])
+		// 解析请求帧
+		frame, err := protocol.DecodeFrame(data)
 		if err != nil {
-			log.Printf("Decode header error: %v\n", err)
+			log.Printf("Decode frame error: %v\n", err)
 			continue
 		}
 
-		// 提取服务名和方法名
-		serviceNameEnd := protocol.HeaderSize + header.ServiceLength
-		methodNameEnd := serviceNameEnd + header.MethodLength
-
-		if len(data) < int(methodNameEnd) {
-			log.Println("Invalid request: data too small")
+		switch frame.Header.MessageType {
+		case protocol.Response:
+			// 客户端对服务端回调的响应
+			peer.deliver(frame)
+			continue
+		case protocol.Ping:
+			if err := peer.write(server.response(frame.Header, protocol.Pong, nil)); err != nil {
+				log.Printf("Write error: %v\n", err)
+			}
+			continue
+		case protocol.Pong:
+			continue
+		case protocol.Auth:
+			// 认证在读取循环中按顺序处理，之后读取的请求以新的身份调用
+			if err := peer.write(server.authenticate(peer, frame)); err != nil {
+				log.Printf("Write error: %v\n", err)
+			}
 			continue
 		}
 
-		serviceName := string(data[protocol.HeaderSize:serviceNameEnd])
-		methodName := string(data[serviceNameEnd:methodNameEnd])
-
-		// 提取参数数据
-		payloadStart := methodNameEnd
-		payloadEnd := uint32(payloadStart) + header.PayloadLength
-
-		if uint32(len(data)) < payloadEnd {
-			log.Println("Invalid request: payload too small")
+		if peer.authErr != nil {
+			server.rejectFrame(peer, data, peer.authErr)
 			continue
 		}
-		payload := data[payloadStart:payloadEnd]
 
-		// 调用服务方法
-		resp, err := server.call(serviceName, methodName, payload)
-		if err != nil {
-			log.Printf("Call error: %v\n", err)
-			// 发送错误响应
-			errorResp := &protocol.ResponseMessage{Error: err.Error()}
-			respData, _ := server.serializer.Encode(errorResp)
-
-			header := &protocol.Header{
-				MagicNumber:   protocol.MagicNumber,
-				Version:       protocol.Version,
-				MessageType:   protocol.Response,
-				SerializeType: header.SerializeType,
-				PayloadLength: uint32(len(respData)),
+		ctx := withMetadata(peer.callContext(), frame.Metadata)
+		serviceMethod := ""
+		if frame.Header.MessageType == protocol.Request {
+			serviceMethod = frame.ServiceName + "." + frame.MethodName
+		}
+		priority := server.priority(ctx, serviceMethod)
+
+		// 交给工作池执行，工作池和等待队列都已满、正在减载或超过内存预算时拒绝请求，不再创建goroutine
+		peer.acquire()
+		peer.requests.Add(1)
+		wg.Add(1)
+		finish := func() {
+			peer.requests.Add(-1)
+			peer.release()
+			wg.Done()
+		}
+		send := func(resp []byte) {
+			defer finish()
+			if err := peer.write(resp); err != nil {
+				log.Printf("Write error: %v\n", err)
 			}
+		}
 
-			headerBytes := protocol.EncodeHeader(header)
-			conn.Write(append(headerBytes, respData...))
-			continue
+		// 并行的批量请求中每个条目作为单独的请求交给工作池
+		if frame.Header.MessageType == protocol.BatchRequest {
+			if flags, entries, err := protocol.DecodeBatch(frame.Payload); err == nil && flags&protocol.BatchParallel != 0 {
+				server.dispatchBatch(ctx, frame.Header, entries, &peer.memUsed, maxResponse, send)
+				continue
+			}
 		}
 
-		// 发送成功响应
-		if err := conn.Write(resp); err != nil {
-			log.Printf("Write error: %v\n", err)
-			return
+		err = server.dispatch(priority, &peer.memUsed, int64(len(data)), func(err error) {
+			if err != nil {
+				defer finish()
+				server.rejectFrame(peer, data, err)
+				return
+			}
+			send(server.serveRequest(ctx, frame, maxResponse))
+		})
+		if err != nil {
+			finish()
+			server.rejectFrame(peer, data, err)
 		}
 	}
 }
 
-// call 调用服务方法
-func (server *Server) call(serviceName, methodName string, argBytes []byte) ([]byte, error) {
-	server.mu.RLock()
-	service, ok := server.services[serviceName]
-	server.mu.RUnlock()
+// ServeRequest 处理一个请求帧（普通或批量），返回对应的响应帧
+// ctx会传递给以context.Context作为首个参数的服务方法；请求在调用方的goroutine中执行，不经过工作池
+func (server *Server) ServeRequest(ctx context.Context, frame *protocol.Frame) []byte {
+	return server.serveRequest(ctx, frame, server.opt.MaxResponseSize)
+}
 
-	if !ok {
-		return nil, errors.New("service not found: " + serviceName)
+// serveRequest 处理一个请求帧，响应超过maxResponse字节时改为返回错误响应
+func (server *Server) serveRequest(ctx context.Context, frame *protocol.Frame, maxResponse int) []byte {
+	var resp []byte
+	switch frame.Header.MessageType {
+	case protocol.Request:
+		resp = server.handleRequest(ctx, frame)
+	case protocol.BatchRequest:
+		resp = server.handleBatch(ctx, frame)
+	default:
+		return server.errorResponse(frame.Header, protocol.Errorf(protocol.InvalidArgument, "unexpected message type: %d", frame.Header.MessageType))
 	}
 
-	mtype, ok := service.methods[methodName]
-	if !ok {
-		return nil, errors.New("method not found: " + methodName)
+	return server.limitResponse(frame.Header, resp, maxResponse)
+}
+
+// limitResponse 响应超过大小限制时改为返回错误，避免对端因超限而无法读取
+func (server *Server) limitResponse(reqHeader *protocol.Header, resp []byte, maxResponse int) []byte {
+	if len(resp) > maxResponse {
+		err := protocol.Errorf(protocol.ResourceExhausted, "response too large: %d bytes exceeds limit of %d bytes", len(resp), maxResponse)
+		log.Printf("Call error: %v\n", err)
+		return server.errorResponse(reqHeader, err)
+	}
+	return resp
+}
+
+// handleRequest 处理单个请求帧，返回响应帧（调用失败时返回错误响应）
+func (server *Server) handleRequest(ctx context.Context, frame *protocol.Frame) []byte {
+	// 调用服务方法
+	respBytes, err := server.call(ctx, frame.ServiceName, frame.MethodName, frame.Payload)
+	if err != nil {
+		log.Printf("Call error: %v\n", err)
+		return server.errorResponse(frame.Header, err)
 	}
 
-	// 创建参数实例
-	argv := reflect.New(mtype.ArgType)
-	replyv := reflect.New(mtype.ReplyType.Elem())
+	return server.response(frame.Header, protocol.Response, respBytes)
+}
+
+// errorResponse 构造错误响应帧
+func (server *Server) errorResponse(reqHeader *protocol.Header, err error) []byte {
+	errorResp := &protocol.ResponseMessage{Error: err.Error(), Code: protocol.CodeOf(err), RetryAfter: protocol.RetryAfter(err)}
+	respData, _ := server.serializer.Encode(errorResp)
+
+	return server.response(reqHeader, protocol.Response, respData)
+}
+
+// response 构造与请求对应的响应帧
+func (server *Server) response(reqHeader *protocol.Header, messageType protocol.MessageType, payload []byte) []byte {
+	return protocol.EncodeFrame(&protocol.Frame{
+		Header: &protocol.Header{
+			MagicNumber:   protocol.MagicNumber,
+			Version:       protocol.Version,
+			MessageType:   messageType,
+			SerializeType: reqHeader.SerializeType,
+			Seq:           reqHeader.Seq,
+		},
+		Payload: payload,
+	})
+}
+
+// call 调用服务方法，返回编码后的响应消息
+func (server *Server) call(ctx context.Context, serviceName, methodName string, argBytes []byte) ([]byte, error) {
+	// 未认证的调用方不能通过NotFound探测有哪些服务和方法
+	if err := server.checkAuth(ctx); err != nil {
+		return nil, err
+	}
+	service, mtype, err := server.lookup(serviceName, methodName)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.rateLimit(ctx, serviceName+"."+methodName); err != nil {
+		return nil, err
+	}
 
 	// 解析参数
+	argv := reflect.New(mtype.ArgType)
 	if err := server.serializer.Decode(argBytes, argv.Interface()); err != nil {
-		return nil, fmt.Errorf("decode argument error: %v", err)
+		return nil, protocol.Errorf(protocol.InvalidArgument, "decode argument error: %v", err)
 	}
 
-	// 调用方法
-	function := mtype.method.Func
-	returnValues := function.Call([]reflect.Value{service.rcvr, argv.Elem(), replyv})
-
-	// 处理错误
-	errInter := returnValues[0].Interface()
-	if errInter != nil {
-		err := errInter.(error)
+	replyv, err := server.invoke(ctx, service, mtype, argv)
+	if err != nil {
 		return nil, err
 	}
 
@@ -251,47 +644,99 @@ func (server *Server) call(serviceName, methodName string, argBytes []byte) ([]b
 	response := &protocol.ResponseMessage{Result: replyv.Interface()}
 	respBytes, err := server.serializer.Encode(response)
 	if err != nil {
-		return nil, fmt.Errorf("encode response error: %v", err)
+		return nil, protocol.Errorf(protocol.Internal, "encode response error: %v", err)
+	}
+
+	return respBytes, nil
+}
+
+// lookup 查找服务和方法
+func (server *Server) lookup(serviceName, methodName string) (*service, *methodType, error) {
+	server.mu.RLock()
+	service, ok := server.services[serviceName]
+	server.mu.RUnlock()
+
+	if !ok {
+		return nil, nil, protocol.Errorf(protocol.NotFound, "service not found: %s", serviceName)
+	}
+
+	mtype, ok := service.methods[methodName]
+	if !ok {
+		return nil, nil, protocol.Errorf(protocol.NotFound, "method not found: %s", methodName)
+	}
+
+	return service, mtype, nil
+}
+
+// invoke 以已解析的参数调用服务方法，argv为指向参数的指针，返回指向结果的指针
+func (server *Server) invoke(ctx context.Context, service *service, mtype *methodType, argv reflect.Value) (reflect.Value, error) {
+	if err := server.checkAuth(ctx); err != nil {
+		return reflect.Value{}, err
+	}
+	if err := server.authorize(ctx, service.name+"."+mtype.method.Name); err != nil {
+		return reflect.Value{}, err
+	}
+	done, err := server.admit(service.name + "." + mtype.method.Name)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	defer done()
+
+	replyv := reflect.New(mtype.ReplyType.Elem())
+
+	// 调用方法
+	function := mtype.method.Func
+	in := []reflect.Value{service.rcvr, argv.Elem(), replyv}
+	if mtype.withContext {
+		in = []reflect.Value{service.rcvr, reflect.ValueOf(ctx), argv.Elem(), replyv}
 	}
+	returnValues := function.Call(in)
 
-	// 构造响应头
-	header := &protocol.Header{
-		MagicNumber:   protocol.MagicNumber,
-		Version:       protocol.Version,
-		MessageType:   protocol.Response,
-		PayloadLength: uint32(len(respBytes)),
+	// 处理错误
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		return reflect.Value{}, errInter.(error)
 	}
 
-	headerBytes := protocol.EncodeHeader(header)
-	return append(headerBytes, respBytes...), nil
+	return replyv, nil
 }
 
 // findMethod 解析服务方法
 func (server *Server) findMethod(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("service/method request ill-formed: " + serviceMethod)
+		err = protocol.Errorf(protocol.NotFound, "service/method request ill-formed: %s", serviceMethod)
 		return
 	}
 
-	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	return server.lookup(serviceMethod[:dot], serviceMethod[dot+1:])
+}
 
-	server.mu.RLock()
-	svc = server.services[serviceName]
-	server.mu.RUnlock()
-	if svc == nil {
-		err = errors.New("can't find service " + serviceName)
-		return
+// httpContext 返回处理HTTP请求的context，超时时间为 HTTPTimeout
+func (server *Server) httpContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := server.opt.HTTPTimeout
+	if timeout == 0 {
+		timeout = transport.DefaultRequestTimeout
 	}
-
-	mtype = svc.methods[methodName]
-	if mtype == nil {
-		err = errors.New("can't find method " + methodName)
+	if timeout < 0 {
+		return context.WithCancel(r.Context())
 	}
-	return
+	return context.WithTimeout(r.Context(), timeout)
 }
 
-// Close 关闭服务器
+// Close 关闭服务器的所有监听，Serve随之返回ErrServerClosed
 func (server *Server) Close() error {
-	return server.transport.Close()
+	server.closeOnce.Do(func() { close(server.closed) })
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	var errs []error
+	for _, l := range server.listeners {
+		errs = append(errs, l.Close())
+	}
+	server.listeners = nil
+	if server.jsonrpcListener != nil {
+		errs = append(errs, server.jsonrpcListener.Close())
+	}
+	return errors.Join(errs...)
 }
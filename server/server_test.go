@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"rpc/server"
+)
+
+// Args 测试服务的参数
+type Args struct {
+	A, B int
+}
+
+// Arith 测试服务，记录同时执行的调用数
+type Arith struct {
+	delay   time.Duration
+	mu      sync.Mutex
+	running int
+	peak    int
+	calls   atomic.Int64
+}
+
+func (a *Arith) Add(args Args, reply *int) error {
+	a.calls.Add(1)
+	*reply = args.A + args.B
+	return nil
+}
+
+func (a *Arith) Fail(args Args, reply *int) error {
+	return errors.New("boom")
+}
+
+// Slow 等待delay后返回参数之和，用于观察并发度
+func (a *Arith) Slow(args Args, reply *int) error {
+	a.mu.Lock()
+	a.running++
+	a.peak = max(a.peak, a.running)
+	a.mu.Unlock()
+
+	time.Sleep(a.delay)
+
+	a.mu.Lock()
+	a.running--
+	a.mu.Unlock()
+	*reply = args.A + args.B
+	return nil
+}
+
+// Peak 返回同时执行Slow的最大调用数
+func (a *Arith) Peak() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.peak
+}
+
+// freeAddr 返回一个当前空闲的本地TCP地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// serve 在空闲的本地地址上启动服务端，等待其开始监听后返回地址
+func serve(t *testing.T, s *server.Server) string {
+	t.Helper()
+	addr := freeAddr(t)
+	go s.Serve(addr)
+	waitListening(t, addr)
+	return addr
+}
+
+// waitListening 等待本地TCP地址开始监听
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	t.Fatalf("server did not start listening on %s", addr)
+}
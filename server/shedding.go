@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"rpc/auth"
+	"rpc/protocol"
+)
+
+// Priority 请求的优先级，过载时先丢弃优先级低的请求
+type Priority int
+
+const (
+	Sheddable Priority = iota - 1 // 可丢弃：开始减载时最先拒绝，如批量任务、预取
+	Normal                        // 普通：默认优先级，只丢弃排队过久的请求
+	Critical                      // 关键：不会被减载，配置了ConcurrencyLimits.CriticalWorkers时在保留的工作协程上执行、不占用普通请求的名额，如健康检查、管理调用；只能由服务端配置或可信的调用方指定
+)
+
+// String 返回优先级在元数据中的名称
+func (p Priority) String() string {
+	switch p {
+	case Sheddable:
+		return "sheddable"
+	case Critical:
+		return "critical"
+	}
+	return "normal"
+}
+
+// ParsePriority 解析元数据中的优先级名称
+func ParsePriority(s string) (Priority, bool) {
+	switch s {
+	case "sheddable":
+		return Sheddable, true
+	case "normal":
+		return Normal, true
+	case "critical":
+		return Critical, true
+	}
+	return Normal, false
+}
+
+const (
+	DefaultShedTarget   = 5 * time.Millisecond   // 默认的排队时间目标值
+	DefaultShedInterval = 100 * time.Millisecond // 默认的观察窗口
+)
+
+// LoadShedding 基于排队时间的自适应减载（CoDel）：请求等待工作协程的时间持续Interval都不低于Target时进入减载状态，
+// 拒绝新的可丢弃请求，并丢弃排队超过Target的可丢弃请求和排队超过Interval的普通请求，排队时间回落到Target以下时退出
+// 排队时间在工作协程池中测量，需要同时配置Concurrency.Workers
+// 请求元数据中的优先级只能降低请求的优先级，标记为关键仅对CriticalCallers中的调用方有效，避免任意客户端绕过减载和并发上限
+type LoadShedding struct {
+	Target          time.Duration       // 排队时间的目标值，0表示使用DefaultShedTarget
+	Interval        time.Duration       // 观察窗口，0表示使用DefaultShedInterval
+	Priorities      map[string]Priority // 按方法指定优先级，键为 "Service.Method" 或 "Service.*"，未配置的方法为Normal
+	CriticalCallers []string            // 可以通过元数据把请求标记为关键的调用方：认证得到的调用方标识（Principal.Subject）或角色（Principal.Roles）
+}
+
+// shedder 减载状态
+type shedder struct {
+	target     time.Duration
+	interval   time.Duration
+	priorities map[string]Priority
+	critical   []string // 可以把请求标记为关键的调用方标识或角色
+
+	mu         sync.Mutex
+	firstAbove time.Time // 排队时间持续不低于目标值时，进入减载状态的时刻，零值表示排队时间低于目标值
+	dropping   bool      // 是否处于减载状态
+}
+
+// newShedder 根据配置创建减载状态，未配置时返回nil
+func newShedder(opt *LoadShedding) *shedder {
+	if opt == nil {
+		return nil
+	}
+	s := &shedder{target: opt.Target, interval: opt.Interval, priorities: opt.Priorities, critical: opt.CriticalCallers}
+	if s.target <= 0 {
+		s.target = DefaultShedTarget
+	}
+	if s.interval <= 0 {
+		s.interval = DefaultShedInterval
+	}
+	return s
+}
+
+// shedding 判断是否处于减载状态，waiting表示是否有请求在排队
+func (s *shedder) shedding(waiting bool) bool {
+	if s == nil || !waiting {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropping
+}
+
+// observe 记录请求的排队时间，返回之后是否处于减载状态
+func (s *shedder) observe(sojourn time.Duration, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sojourn < s.target {
+		s.firstAbove = time.Time{}
+		s.dropping = false
+		return false
+	}
+	if s.firstAbove.IsZero() {
+		s.firstAbove = now.Add(s.interval)
+	} else if !now.Before(s.firstAbove) {
+		s.dropping = true
+	}
+	return s.dropping
+}
+
+// shed 请求取得工作协程时调用，根据排队时间和优先级决定是否丢弃请求
+func (s *shedder) shed(priority Priority, sojourn time.Duration) error {
+	if s == nil || !s.observe(sojourn, time.Now()) {
+		return nil
+	}
+	if (priority == Sheddable && sojourn >= s.target) || (priority == Normal && sojourn >= s.interval) {
+		return protocol.Errorf(protocol.Unavailable, "server overloaded: %s request shed after queueing for %v", priority, sojourn.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// priority 返回请求的优先级：方法配置的优先级，没有时为Normal；请求元数据可以降低优先级，
+// 只有认证得到的调用方在CriticalCallers中时才能把请求提升为关键
+func (server *Server) priority(ctx context.Context, serviceMethod string) Priority {
+	priority := Normal
+	s := server.shedder
+	if s != nil {
+		if key, ok := methodKey(s.priorities, serviceMethod); ok {
+			priority = s.priorities[key]
+		}
+	}
+
+	p, ok := ParsePriority(MetadataFromContext(ctx)[protocol.MetadataPriority])
+	switch {
+	case !ok:
+	case p < priority:
+		priority = p
+	case p == Critical && s.trusted(auth.FromContext(ctx)):
+		priority = Critical
+	}
+	return priority
+}
+
+// trusted 判断调用方是否可以把请求标记为关键
+func (s *shedder) trusted(principal *auth.Principal) bool {
+	if s == nil || principal == nil {
+		return false
+	}
+	for _, name := range s.critical {
+		if name == principal.Subject || slices.Contains(principal.Roles, name) {
+			return true
+		}
+	}
+	return false
+}
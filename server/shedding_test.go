@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"rpc/auth"
+	"rpc/protocol"
+)
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []Priority{Sheddable, Normal, Critical} {
+		if got, ok := ParsePriority(p.String()); !ok || got != p {
+			t.Errorf("ParsePriority(%q) = %v, %v", p.String(), got, ok)
+		}
+	}
+	if p, ok := ParsePriority("urgent"); ok || p != Normal {
+		t.Errorf("ParsePriority(urgent) = %v, %v; want normal, false", p, ok)
+	}
+}
+
+func TestShedder(t *testing.T) {
+	s := newShedder(&LoadShedding{Target: 10 * time.Millisecond, Interval: 100 * time.Millisecond})
+	now := time.Now()
+
+	// 排队时间刚超过目标值时还不减载，持续一个观察窗口后才进入减载状态
+	if s.observe(20*time.Millisecond, now) {
+		t.Fatal("dropping before the interval elapsed")
+	}
+	if s.observe(20*time.Millisecond, now.Add(50*time.Millisecond)) {
+		t.Fatal("dropping before the interval elapsed")
+	}
+	if !s.observe(20*time.Millisecond, now.Add(100*time.Millisecond)) {
+		t.Fatal("not dropping after the interval")
+	}
+	if !s.shedding(true) || s.shedding(false) {
+		t.Error("shedding should only reject new requests while requests are queued")
+	}
+
+	if err := s.shed(Sheddable, 20*time.Millisecond); protocol.CodeOf(err) != protocol.Unavailable {
+		t.Errorf("sheddable request: %v, want Unavailable", err)
+	}
+	if err := s.shed(Normal, 20*time.Millisecond); err != nil {
+		t.Errorf("normal request queued below the interval: %v", err)
+	}
+	if err := s.shed(Normal, 200*time.Millisecond); protocol.CodeOf(err) != protocol.Unavailable {
+		t.Errorf("normal request queued above the interval: %v, want Unavailable", err)
+	}
+	if err := s.shed(Critical, time.Second); err != nil {
+		t.Errorf("critical request: %v", err)
+	}
+
+	// 排队时间回落到目标值以下时退出减载状态
+	if s.observe(time.Millisecond, now.Add(200*time.Millisecond)) || s.shedding(true) {
+		t.Error("still dropping after the queue drained")
+	}
+	if err := s.shed(Sheddable, 5*time.Millisecond); err != nil {
+		t.Errorf("sheddable request after recovery: %v", err)
+	}
+
+	var none *shedder
+	if none.shedding(true) || none.shed(Sheddable, time.Hour) != nil {
+		t.Error("nil shedder should never shed")
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	opt := *DefaultOption
+	opt.LoadShedding = &LoadShedding{
+		Priorities:      map[string]Priority{"Health.*": Critical, "Batch.Run": Sheddable},
+		CriticalCallers: []string{"admin"},
+	}
+	server := NewServerWithOption(&opt)
+
+	withPriority := func(ctx context.Context, p string) context.Context {
+		return withMetadata(ctx, map[string]string{protocol.MetadataPriority: p})
+	}
+	admin := auth.NewContext(context.Background(), &auth.Principal{Subject: "ops", Roles: []string{"admin"}})
+	user := auth.NewContext(context.Background(), &auth.Principal{Subject: "alice"})
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		serviceMethod string
+		want          Priority
+	}{
+		{"default", context.Background(), "Arith.Add", Normal},
+		{"configured", context.Background(), "Health.Check", Critical},
+		{"configured sheddable", context.Background(), "Batch.Run", Sheddable},
+		{"metadata lowers", withPriority(context.Background(), "sheddable"), "Health.Check", Sheddable},
+		{"metadata cannot raise", withPriority(context.Background(), "normal"), "Batch.Run", Sheddable},
+		{"untrusted critical", withPriority(user, "critical"), "Arith.Add", Normal},
+		{"anonymous critical", withPriority(context.Background(), "critical"), "Arith.Add", Normal},
+		{"trusted critical", withPriority(admin, "critical"), "Arith.Add", Critical},
+		{"unknown priority", withPriority(context.Background(), "urgent"), "Arith.Add", Normal},
+	}
+	for _, tt := range tests {
+		if got := server.priority(tt.ctx, tt.serviceMethod); got != tt.want {
+			t.Errorf("%s: priority = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"rpc/protocol"
+	"rpc/transport"
+)
+
+// shutdownPollInterval 优雅关闭时检查连接是否全部关闭的间隔
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown 优雅关闭服务器：停止接受新连接，通知已连接的客户端（GoAway）在新连接上发起后续调用，
+// 等待客户端处理完已发出的调用并关闭连接；HTTP请求处理完毕即结束，UDP连接在没有进行中的请求时关闭
+// ctx结束时关闭所有连接并返回ctx的错误；Serve随之返回ErrServerClosed
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.closeOnce.Do(func() { close(server.closed) })
+
+	server.mu.Lock()
+	listeners := server.listeners
+	server.listeners = nil
+	jsonrpcListener := server.jsonrpcListener
+	server.mu.Unlock()
+
+	// 支持优雅关闭的传输层等待已接受的请求处理完毕，其他的直接关闭
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l listener) {
+			if st, ok := l.Transport.(transport.ShutdownTransport); ok {
+				errc <- st.Shutdown(ctx)
+				return
+			}
+			errc <- l.Close()
+		}(l)
+	}
+	var errs []error
+	if jsonrpcListener != nil {
+		errs = append(errs, jsonrpcListener.Close())
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for server.goAway() > 0 {
+		select {
+		case <-ctx.Done():
+			server.closePeers()
+			for range listeners {
+				<-errc
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	for range listeners {
+		errs = append(errs, <-errc)
+	}
+	return errors.Join(errs...)
+}
+
+// goAway 向尚未通知的客户端发送GoAway，关闭没有进行中请求的UDP连接，返回剩余的连接数
+func (server *Server) goAway() int {
+	server.peerMu.Lock()
+	peers := make([]*Peer, 0, len(server.peers))
+	for _, p := range server.peers {
+		peers = append(peers, p)
+	}
+	server.peerMu.Unlock()
+
+	for _, p := range peers {
+		// HTTP请求处理完毕后连接自然结束
+		if _, ok := p.conn.(*transport.HTTPConn); ok {
+			continue
+		}
+
+		if p.goaway.CompareAndSwap(false, true) {
+			frame := protocol.EncodeFrame(&protocol.Frame{
+				Header: &protocol.Header{
+					MagicNumber:   protocol.MagicNumber,
+					Version:       protocol.Version,
+					MessageType:   protocol.GoAway,
+					SerializeType: byte(server.codecType),
+				},
+			})
+			if err := p.write(frame); err != nil {
+				log.Printf("Peer %d goaway write error: %v\n", p.id, err)
+			}
+		}
+
+		// UDP没有连接关闭的信号，也可能收不到GoAway，请求处理完毕后由服务端关闭
+		if _, ok := p.conn.(*transport.UDPConn); ok && p.requests.Load() == 0 {
+			p.conn.Close()
+		}
+	}
+	return len(peers)
+}
+
+// closePeers 关闭所有客户端连接
+func (server *Server) closePeers() {
+	server.peerMu.Lock()
+	defer server.peerMu.Unlock()
+
+	for _, p := range server.peers {
+		p.conn.Close()
+	}
+}
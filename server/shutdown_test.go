@@ -0,0 +1,128 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/server"
+	"rpc/transport"
+)
+
+func TestShutdownWaitsForCalls(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	s.Register(&Arith{delay: 300 * time.Millisecond})
+	addr := freeAddr(t)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(addr) }()
+	waitListening(t, addr)
+
+	c := client.NewClient(addr, nil)
+	defer c.Close()
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 1}, &sum); err != nil {
+		t.Fatal(err)
+	}
+
+	called := make(chan error, 1)
+	go func() { called <- c.Call("Arith.Slow", Args{A: 1, B: 2}, &sum) }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+	// 进行中的调用在Shutdown返回前完成
+	select {
+	case err := <-called:
+		if err != nil || sum != 3 {
+			t.Errorf("in-flight call = %d, %v", sum, err)
+		}
+	default:
+		t.Error("Shutdown returned before the in-flight call finished")
+	}
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, server.ErrServerClosed) {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := server.NewServer(transport.TCP, codec.JSON)
+	s.Register(&Arith{delay: 2 * time.Second})
+	addr := serve(t, s)
+
+	c := client.NewClient(addr, nil)
+	defer c.Close()
+	called := make(chan error, 1)
+	go func() {
+		var sum int
+		called <- c.Call("Arith.Slow", Args{A: 1, B: 2}, &sum)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+	// 超时后连接被关闭，调用失败
+	select {
+	case err := <-called:
+		if err == nil {
+			t.Error("call succeeded after its connection was closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not fail after Shutdown timed out")
+	}
+}
+
+func TestGoAwayReconnects(t *testing.T) {
+	old := server.NewServer(transport.TCP, codec.JSON)
+	old.Register(&Arith{delay: 200 * time.Millisecond})
+	addr := serve(t, old)
+
+	c := client.NewClient(addr, nil)
+	defer c.Close()
+	var sum int
+	if err := c.Call("Arith.Add", Args{A: 1, B: 1}, &sum); err != nil {
+		t.Fatal(err)
+	}
+
+	called := make(chan error, 1)
+	go func() {
+		var sum int
+		called <- c.Call("Arith.Slow", Args{A: 1, B: 2}, &sum)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := old.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-called; err != nil {
+		t.Errorf("in-flight call = %v", err)
+	}
+
+	// 收到GoAway后，后续调用在新连接上发往接替的服务器
+	next := server.NewServer(transport.TCP, codec.JSON)
+	arith := &Arith{}
+	next.Register(arith)
+	go next.Serve(addr)
+	defer next.Close()
+	waitListening(t, addr)
+
+	if err := c.Call("Arith.Add", Args{A: 2, B: 3}, &sum); err != nil || sum != 5 {
+		t.Fatalf("call after GoAway = %d, %v", sum, err)
+	}
+	if arith.calls.Load() != 1 {
+		t.Errorf("new server saw %d calls, want 1", arith.calls.Load())
+	}
+}
@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rpc/client"
+	"rpc/server"
+	"rpc/transport"
+)
+
+// testCerts 测试用的CA、服务端证书和客户端证书文件
+type testCerts struct {
+	CA                    string
+	ServerCert, ServerKey string
+	ClientCert, ClientKey string
+}
+
+// writeCerts 生成CA签发的服务端（localhost）和客户端（CommonName为client）证书
+func writeCerts(t *testing.T) testCerts {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) (string, string) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{"localhost"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		certFile := filepath.Join(dir, name+".crt")
+		keyFile := filepath.Join(dir, name+".key")
+		writePEM(t, certFile, "CERTIFICATE", der)
+		writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+		return certFile, keyFile
+	}
+
+	certs := testCerts{CA: filepath.Join(dir, "ca.crt")}
+	writePEM(t, certs.CA, "CERTIFICATE", caDER)
+	certs.ServerCert, certs.ServerKey = issue(2, "server", x509.ExtKeyUsageServerAuth)
+	certs.ClientCert, certs.ClientKey = issue(3, "client", x509.ExtKeyUsageClientAuth)
+	return certs
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Identity 返回双向TLS中验证过的客户端身份
+type Identity struct{}
+
+func (Identity) Get(ctx context.Context, args struct{}, reply *string) error {
+	*reply = server.PeerFromContext(ctx).Identity()
+	return nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	certs := writeCerts(t)
+	opt := *server.DefaultOption
+	opt.TLS = &transport.TLSOption{
+		CertFile:   certs.ServerCert,
+		KeyFile:    certs.ServerKey,
+		CAFile:     certs.CA,
+		ClientAuth: true,
+	}
+	s := server.NewServerWithOption(&opt)
+	s.Register(Identity{})
+	addr := serve(t, s)
+
+	copt := *client.DefaultOption
+	copt.TLS = &transport.TLSOption{
+		CertFile:   certs.ClientCert,
+		KeyFile:    certs.ClientKey,
+		CAFile:     certs.CA,
+		ServerName: "localhost",
+	}
+	c := client.NewClient(addr, &copt)
+	defer c.Close()
+
+	var identity string
+	if err := c.Call("Identity.Get", struct{}{}, &identity); err != nil {
+		t.Fatal(err)
+	}
+	if identity != "client" {
+		t.Errorf("Identity = %q, want client", identity)
+	}
+
+	// 没有客户端证书时握手失败
+	copt.TLS = &transport.TLSOption{CAFile: certs.CA, ServerName: "localhost"}
+	anonymous := client.NewClient(addr, &copt)
+	defer anonymous.Close()
+	if err := anonymous.Call("Identity.Get", struct{}{}, &identity); err == nil {
+		t.Error("call without a client certificate succeeded")
+	}
+
+	// 不信任服务端证书的CA时握手失败
+	copt.TLS = &transport.TLSOption{CertFile: certs.ClientCert, KeyFile: certs.ClientKey, ServerName: "localhost"}
+	untrusted := client.NewClient(addr, &copt)
+	defer untrusted.Close()
+	if err := untrusted.Call("Identity.Get", struct{}{}, &identity); err == nil {
+		t.Error("call to a server with an untrusted certificate succeeded")
+	}
+}
@@ -0,0 +1,29 @@
+package server_test
+
+import (
+	"testing"
+
+	"rpc/client"
+	"rpc/codec"
+	"rpc/server"
+	"rpc/transport"
+)
+
+func TestDialHTTP(t *testing.T) {
+	s := server.NewServer(transport.HTTP, codec.JSON)
+	s.Register(&Arith{})
+	addr := serve(t, s)
+
+	c, err := client.DialHTTP(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := range 3 {
+		var reply int
+		if err := c.Call("Arith.Add", Args{i, 1}, &reply); err != nil || reply != i+1 {
+			t.Fatalf("Call = %d, %v; want %d", reply, err, i+1)
+		}
+	}
+}
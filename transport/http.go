@@ -2,70 +2,112 @@ package transport
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"strings"
 	"sync"
+	"time"
+
+	"rpc/protocol"
+)
+
+// HTTP传输的默认参数
+const (
+	DefaultHTTPPath       = "/rpc"
+	DefaultJSONRPCPath    = "/jsonrpc"       // JSON-RPC 2.0 的路径
+	DefaultRequestTimeout = 30 * time.Second // 服务端处理单个HTTP请求的最长时间
 )
 
 // HTTPTransport 实现基于HTTP的传输层
+// 每个POST请求携带一帧，作为一个只处理一次请求的连接交给Accept，响应写回HTTP应答
 type HTTPTransport struct {
 	server   *http.Server
 	listener net.Listener
+	raw      net.Listener // TLS包装前的监听器
 	addr     string
 	path     string
-	mu       sync.Mutex
-	conns    chan *HTTPConn
+	conns    chan Conn
+	closed   chan struct{}
+	once     sync.Once
+	opt      *Option
+	http2    bool // 服务端和客户端使用HTTP/2（见HTTP2Transport）
 }
 
-// HTTPConn 表示一个HTTP连接
+// HTTPConn 表示一个HTTP请求，只读出一帧、只写入一帧响应
+// 生命周期与请求绑定：请求超时或客户端断开时Context被取消，Read随之返回io.EOF
 type HTTPConn struct {
-	data []byte
-	res  chan []byte
-	err  chan error
+	data  []byte
+	ctx   context.Context
+	res   chan []byte
+	done  chan struct{}
+	once  sync.Once
+	tls   *tls.ConnectionState // HTTPS请求的连接状态
+	token string               // Authorization头中的Bearer令牌
+	raddr net.Addr             // 发起请求的客户端地址
 }
 
-// Listen 在指定地址上监听HTTP连接
-func (t *HTTPTransport) Listen(addr string) error {
-	t.addr = addr
-	t.path = "/rpc"
-	t.conns = make(chan *HTTPConn, 10) // 缓冲通道，存储连接
+// BearerToken 返回HTTP请求Authorization头中的Bearer令牌，没有时返回空字符串
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(t.path, func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
+// httpPath 返回生效的HTTP路径
+func (opt *Option) httpPath() string {
+	if opt == nil || opt.HTTPPath == "" {
+		return DefaultHTTPPath
+	}
+	return opt.HTTPPath
+}
 
-		// 读取请求体
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
+// requestTimeout 返回生效的HTTP请求超时
+func (opt *Option) requestTimeout() time.Duration {
+	if opt == nil || opt.RequestTimeout == 0 {
+		return DefaultRequestTimeout
+	}
+	return max(opt.RequestTimeout, 0)
+}
 
-		// 创建新连接
-		conn := &HTTPConn{
-			data: body,
-			res:  make(chan []byte),
-			err:  make(chan error),
-		}
+// Listen 在指定地址上监听HTTP连接
+func (t *HTTPTransport) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if err := t.ListenOn(listener); err != nil {
+		listener.Close()
+		return err
+	}
+	t.addr = addr
+	return nil
+}
 
-		// 将连接放入通道
-		t.conns <- conn
+// ListenOn 在已有的监听器上提供HTTP服务，配置了TLS时在其上启用TLS
+func (t *HTTPTransport) ListenOn(listener net.Listener) error {
+	t.addr = listener.Addr().String()
+	t.raw = listener
+	t.path = t.opt.httpPath()
+	t.conns = make(chan Conn)
+	t.closed = make(chan struct{})
 
-		// 等待响应
-		select {
-		case resp := <-conn.res:
-			w.WriteHeader(http.StatusOK)
-			w.Write(resp)
-		case err := <-conn.err:
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-		}
-	})
+	mux := http.NewServeMux()
+	mux.HandleFunc(t.path, t.serveHTTP)
+	if t.opt.Gateway != nil {
+		mux.Handle(strings.TrimSuffix(t.path, "/")+"/", t.opt.Gateway)
+	}
+	if t.opt.JSONRPC != nil && t.path != DefaultJSONRPCPath {
+		mux.Handle(DefaultJSONRPCPath, t.opt.JSONRPC)
+	}
 
 	// 监听统计接口
 	mux.HandleFunc("/debug/rpc/stats", func(w http.ResponseWriter, r *http.Request) {
@@ -77,12 +119,21 @@ func (t *HTTPTransport) Listen(addr string) error {
 		w.Write([]byte("RPC Services: Not implemented yet"))
 	})
 
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
+	if t.opt.TLS != nil {
+		config, err := t.opt.TLS.serverConfig()
+		if err != nil {
+			return err
+		}
+		if t.http2 {
+			config = withALPN(config)
+		}
+		listener = tls.NewListener(listener, config)
 	}
 
 	t.server = &http.Server{Handler: mux}
+	if t.http2 {
+		t.server.Protocols = http2Protocols(true)
+	}
 	t.listener = listener
 
 	// 启动HTTP服务器
@@ -91,99 +142,407 @@ func (t *HTTPTransport) Listen(addr string) error {
 	return nil
 }
 
+// handoff 把持久连接交给Accept，传输层已关闭时关闭连接
+func (t *HTTPTransport) handoff(conn Conn) {
+	select {
+	case t.conns <- conn:
+	case <-t.closed:
+		conn.Close()
+	}
+}
+
+// serveHTTP 处理RPC请求：校验请求、交给Accept分发，并等待唯一的一帧响应
+func (t *HTTPTransport) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// WebSocket升级请求和HTTP隧道请求建立持久连接
+	if isWebSocketUpgrade(r) {
+		conn, err := upgradeWebSocket(w, r, t.opt)
+		if err != nil {
+			return
+		}
+		t.handoff(conn)
+		return
+	}
+	if isTunnelRequest(r) {
+		conn, err := upgradeTunnel(w, r, t.opt.maxFrameSize())
+		if err != nil {
+			return
+		}
+		t.handoff(conn)
+		return
+	}
+	if isStreamRequest(r) {
+		t.serveStream(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 读取请求体，超过大小限制时返回413
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(t.opt.maxFrameSize())))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 不是合法的协议帧时直接返回400，不交给服务端
+	frame, err := protocol.DecodeFrame(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if timeout := t.opt.requestTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn := &HTTPConn{
+		data:  body,
+		ctx:   ctx,
+		res:   make(chan []byte),
+		done:  make(chan struct{}),
+		tls:   r.TLS,
+		token: BearerToken(r),
+		raddr: requestAddr(r),
+	}
+	defer conn.Close()
+
+	select {
+	case t.conns <- conn:
+	case <-t.closed:
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	case <-ctx.Done():
+		writeContextError(w, r, ctx)
+		return
+	}
+
+	select {
+	case resp := <-conn.res:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	case <-conn.done:
+		// 服务端未响应就关闭了连接：请求类消息视为内部错误，其余消息本就没有响应
+		if isRequest(frame.Header.MessageType) {
+			http.Error(w, "connection closed without response", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case <-ctx.Done():
+		writeContextError(w, r, ctx)
+	}
+}
+
+// writeContextError 请求超时返回504，客户端已断开时不再写入
+func writeContextError(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	if r.Context().Err() != nil {
+		return
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		http.Error(w, "request timeout", http.StatusGatewayTimeout)
+	}
+}
+
 // Accept 接受一个新的HTTP连接
 func (t *HTTPTransport) Accept() (Conn, error) {
 	if t.conns == nil {
 		return nil, errors.New("transport not listening")
 	}
-	// 从通道中获取一个连接
-	conn := <-t.conns
-	return conn, nil
+
+	select {
+	case conn := <-t.conns:
+		return conn, nil
+	case <-t.closed:
+		return nil, errors.New("HTTPTransport closed")
+	}
 }
 
 // Dial 连接到指定地址的HTTP服务器
 func (t *HTTPTransport) Dial(addr string) (Conn, error) {
 	client := NewHTTPClient(addr)
-	return &HTTPClientConn{client: client}, nil
+	client.maxFrameSize = t.opt.maxFrameSize()
+	client.token = t.opt.Token
+	var err error
+	if client.client, client.baseURL, err = t.httpClient(addr); err != nil {
+		return nil, err
+	}
+	client.client.Timeout = max(t.opt.RequestTimeout, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HTTPClientConn{
+		client:  client,
+		ctx:     ctx,
+		cancel:  cancel,
+		results: make(chan httpResult, 16),
+	}, nil
+}
+
+// httpClient 按配置创建连接到addr的HTTP客户端，返回客户端和RPC路径的URL
+func (t *HTTPTransport) httpClient(addr string) (*http.Client, string, error) {
+	baseURL := "http://" + addr + t.opt.httpPath()
+	if t.opt.TLS == nil && !t.http2 {
+		return &http.Client{}, baseURL, nil
+	}
+
+	tr := &http.Transport{}
+	if t.opt.TLS != nil {
+		config, err := t.opt.TLS.clientConfig(addr)
+		if err != nil {
+			return nil, "", err
+		}
+		tr.TLSClientConfig = config
+		baseURL = "https://" + addr + t.opt.httpPath()
+	}
+	// HTTP/2客户端的所有请求复用一个连接，每次调用是一个流
+	// 对HTTP/2而言MaxConnsPerHost只限制同时新建的连接数，避免并发的首批请求各自建立连接
+	if t.http2 {
+		tr.Protocols = http2Protocols(false)
+		tr.MaxConnsPerHost = 1
+	}
+	return &http.Client{Transport: tr}, baseURL, nil
+}
+
+// File 返回监听套接字的副本
+func (t *HTTPTransport) File() (*os.File, error) {
+	if t.raw == nil {
+		return nil, errors.New("transport not listening")
+	}
+	return listenerFile(t.raw)
+}
+
+// Shutdown 优雅关闭HTTP服务器：停止监听，等待进行中的请求得到响应后关闭
+// 已升级的WebSocket和隧道连接不受影响，由服务端自行关闭
+func (t *HTTPTransport) Shutdown(ctx context.Context) error {
+	if t.server == nil {
+		return nil
+	}
+
+	err := t.server.Shutdown(ctx)
+	t.once.Do(func() { close(t.closed) })
+	if err != nil {
+		t.server.Close()
+	}
+	return err
 }
 
 // Close 关闭HTTP服务器
 func (t *HTTPTransport) Close() error {
-	if t.server != nil {
-		return t.server.Close()
+	if t.server == nil {
+		return nil
 	}
-	return nil
+
+	t.once.Do(func() { close(t.closed) })
+	return t.server.Close()
 }
 
-// Read 从HTTP请求中读取数据
+// Read 第一次调用返回请求携带的帧，之后阻塞到请求结束（已响应、超时或客户端断开），返回io.EOF
 func (c *HTTPConn) Read() ([]byte, error) {
-	return c.data, nil
+	if c.data != nil {
+		data := c.data
+		c.data = nil
+		return data, nil
+	}
+
+	select {
+	case <-c.ctx.Done():
+	case <-c.done:
+	}
+	return nil, io.EOF
 }
 
-// Write 将数据写入HTTP响应
+// Write 将响应帧写入HTTP应答，每个请求只能写入一次
+// HTTP请求无法承载服务端主动发起的消息（回调、推送），写入这类帧返回错误
 func (c *HTTPConn) Write(data []byte) error {
-	c.res <- data
-	return nil
+	header, err := protocol.DecodeHeader(data)
+	if err != nil {
+		return err
+	}
+	switch header.MessageType {
+	case protocol.Response, protocol.BatchResponse, protocol.Pong:
+	default:
+		return errors.New("HTTP transport does not support server-initiated messages")
+	}
+
+	select {
+	case c.res <- data:
+		return nil
+	case <-c.done:
+		return io.ErrClosedPipe
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
 }
 
-// Close 关闭HTTP连接
+// Context 返回请求的context，请求超时或客户端断开时被取消
+func (c *HTTPConn) Context() context.Context {
+	return c.ctx
+}
+
+// ConnectionState 返回HTTPS请求的TLS连接状态，HTTP请求返回nil
+func (c *HTTPConn) ConnectionState() (*tls.ConnectionState, error) {
+	return c.tls, nil
+}
+
+// Credentials 返回请求携带的Bearer令牌
+func (c *HTTPConn) Credentials() string {
+	return c.token
+}
+
+// RemoteAddr 返回发起请求的客户端地址，无法解析时返回nil
+func (c *HTTPConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// requestAddr 解析HTTP请求的客户端地址
+func requestAddr(r *http.Request) net.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(addrPort)
+}
+
+// Close 关闭HTTP连接，未写入响应时HTTP应答按消息类型返回错误或204
 func (c *HTTPConn) Close() error {
-	close(c.res)
-	close(c.err)
+	c.once.Do(func() { close(c.done) })
 	return nil
 }
 
 // HTTPClient HTTP客户端实现
 type HTTPClient struct {
-	client  *http.Client
-	baseURL string
+	client       *http.Client
+	baseURL      string
+	maxFrameSize int                    // 响应体最大字节数
+	token        func() (string, error) // 每个请求的Bearer令牌，nil表示不发送
 }
 
 // NewHTTPClient 创建HTTP客户端
 func NewHTTPClient(addr string) *HTTPClient {
 	return &HTTPClient{
-		client:  &http.Client{},
-		baseURL: "http://" + addr + "/rpc",
+		client:       &http.Client{},
+		baseURL:      "http://" + addr + DefaultHTTPPath,
+		maxFrameSize: DefaultMaxFrameSize,
 	}
 }
 
-// Call 发送HTTP请求
-func (c *HTTPClient) Call(data []byte) ([]byte, error) {
-	resp, err := c.client.Post(c.baseURL, "application/octet-stream", bytes.NewReader(data))
+// Call 发送HTTP请求，服务端没有响应帧（204）时返回nil
+func (c *HTTPClient) Call(ctx context.Context, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if c.token != nil {
+		token, err := c.token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("HTTP error: " + resp.Status)
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("HTTP error: %s: %s", resp.Status, bytes.TrimSpace(msg))
 	}
 
-	return io.ReadAll(resp.Body)
+	// 多读一个字节以判断响应是否超过大小限制
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(c.maxFrameSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > c.maxFrameSize {
+		size := uint64(len(body))
+		if resp.ContentLength > 0 {
+			size = uint64(resp.ContentLength)
+		}
+		return nil, &FrameTooLargeError{Size: size, Limit: c.maxFrameSize}
+	}
+	return body, nil
 }
 
 // HTTPClientConn 是HTTP客户端的连接
+// 每次Write并发发送一个POST请求，响应或错误放入队列供Read读取
 type HTTPClientConn struct {
-	client *HTTPClient
-	data   []byte
+	client  *HTTPClient
+	ctx     context.Context // 连接关闭时取消，中止进行中的请求
+	cancel  context.CancelFunc
+	results chan httpResult
+}
+
+// httpResult 一个HTTP请求的结果
+type httpResult struct {
+	data []byte
+	err  error
 }
 
-// Read 从HTTP服务器读取数据
+// Read 读取下一个响应，阻塞直到有响应或连接关闭
+// 请求失败时返回FrameError，其中的消息头对应该请求的响应
 func (c *HTTPClientConn) Read() ([]byte, error) {
-	return c.data, nil
+	select {
+	case r := <-c.results:
+		return r.data, r.err
+	case <-c.ctx.Done():
+		return nil, io.EOF
+	}
 }
 
-// Write 将数据写入HTTP服务器并获取响应
+// Write 发送一个HTTP请求，不等待响应
 func (c *HTTPClientConn) Write(data []byte) error {
-	resp, err := c.client.Call(data)
-	if err != nil {
-		return err
+	if c.ctx.Err() != nil {
+		return io.ErrClosedPipe
 	}
-	c.data = resp
+
+	go func() {
+		resp, err := c.client.Call(c.ctx, data)
+		if err == nil && resp == nil {
+			return
+		}
+
+		r := httpResult{data: resp}
+		if err != nil {
+			r.err = &FrameError{Head: responseHead(data), Err: err}
+		}
+		select {
+		case c.results <- r:
+		case <-c.ctx.Done():
+		}
+	}()
 	return nil
 }
 
-// Close 关闭HTTP连接
+// responseHead 根据请求帧构造对应响应的消息头，无法解析时返回nil
+func responseHead(data []byte) []byte {
+	header, err := protocol.DecodeHeader(data)
+	if err != nil || !isRequest(header.MessageType) {
+		return nil
+	}
+	header.MessageType = protocol.Response
+	return protocol.EncodeHeader(header)
+}
+
+// Close 关闭HTTP连接，中止进行中的请求
 func (c *HTTPClientConn) Close() error {
+	c.cancel()
 	return nil
 }
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net/http"
+	"slices"
+)
+
+// HTTP2Transport 实现基于HTTP/2的传输层：明文时使用h2c（先验知识，不经过Upgrade），配置了TLS时通过ALPN协商h2
+// 与HTTPTransport相同，每次调用是一个POST请求，但所有请求作为独立的流复用同一个连接，由HTTP/2负责流量控制
+// 一元调用的流无法承载服务端主动发起的消息（回调、推送、发布订阅），需要时使用HTTP2StreamTransport
+// 服务端仍接受HTTP/1.1请求，因此HTTP、WebSocket和HTTP隧道客户端可以连接同一个服务端，也接受HTTP2StreamTransport的流
+type HTTP2Transport struct {
+	HTTPTransport
+}
+
+// http2Protocols 返回HTTP/2传输使用的协议集合，server为true时同时保留HTTP/1.1
+func http2Protocols(server bool) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(server)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+// withALPN 让TLS配置（包括按客户端生成的配置）通过ALPN提供h2和http/1.1
+func withALPN(config *tls.Config) *tls.Config {
+	protos := []string{"h2", "http/1.1"}
+	config.NextProtos = protos
+
+	getConfig := config.GetConfigForClient
+	if getConfig != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := getConfig(hello)
+			if err != nil || c == nil {
+				return c, err
+			}
+			c.NextProtos = slices.Clone(protos)
+			return c, nil
+		}
+	}
+	return config
+}
@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"rpc/protocol"
+)
+
+// listenHTTP2 在本地空闲端口上监听HTTP/2，返回传输层和监听地址
+func listenHTTP2(t *testing.T) (Transport, string) {
+	t.Helper()
+	tr := NewTransportWithOption(HTTP2, nil)
+	if err := tr.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr, tr.(*HTTP2Transport).listener.Addr().String()
+}
+
+func TestHTTP2ConcurrentCalls(t *testing.T) {
+	tr, addr := listenHTTP2(t)
+	go func() {
+		for {
+			conn, err := tr.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, _ := conn.Read()
+				conn.Write(respond(req, "ok"))
+			}()
+		}
+	}()
+
+	cli, err := NewTransportWithOption(HTTP2, nil).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	const calls = 20
+	for seq := uint64(1); seq <= calls; seq++ {
+		cli.Write(udpFrame(protocol.Request, seq, "call"))
+	}
+	seen := make(map[uint64]bool)
+	for range calls {
+		data, err := cli.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _ := protocol.DecodeHeader(data)
+		seen[header.Seq] = true
+	}
+	if len(seen) != calls {
+		t.Errorf("got %d distinct responses, want %d", len(seen), calls)
+	}
+}
+
+// dialStream 建立一条HTTP/2流，返回客户端和服务端两端的连接
+func dialStream(t *testing.T) (client, server Conn) {
+	t.Helper()
+	tr, addr := listenHTTP2(t)
+	accepted := make(chan Conn, 1)
+	go func() {
+		if c, err := tr.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+
+	client, err := NewTransportWithOption(HTTP2Stream, nil).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	select {
+	case server = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the stream")
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestHTTP2StreamBidirectional(t *testing.T) {
+	client, server := dialStream(t)
+
+	if err := client.Write(udpFrame(protocol.Request, 1, "call")); err != nil {
+		t.Fatal(err)
+	}
+	req, err := server.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端在同一个流上先发起回调请求，再返回响应
+	if err := server.Write(udpFrame(protocol.Request, 100, "callback")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Write(respond(req, "done")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []protocol.MessageType{protocol.Request, protocol.Response} {
+		data, err := client.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header, _ := protocol.DecodeHeader(data); header.MessageType != want {
+			t.Fatalf("client read message type %d, want %d", header.MessageType, want)
+		}
+	}
+
+	// 服务端关闭流后客户端读到错误
+	server.Close()
+	if _, err := client.Read(); err == nil {
+		t.Error("client Read succeeded after the server closed the stream")
+	}
+}
+
+func TestHTTP2StreamCloseDuringWrites(t *testing.T) {
+	client, server := dialStream(t)
+
+	// 客户端不读取，服务端的写入最终阻塞在流量控制上；Close必须唤醒它们且之后不再写入
+	payload := string(bytes.Repeat([]byte{'x'}, 64<<10))
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for server.Write(udpFrame(protocol.Request, 1, payload)) == nil {
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		server.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked behind a write")
+	}
+	wg.Wait()
+	client.Close()
+}
+
+func TestHTTP2StreamRequiresHTTP2(t *testing.T) {
+	_, addr := listenHTTP2(t)
+	resp, err := http.Post("http://"+addr+DefaultHTTPPath, StreamContentType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusHTTPVersionNotSupported {
+		t.Errorf("HTTP/1.1 stream request: status %d, want 505", resp.StatusCode)
+	}
+}
@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StreamContentType HTTP2StreamTransport建立流的请求和应答使用的Content-Type
+const StreamContentType = "application/x-rpc-stream"
+
+// HTTP2StreamTransport 实现基于单个HTTP/2流的传输层：客户端发出一个不结束的POST请求，
+// 之后请求体和应答体双向同时收发与TCP传输相同的带长度前缀的二进制帧
+// 连接是持久的，支持多路复用的调用、回调和服务端推送，同时经过HTTP/2的流量控制，可以穿过只转发HTTP/2的基础设施
+// 服务端与HTTP2Transport相同（两者的客户端可以连接同一个服务端），只接受HTTP/2上的流请求
+type HTTP2StreamTransport struct {
+	HTTPTransport
+}
+
+// HTTP2StreamConn HTTP/2流上的连接
+type HTTP2StreamConn struct {
+	*TCPConn
+	tls *tls.ConnectionState // HTTPS请求的连接状态（服务端）
+}
+
+// ConnectionState 返回建立流的HTTPS请求的TLS连接状态，明文（h2c）时返回nil
+func (c *HTTP2StreamConn) ConnectionState() (*tls.ConnectionState, error) {
+	return c.tls, nil
+}
+
+// isStreamRequest 判断HTTP请求是否要求建立流
+func isStreamRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.Header.Get("Content-Type") == StreamContentType
+}
+
+// serveStream 应答流请求并把流交给Accept，阻塞到流关闭或客户端断开
+func (t *HTTPTransport) serveStream(w http.ResponseWriter, r *http.Request) {
+	// HTTP/1.1的请求体和应答体不能可靠地同时收发
+	if r.ProtoMajor != 2 {
+		http.Error(w, "streaming requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", StreamContentType)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	stream := &h2Stream{
+		reader: r.Body,
+		writer: w,
+		flush:  rc.Flush,
+		// 让阻塞在流量控制上的写入立即返回
+		abort:  func() { rc.SetWriteDeadline(time.Now()) },
+		done:   make(chan struct{}),
+		remote: requestAddr(r),
+	}
+	t.handoff(&HTTP2StreamConn{
+		TCPConn: &TCPConn{conn: stream, maxFrameSize: t.opt.maxFrameSize()},
+		tls:     r.TLS,
+	})
+
+	// 处理函数返回后不能再写入应答，先关闭流；done在进行中的写入结束后才关闭
+	select {
+	case <-stream.done:
+	case <-r.Context().Done():
+		stream.Close()
+	}
+}
+
+// Dial 建立到服务器的HTTP/2流，addr为 host:port，路径为Option.HTTPPath
+func (t *HTTP2StreamTransport) Dial(addr string) (Conn, error) {
+	client, url, err := t.httpClient(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// ctx贯穿整个流的生命周期，只有建立阶段有超时
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(tunnelHandshakeTimeout, cancel)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", StreamContentType)
+
+	resp, err := client.Do(req)
+	if !timer.Stop() && err == nil {
+		resp.Body.Close()
+		err = errors.New("stream handshake timeout")
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("HTTP/2 stream: unexpected status %s", resp.Status)
+	}
+
+	stream := &h2Stream{
+		reader: resp.Body,
+		writer: pw,
+		abort: func() {
+			pw.Close()
+			cancel()
+		},
+		done: make(chan struct{}),
+	}
+	return &HTTP2StreamConn{TCPConn: &TCPConn{conn: stream, maxFrameSize: t.opt.maxFrameSize()}}, nil
+}
+
+// h2Stream 把HTTP/2流的两个方向包装为net.Conn，以复用TCPConn的帧格式
+type h2Stream struct {
+	reader io.ReadCloser // 服务端为请求体，客户端为应答体
+	writer io.Writer     // 服务端为应答，客户端为请求体的管道
+	flush  func() error  // 服务端每次写入后刷新应答，客户端为nil
+	abort  func()        // 唤醒阻塞中的写入：服务端设置写入超时，客户端关闭请求体管道并中止请求
+	remote net.Addr      // 对端地址（服务端）
+
+	mu      sync.Mutex // 保护写入和closed
+	closed  bool
+	closing atomic.Bool   // Close已开始，之后的读取错误视为流结束
+	done    chan struct{} // 流已关闭且没有进行中的写入时关闭
+	once    sync.Once
+}
+
+// Read 读取对端发来的数据，流已关闭时返回io.EOF
+func (s *h2Stream) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if err != nil && s.closing.Load() {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *h2Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := s.writer.Write(p)
+	if err == nil && s.flush != nil {
+		err = s.flush()
+	}
+	return n, err
+}
+
+// Close 关闭流的两个方向，可以重复调用；返回后不会再写入
+func (s *h2Stream) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.closing.Store(true)
+		// 先唤醒阻塞在写入上的调用，再等待进行中的写入结束，最后通知done
+		// 服务端的处理函数在done关闭后返回，此时不能再有写入，否则net/http会panic
+		s.abort()
+		err = s.reader.Close()
+
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		close(s.done)
+	})
+	<-s.done
+	return err
+}
+
+func (s *h2Stream) LocalAddr() net.Addr                { return nil }
+func (s *h2Stream) RemoteAddr() net.Addr               { return s.remote }
+func (s *h2Stream) SetDeadline(t time.Time) error      { return nil }
+func (s *h2Stream) SetReadDeadline(t time.Time) error  { return nil }
+func (s *h2Stream) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"rpc/protocol"
+)
+
+// listenHTTP 在本地空闲端口上监听HTTP，返回传输层和监听地址
+func listenHTTP(t *testing.T, opt *Option) (*HTTPTransport, string) {
+	t.Helper()
+	tr := NewTransportWithOption(HTTP, opt).(*HTTPTransport)
+	if err := tr.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr, tr.listener.Addr().String()
+}
+
+// respond 把请求帧改为同序号的响应帧
+func respond(req []byte, payload string) []byte {
+	header, _ := protocol.DecodeHeader(req)
+	return udpFrame(protocol.Response, header.Seq, payload)
+}
+
+func TestHTTPConcurrentRequests(t *testing.T) {
+	tr, addr := listenHTTP(t, nil)
+
+	// 第1个请求在客户端收到第2个请求的响应之后才响应，两个请求必须同时在处理中
+	release := make(chan struct{})
+	go func() {
+		for range 2 {
+			conn, err := tr.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, _ := conn.Read()
+				if header, _ := protocol.DecodeHeader(req); header.Seq == 1 {
+					<-release
+				}
+				conn.Write(respond(req, "ok"))
+			}()
+		}
+	}()
+
+	cli, err := NewTransportWithOption(HTTP, nil).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	cli.Write(udpFrame(protocol.Request, 1, "first"))
+	cli.Write(udpFrame(protocol.Request, 2, "second"))
+
+	for _, want := range []uint64{2, 1} {
+		data, err := cli.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header, _ := protocol.DecodeHeader(data); header.Seq != want {
+			t.Fatalf("got response %d, want %d", header.Seq, want)
+		}
+		if want == 2 {
+			close(release)
+		}
+	}
+}
+
+func TestHTTPRequestTimeout(t *testing.T) {
+	tr, addr := listenHTTP(t, &Option{RequestTimeout: 50 * time.Millisecond})
+
+	// 服务端接受请求但不响应，请求结束后Read返回EOF
+	readEOF := make(chan error, 1)
+	go func() {
+		conn, err := tr.Accept()
+		if err != nil {
+			return
+		}
+		conn.Read()
+		_, err = conn.Read()
+		readEOF <- err
+	}()
+
+	cli, err := NewTransportWithOption(HTTP, nil).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	cli.Write(udpFrame(protocol.Request, 7, "slow"))
+
+	_, err = cli.Read()
+	var frameErr *FrameError
+	if !errors.As(err, &frameErr) {
+		t.Fatalf("Read = %v, want FrameError", err)
+	}
+	if header, _ := protocol.DecodeHeader(frameErr.Head); header == nil || header.Seq != 7 || header.MessageType != protocol.Response {
+		t.Errorf("FrameError.Head = %+v, want response header for seq 7", header)
+	}
+	if !bytes.Contains([]byte(err.Error()), []byte("504")) {
+		t.Errorf("error %q does not mention status 504", err)
+	}
+	if err := <-readEOF; !errors.Is(err, io.EOF) {
+		t.Errorf("server Read after timeout = %v, want EOF", err)
+	}
+}
+
+func TestHTTPStatusCodes(t *testing.T) {
+	tr, addr := listenHTTP(t, &Option{MaxFrameSize: 64})
+	url := "http://" + addr + DefaultHTTPPath
+
+	// 服务端不写响应就关闭连接
+	go func() {
+		for {
+			conn, err := tr.Accept()
+			if err != nil {
+				return
+			}
+			conn.Read()
+			conn.Close()
+		}
+	}()
+
+	post := func(body []byte) int {
+		resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", resp.StatusCode)
+	}
+	if code := post([]byte("not a frame")); code != http.StatusBadRequest {
+		t.Errorf("invalid frame: status %d, want 400", code)
+	}
+	if code := post(udpFrame(protocol.Request, 1, string(make([]byte, 64)))); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized frame: status %d, want 413", code)
+	}
+	if code := post(udpFrame(protocol.Request, 1, "")); code != http.StatusInternalServerError {
+		t.Errorf("unanswered request: status %d, want 500", code)
+	}
+	if code := post(udpFrame(protocol.Ping, 1, "")); code != http.StatusNoContent {
+		t.Errorf("unanswered ping: status %d, want 204", code)
+	}
+}
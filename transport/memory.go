@@ -0,0 +1,227 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"rpc/protocol"
+)
+
+// MemoryScheme 进程内传输的地址前缀，如 mem://orders，服务端和客户端通过名字相连
+const MemoryScheme = "mem://"
+
+// memoryQueueSize 每个方向上缓冲的帧数，超过后写入阻塞直到对端读取
+const memoryQueueSize = 64
+
+// memoryListeners 进程内所有正在监听的名字
+var (
+	memoryMu        sync.Mutex
+	memoryListeners = make(map[string]*MemoryTransport)
+)
+
+// MemoryTransport 实现进程内的传输层，不使用任何套接字，用于测试和同进程内的服务
+// Dial方可通过Option.Latency和Option.Bandwidth模拟网络延迟和带宽
+type MemoryTransport struct {
+	name    string
+	opt     *Option
+	accepts chan *MemoryConn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// MemoryConn 表示一个进程内连接，每次Write的数据作为一帧整体交给对端
+type MemoryConn struct {
+	in, out      *memoryPipe
+	maxFrameSize int
+	closed       chan struct{} // 连接两端共享，任一端关闭后两端都不可用
+	once         *sync.Once
+}
+
+// memoryPipe 连接的一个方向
+type memoryPipe struct {
+	frames    chan memoryFrame
+	latency   time.Duration
+	bandwidth int
+	mu        sync.Mutex
+	busyUntil time.Time // 带宽限制下，前面的帧发送完成的时间
+}
+
+// memoryFrame 正在传输的帧
+type memoryFrame struct {
+	data      []byte
+	deliverAt time.Time // 对端可以读到该帧的时间
+}
+
+// parseMemoryAddr 去掉mem://前缀，返回名字
+func parseMemoryAddr(addr string) (string, error) {
+	name := strings.TrimPrefix(addr, MemoryScheme)
+	if name == "" {
+		return "", errors.New("empty memory transport name")
+	}
+	return name, nil
+}
+
+// Listen 以指定名字登记监听，同一名字同时只能有一个监听者
+func (t *MemoryTransport) Listen(addr string) error {
+	name, err := parseMemoryAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	if _, exists := memoryListeners[name]; exists {
+		return fmt.Errorf("memory transport %s already in use", name)
+	}
+
+	t.name = name
+	t.accepts = make(chan *MemoryConn)
+	t.closed = make(chan struct{})
+	memoryListeners[name] = t
+	return nil
+}
+
+// Accept 接受一个进程内连接
+func (t *MemoryTransport) Accept() (Conn, error) {
+	if t.accepts == nil {
+		return nil, errors.New("transport not listening")
+	}
+
+	select {
+	case c := <-t.accepts:
+		return c, nil
+	case <-t.closed:
+		return nil, errors.New("memory transport closed")
+	}
+}
+
+// Dial 连接到指定名字的监听者，阻塞直到对方Accept
+func (t *MemoryTransport) Dial(addr string) (Conn, error) {
+	name, err := parseMemoryAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	memoryMu.Lock()
+	listener := memoryListeners[name]
+	memoryMu.Unlock()
+	if listener == nil {
+		return nil, fmt.Errorf("memory transport %s not listening", name)
+	}
+
+	// 链路特性由Dial方决定，两个方向相同
+	newPipe := func() *memoryPipe {
+		return &memoryPipe{
+			frames:    make(chan memoryFrame, memoryQueueSize),
+			latency:   t.opt.Latency,
+			bandwidth: t.opt.Bandwidth,
+		}
+	}
+	up, down := newPipe(), newPipe()
+	closed := make(chan struct{})
+	once := &sync.Once{}
+
+	client := &MemoryConn{in: down, out: up, maxFrameSize: t.opt.maxFrameSize(), closed: closed, once: once}
+	server := &MemoryConn{in: up, out: down, maxFrameSize: listener.opt.maxFrameSize(), closed: closed, once: once}
+
+	select {
+	case listener.accepts <- server:
+		return client, nil
+	case <-listener.closed:
+		return nil, fmt.Errorf("memory transport %s not listening", name)
+	}
+}
+
+// Close 注销名字，不影响已建立的连接
+func (t *MemoryTransport) Close() error {
+	if t.closed == nil {
+		return nil
+	}
+
+	t.once.Do(func() {
+		memoryMu.Lock()
+		if memoryListeners[t.name] == t {
+			delete(memoryListeners, t.name)
+		}
+		memoryMu.Unlock()
+		close(t.closed)
+	})
+	return nil
+}
+
+// Read 读取对端写入的下一帧，模拟延迟时等到该帧到达为止
+func (c *MemoryConn) Read() ([]byte, error) {
+	var frame memoryFrame
+	select {
+	case frame = <-c.in.frames:
+	case <-c.closed:
+		return nil, io.EOF
+	}
+
+	if wait := time.Until(frame.deliverAt); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-c.closed:
+			return nil, io.EOF
+		}
+	}
+
+	if len(frame.data) > c.maxFrameSize {
+		head := frame.data[:min(len(frame.data), protocol.HeaderSize)]
+		return nil, &FrameTooLargeError{Size: uint64(len(frame.data)), Limit: c.maxFrameSize, Head: head}
+	}
+	return frame.data, nil
+}
+
+// Write 向对端发送一帧，带宽受限时阻塞到该帧发送完成
+func (c *MemoryConn) Write(data []byte) error {
+	// 复制数据，避免调用方复用缓冲区影响对端
+	frame := memoryFrame{data: append([]byte(nil), data...)}
+
+	// 关闭后队列可能仍有空位，先检查，避免select随机选中入队
+	select {
+	case <-c.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	p := c.out
+	p.mu.Lock()
+	sent := time.Now()
+	if p.bandwidth > 0 {
+		if p.busyUntil.After(sent) {
+			sent = p.busyUntil
+		}
+		sent = sent.Add(time.Duration(int64(len(data)) * int64(time.Second) / int64(p.bandwidth)))
+		p.busyUntil = sent
+	}
+	frame.deliverAt = sent.Add(p.latency)
+
+	// 持有锁入队，保证帧的顺序与到达时间一致
+	select {
+	case p.frames <- frame:
+	case <-c.closed:
+		p.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+	p.mu.Unlock()
+
+	if wait := time.Until(sent); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-c.closed:
+			return io.ErrClosedPipe
+		}
+	}
+	return nil
+}
+
+// Close 关闭连接，对端随后的读写返回错误
+func (c *MemoryConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// memoryPair 在指定名字上监听，返回Dial方和Accept方的连接
+func memoryPair(t *testing.T, name string, opt *Option) (Conn, Conn) {
+	t.Helper()
+	srv := NewTransportWithOption(Memory, nil)
+	if err := srv.Listen(MemoryScheme + name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	accepted := make(chan Conn, 1)
+	go func() {
+		if c, err := srv.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	cli, err := NewTransportWithOption(Memory, opt).Dial(MemoryScheme + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	t.Cleanup(func() { cli.Close() })
+	return cli, conn
+}
+
+func TestMemoryRoundTrip(t *testing.T) {
+	cli, conn := memoryPair(t, "round-trip", nil)
+
+	buf := []byte("request")
+	if err := cli.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	buf[0] = 'X' // 写入后修改缓冲区不影响对端
+	if data, err := conn.Read(); err != nil || string(data) != "request" {
+		t.Fatalf("server Read = %q, %v", data, err)
+	}
+	if err := conn.Write([]byte("response")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cli.Read(); err != nil || string(data) != "response" {
+		t.Fatalf("client Read = %q, %v", data, err)
+	}
+
+	// 任一端关闭后两端都不可用
+	conn.Close()
+	if _, err := cli.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("Read after close = %v, want EOF", err)
+	}
+	if err := cli.Write([]byte("late")); err == nil {
+		t.Error("Write after close succeeded")
+	}
+}
+
+func TestMemoryListen(t *testing.T) {
+	srv := NewTransportWithOption(Memory, nil)
+	if err := srv.Listen(MemoryScheme + "taken"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTransportWithOption(Memory, nil).Listen(MemoryScheme + "taken"); err == nil {
+		t.Error("two listeners on the same name")
+	}
+	srv.Close()
+
+	if _, err := NewTransportWithOption(Memory, nil).Dial(MemoryScheme + "taken"); err == nil {
+		t.Error("Dial succeeded after the listener closed")
+	}
+	again := NewTransportWithOption(Memory, nil)
+	if err := again.Listen(MemoryScheme + "taken"); err != nil {
+		t.Errorf("Listen after Close: %v", err)
+	}
+	again.Close()
+}
+
+func TestMemoryLatencyAndBandwidth(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	cli, conn := memoryPair(t, "slow", &Option{Latency: latency, Bandwidth: 10000})
+
+	// 1000字节在10000字节/秒下需要100ms发送，再经过50ms延迟到达
+	start := time.Now()
+	if err := cli.Write(bytes.Repeat([]byte{'x'}, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Write returned after %v, want about 100ms", elapsed)
+	}
+	if _, err := conn.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("frame arrived after %v, want about 150ms", elapsed)
+	}
+}
+
+func TestMemoryFrameTooLarge(t *testing.T) {
+	srv := NewTransportWithOption(Memory, &Option{MaxFrameSize: 16})
+	if err := srv.Listen(MemoryScheme + "limited"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	accepted := make(chan Conn, 1)
+	go func() {
+		c, _ := srv.Accept()
+		accepted <- c
+	}()
+	cli, err := NewTransportWithOption(Memory, nil).Dial(MemoryScheme + "limited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	conn := <-accepted
+
+	cli.Write(bytes.Repeat([]byte{'x'}, 32))
+	cli.Write([]byte("small"))
+	var tooLarge *FrameTooLargeError
+	if _, err := conn.Read(); !errors.As(err, &tooLarge) {
+		t.Fatalf("Read = %v, want FrameTooLargeError", err)
+	}
+	if data, err := conn.Read(); err != nil || string(data) != "small" {
+		t.Fatalf("Read after oversized frame = %q, %v", data, err)
+	}
+}
@@ -1,20 +1,29 @@
 package transport
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
 	"net"
+	"os"
+
+	"rpc/protocol"
 )
 
-// TCPTransport 实现基于TCP的传输层
+// TCPTransport 实现基于TCP的传输层，配置了TLS时使用TLS加密
 type TCPTransport struct {
 	listener net.Listener
+	raw      net.Listener // TLS包装前的监听器
+	opt      *Option
 }
 
 // TCPConn 表示一个TCP连接
 type TCPConn struct {
-	conn net.Conn
+	conn         net.Conn
+	reader       io.Reader // 读取数据的来源，nil表示直接从conn读取（HTTP隧道的连接可能已有缓冲数据）
+	maxFrameSize int       // 读取的单帧最大字节数
 }
 
 // Listen 在指定地址上监听TCP连接
@@ -23,10 +32,49 @@ func (t *TCPTransport) Listen(addr string) error {
 	if err != nil {
 		return err
 	}
+	if err := t.ListenOn(listener); err != nil {
+		listener.Close()
+		return err
+	}
+	return nil
+}
+
+// ListenOn 在已有的流式监听器（TCP、Unix域套接字或自定义实现）上接受连接，配置了TLS时在其上启用TLS
+func (t *TCPTransport) ListenOn(listener net.Listener) error {
+	t.raw = listener
+	// 握手在连接首次读写或调用ConnectionState时进行，不阻塞Accept
+	if t.opt.TLS != nil {
+		config, err := t.opt.TLS.serverConfig()
+		if err != nil {
+			return err
+		}
+		listener = tls.NewListener(listener, config)
+	}
+
 	t.listener = listener
 	return nil
 }
 
+// File 返回监听套接字的副本
+func (t *TCPTransport) File() (*os.File, error) {
+	if t.raw == nil {
+		return nil, errors.New("transport not listening")
+	}
+	return listenerFile(t.raw)
+}
+
+// ServerConn 把已建立的流式连接包装为服务端连接，按长度前缀收发帧；配置了TLS时作为TLS服务端握手
+func ServerConn(conn net.Conn, opt *Option) (Conn, error) {
+	if opt != nil && opt.TLS != nil {
+		config, err := opt.TLS.serverConfig()
+		if err != nil {
+			return nil, err
+		}
+		conn = tls.Server(conn, config)
+	}
+	return &TCPConn{conn: conn, maxFrameSize: opt.maxFrameSize()}, nil
+}
+
 // Accept 接受一个新的TCP连接
 func (t *TCPTransport) Accept() (Conn, error) {
 	if t.listener == nil {
@@ -36,16 +84,29 @@ func (t *TCPTransport) Accept() (Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &TCPConn{conn: conn}, nil
+	return &TCPConn{conn: conn, maxFrameSize: t.opt.maxFrameSize()}, nil
 }
 
 // Dial 连接到指定地址的TCP服务器
 func (t *TCPTransport) Dial(addr string) (Conn, error) {
+	if t.opt.TLS != nil {
+		config, err := t.opt.TLS.clientConfig(addr)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{Timeout: tlsHandshakeTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+		if err != nil {
+			return nil, err
+		}
+		return &TCPConn{conn: conn, maxFrameSize: t.opt.maxFrameSize()}, nil
+	}
+
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	return &TCPConn{conn: conn}, nil
+	return &TCPConn{conn: conn, maxFrameSize: t.opt.maxFrameSize()}, nil
 }
 
 // Close 关闭TCP监听器
@@ -60,24 +121,53 @@ func (t *TCPTransport) Close() error {
 func (c *TCPConn) Read() ([]byte, error) {
 	// 首先读取数据长度（4字节）
 	sizeBuf := make([]byte, 4)
-	if _, err := io.ReadFull(c.conn, sizeBuf); err != nil {
+	if _, err := io.ReadFull(c.source(), sizeBuf); err != nil {
 		return nil, err
 	}
 
 	// 解析数据长度
 	size := binary.BigEndian.Uint32(sizeBuf)
 
+	// 长度来自对端，超过限制时不分配内存，丢弃数据后返回错误，连接仍可继续使用
+	if uint64(size) > uint64(c.maxFrameSize) {
+		return nil, c.discard(size)
+	}
+
 	// 读取实际数据
 	data := make([]byte, size)
-	if _, err := io.ReadFull(c.conn, data); err != nil {
+	if _, err := io.ReadFull(c.source(), data); err != nil {
 		return nil, err
 	}
 
 	return data, nil
 }
 
+// source 返回读取数据的来源
+func (c *TCPConn) source() io.Reader {
+	if c.reader != nil {
+		return c.reader
+	}
+	return c.conn
+}
+
+// discard 读取超限帧的开头部分后丢弃其余数据
+func (c *TCPConn) discard(size uint32) error {
+	head := make([]byte, min(int(size), protocol.HeaderSize))
+	if _, err := io.ReadFull(c.source(), head); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, c.source(), int64(size)-int64(len(head))); err != nil {
+		return err
+	}
+	return &FrameTooLargeError{Size: uint64(size), Limit: c.maxFrameSize, Head: head}
+}
+
 // Write 将数据写入TCP连接
 func (c *TCPConn) Write(data []byte) error {
+	if uint64(len(data)) > math.MaxUint32 {
+		return errors.New("frame too large for length prefix")
+	}
+
 	// 先写入数据长度（4字节）
 	sizeBuf := make([]byte, 4)
 	binary.BigEndian.PutUint32(sizeBuf, uint32(len(data)))
@@ -92,6 +182,20 @@ func (c *TCPConn) Write(data []byte) error {
 	return err
 }
 
+// ConnectionState 完成TLS握手并返回连接状态，未使用TLS时返回nil
+func (c *TCPConn) ConnectionState() (*tls.ConnectionState, error) {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	return handshake(tlsConn)
+}
+
+// RemoteAddr 返回对端地址
+func (c *TCPConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
 // Close 关闭TCP连接
 func (c *TCPConn) Close() error {
 	return c.conn.Close()
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestTCPFrameTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	w := &TCPConn{conn: client, maxFrameSize: DefaultMaxFrameSize}
+	r := &TCPConn{conn: server, maxFrameSize: 64}
+
+	large := bytes.Repeat([]byte{'x'}, 100)
+	small := []byte("small frame")
+	go func() {
+		w.Write(large)
+		w.Write(small)
+	}()
+
+	_, err := r.Read()
+	var tooLarge *FrameTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("Read = %v, want FrameTooLargeError", err)
+	}
+	if tooLarge.Size != 100 || tooLarge.Limit != 64 || !bytes.Equal(tooLarge.Head, large[:len(tooLarge.Head)]) {
+		t.Errorf("unexpected error details: %+v", tooLarge)
+	}
+
+	// 超限的帧被丢弃后连接仍可继续读取
+	data, err := r.Read()
+	if err != nil || !bytes.Equal(data, small) {
+		t.Fatalf("Read after oversized frame = %q, %v", data, err)
+	}
+}
+
+func TestTCPFileHandoff(t *testing.T) {
+	old := &TCPTransport{opt: &Option{}}
+	if err := old.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	addr := old.raw.Addr().String()
+
+	f, err := old.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &TCPTransport{opt: &Option{}}
+	if err := next.ListenOn(l); err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+
+	// 旧监听器关闭后，继承的套接字仍在同一地址上接受连接
+	old.Close()
+	cli, err := next.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	conn, err := next.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := cli.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := conn.Read(); err != nil || string(data) != "hello" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+}
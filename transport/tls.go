@@ -0,0 +1,220 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLS相关的默认参数
+const (
+	DefaultTLSReloadInterval = 10 * time.Second // 检查证书文件是否变化的间隔
+	tlsHandshakeTimeout      = 10 * time.Second // TLS握手超时
+)
+
+// TLSOption TCP和HTTP传输的TLS配置，证书文件变化后自动重新加载，无需重启
+type TLSOption struct {
+	CertFile string // 证书文件（PEM），服务端必填，客户端用于双向认证
+	KeyFile  string // 私钥文件（PEM）
+	CAFile   string // 验证对端证书的CA文件（PEM），客户端为空时使用系统根证书
+
+	ServerName         string        // 客户端：SNI及校验证书使用的服务器名，为空时取拨号地址中的主机名
+	MinVersion         uint16        // 最低TLS版本，0表示TLS 1.2
+	CipherSuites       []uint16      // TLS 1.2的密码套件，按优先级排列，空表示使用默认值（TLS 1.3的套件不可配置）
+	ClientAuth         bool          // 服务端：要求客户端提供由CAFile签发的证书（双向TLS）
+	InsecureSkipVerify bool          // 客户端：不验证服务器证书，仅用于测试
+	ReloadInterval     time.Duration // 检查证书文件是否变化的间隔，0表示使用DefaultTLSReloadInterval
+}
+
+// TLSConn 可以提供TLS连接状态的连接
+type TLSConn interface {
+	// ConnectionState 完成握手并返回连接状态，非TLS连接返回nil
+	ConnectionState() (*tls.ConnectionState, error)
+}
+
+// tlsFiles 从磁盘加载证书和CA，文件修改后重新加载
+type tlsFiles struct {
+	opt       *TLSOption
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  []time.Time // 各文件上次加载时的修改时间
+	lastCheck time.Time
+}
+
+// newTLSFiles 加载证书文件，首次加载失败时返回错误
+func newTLSFiles(opt *TLSOption) (*tlsFiles, error) {
+	f := &tlsFiles{opt: opt}
+	modTimes, err := f.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(modTimes); err != nil {
+		return nil, err
+	}
+	f.lastCheck = time.Now()
+	return f, nil
+}
+
+// stat 获取各文件的修改时间
+func (f *tlsFiles) stat() ([]time.Time, error) {
+	files := []string{f.opt.CertFile, f.opt.KeyFile, f.opt.CAFile}
+	modTimes := make([]time.Time, len(files))
+	for i, name := range files {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load 读取证书、私钥和CA
+func (f *tlsFiles) load(modTimes []time.Time) error {
+	var cert *tls.Certificate
+	if f.opt.CertFile != "" || f.opt.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(f.opt.CertFile, f.opt.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate error: %v", err)
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if f.opt.CAFile != "" {
+		pem, err := os.ReadFile(f.opt.CAFile)
+		if err != nil {
+			return fmt.Errorf("load CA error: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", f.opt.CAFile)
+		}
+	}
+
+	f.cert, f.pool, f.modTimes = cert, pool, modTimes
+	return nil
+}
+
+// current 返回当前的证书和CA，距上次检查超过ReloadInterval且文件有变化时重新加载
+// 重新加载失败时继续使用旧的证书
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	interval := f.opt.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultTLSReloadInterval
+	}
+	if time.Since(f.lastCheck) < interval {
+		return f.cert, f.pool
+	}
+	f.lastCheck = time.Now()
+
+	modTimes, err := f.stat()
+	if err != nil {
+		log.Printf("TLS reload error: %v\n", err)
+		return f.cert, f.pool
+	}
+	for i := range modTimes {
+		if !modTimes[i].Equal(f.modTimes[i]) {
+			if err := f.load(modTimes); err != nil {
+				log.Printf("TLS reload error: %v\n", err)
+			} else {
+				log.Printf("TLS certificates reloaded\n")
+			}
+			break
+		}
+	}
+	return f.cert, f.pool
+}
+
+// baseConfig 服务端和客户端共用的配置
+func (opt *TLSOption) baseConfig() *tls.Config {
+	minVersion := opt.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: opt.CipherSuites,
+	}
+}
+
+// serverConfig 创建服务端TLS配置，每次握手时使用最新加载的证书
+func (opt *TLSOption) serverConfig() (*tls.Config, error) {
+	if opt.CertFile == "" || opt.KeyFile == "" {
+		return nil, errors.New("TLS server requires CertFile and KeyFile")
+	}
+	if opt.ClientAuth && opt.CAFile == "" {
+		return nil, errors.New("TLS client authentication requires CAFile")
+	}
+
+	files, err := newTLSFiles(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	config := opt.baseConfig()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := files.current()
+		c := opt.baseConfig()
+		c.Certificates = []tls.Certificate{*cert}
+		if opt.ClientAuth {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+			c.ClientCAs = pool
+		}
+		return c, nil
+	}
+	return config, nil
+}
+
+// clientConfig 创建连接到addr的客户端TLS配置
+func (opt *TLSOption) clientConfig(addr string) (*tls.Config, error) {
+	files, err := newTLSFiles(opt)
+	if err != nil {
+		return nil, err
+	}
+	_, pool := files.current()
+
+	config := opt.baseConfig()
+	config.RootCAs = pool
+	config.InsecureSkipVerify = opt.InsecureSkipVerify
+	config.ServerName = opt.ServerName
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	if opt.CertFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := files.current()
+			return cert, nil
+		}
+	}
+	return config, nil
+}
+
+// handshake 在超时时间内完成服务端握手
+func handshake(conn *tls.Conn) (*tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	return &state, nil
+}
@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TunnelProtocol 以Upgrade方式建立隧道时使用的协议名
+const TunnelProtocol = "rpc"
+
+// tunnelHandshakeTimeout 建立隧道（包括经过代理的CONNECT）的最长时间
+const tunnelHandshakeTimeout = 10 * time.Second
+
+// HTTPTunnelTransport 实现经过HTTP隧道的传输层，类似net/rpc的HandleHTTP/DialHTTP
+// 客户端向RPC路径发送CONNECT请求，服务端应答后在该连接上直接收发与TCP传输相同的二进制帧
+// 服务端与HTTPTransport共用同一个HTTP服务器，也接受 "Upgrade: rpc" 形式的升级请求（便于经过反向代理）
+// 连接是持久的，支持多路复用的调用和服务端推送
+type HTTPTunnelTransport struct {
+	HTTPTransport
+}
+
+// isTunnelRequest 判断HTTP请求是否为建立隧道的CONNECT或升级请求
+func isTunnelRequest(r *http.Request) bool {
+	return r.Method == http.MethodConnect ||
+		(headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", TunnelProtocol))
+}
+
+// upgradeTunnel 接管HTTP连接并应答隧道请求，返回在该连接上收发二进制帧的连接
+func upgradeTunnel(w http.ResponseWriter, r *http.Request, maxFrameSize int) (*TCPConn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunnel not supported", http.StatusInternalServerError)
+		return nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 200 Connected to RPC\r\n\r\n"
+	if r.Method != http.MethodConnect {
+		resp = "HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: " + TunnelProtocol + "\r\n" +
+			"Connection: Upgrade\r\n\r\n"
+	}
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// 客户端可能在收到应答前就发送了帧，它们已在rw.Reader的缓冲区中
+	return &TCPConn{conn: conn, reader: rw.Reader, maxFrameSize: maxFrameSize}, nil
+}
+
+// Dial 经HTTP隧道连接到服务器，addr可以是 http://host:port/path、https://host:port/path 或 host:port（路径为Option.HTTPPath）
+// 配置了TLS时，host:port形式的地址使用https；配置了Proxy时先通过代理的CONNECT连接到服务器
+func (t *HTTPTunnelTransport) Dial(addr string) (Conn, error) {
+	u, err := parseTunnelAddr(addr, t.opt.TLS != nil, t.opt.httpPath())
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if t.opt.Proxy != "" {
+		conn, err = dialProxy(t.opt.Proxy, u.Host)
+	} else {
+		conn, err = net.DialTimeout("tcp", u.Host, tunnelHandshakeTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(tunnelHandshakeTimeout))
+
+	if u.Scheme == "https" {
+		tlsOpt := t.opt.TLS
+		if tlsOpt == nil {
+			tlsOpt = &TLSOption{}
+		}
+		config, err := tlsOpt.clientConfig(u.Host)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	reader, err := httpConnect(conn, u.Path, u.Host, "")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &TCPConn{conn: conn, reader: reader, maxFrameSize: t.opt.maxFrameSize()}, nil
+}
+
+// dialProxy 通过HTTP代理的CONNECT连接到target，代理地址中的用户名和密码用于Basic认证
+func dialProxy(proxy, target string) (net.Conn, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	p, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy address: %v", err)
+	}
+	if p.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", p.Scheme)
+	}
+	host := p.Host
+	if p.Port() == "" {
+		host = net.JoinHostPort(p.Hostname(), "80")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, tunnelHandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(tunnelHandshakeTimeout))
+
+	var auth string
+	if p.User != nil {
+		password, _ := p.User.Password()
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(p.User.Username()+":"+password))
+	}
+	reader, err := httpConnect(conn, target, target, auth)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy: %v", err)
+	}
+	// 代理在隧道建立后只转发服务器的数据，而服务器在收到请求前不会发送数据
+	if reader.Buffered() > 0 {
+		conn.Close()
+		return nil, errors.New("proxy: unexpected data after CONNECT response")
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnect 发送CONNECT请求并等待200应答，返回读取应答后的缓冲读取器
+func httpConnect(conn net.Conn, target, host, proxyAuth string) (*bufio.Reader, error) {
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + host + "\r\n"
+	if proxyAuth != "" {
+		req += "Proxy-Authorization: " + proxyAuth + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: unexpected status %s", target, resp.Status)
+	}
+	return reader, nil
+}
+
+// parseTunnelAddr 解析隧道地址，地址中没有路径时使用path
+func parseTunnelAddr(addr string, secure bool, path string) (*url.URL, error) {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		addr = scheme + "://" + addr
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = path
+	}
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			u.Host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			u.Host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	return u, nil
+}
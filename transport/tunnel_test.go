@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"rpc/protocol"
+)
+
+// listenTunnel 在本地空闲端口上监听HTTP，返回传输层和监听地址
+func listenTunnel(t *testing.T) (Transport, string) {
+	t.Helper()
+	tr := NewTransportWithOption(HTTPTunnel, nil)
+	if err := tr.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr, tr.(*HTTPTunnelTransport).listener.Addr().String()
+}
+
+// echoOnce 接受一个连接，把读到的请求原样作为响应写回
+func echoOnce(t *testing.T, tr Transport) {
+	go func() {
+		conn, err := tr.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			req, err := conn.Read()
+			if err != nil {
+				return
+			}
+			conn.Write(respond(req, "echo"))
+		}
+	}()
+}
+
+func TestTunnelRoundTrip(t *testing.T) {
+	tr, addr := listenTunnel(t)
+	echoOnce(t, tr)
+
+	cli, err := NewTransportWithOption(HTTPTunnel, nil).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// 持久连接上可以连续发送多个请求
+	for seq := uint64(1); seq <= 3; seq++ {
+		if err := cli.Write(udpFrame(protocol.Request, seq, "ping")); err != nil {
+			t.Fatal(err)
+		}
+		data, err := cli.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header, _ := protocol.DecodeHeader(data); header.Seq != seq || header.MessageType != protocol.Response {
+			t.Fatalf("response header = %+v, want response %d", header, seq)
+		}
+	}
+}
+
+func TestTunnelUpgradeWithBufferedFrame(t *testing.T) {
+	tr, addr := listenTunnel(t)
+	echoOnce(t, tr)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 升级请求之后紧接着发送第一帧，不等待应答
+	frame := udpFrame(protocol.Request, 9, "early")
+	msg := []byte("GET " + DefaultHTTPPath + " HTTP/1.1\r\nHost: " + addr + "\r\nConnection: Upgrade\r\nUpgrade: " + TunnelProtocol + "\r\n\r\n")
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(frame)))
+	conn.Write(append(msg, frame...))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %s, want 101", resp.Status)
+	}
+
+	c := &TCPConn{conn: conn, reader: reader, maxFrameSize: DefaultMaxFrameSize}
+	data, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header, _ := protocol.DecodeHeader(data); header.Seq != 9 {
+		t.Errorf("response seq = %d, want 9", header.Seq)
+	}
+}
+
+func TestTunnelThroughProxy(t *testing.T) {
+	tr, addr := listenTunnel(t)
+	echoOnce(t, tr)
+
+	// 只接受带Basic认证的CONNECT请求的最小代理
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	authorized := make(chan bool, 1)
+	go func() {
+		conn, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != want {
+			authorized <- false
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return
+		}
+		authorized <- true
+
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			return
+		}
+		defer target.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(target, reader)
+		io.Copy(conn, target)
+	}()
+
+	cli, err := NewTransportWithOption(HTTPTunnel, &Option{Proxy: "http://user:secret@" + proxy.Addr().String()}).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if !<-authorized {
+		t.Fatal("proxy did not receive credentials")
+	}
+
+	cli.Write(udpFrame(protocol.Request, 1, "via proxy"))
+	if _, err := cli.Read(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,25 +1,74 @@
 package transport
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rpc/protocol"
+)
+
+// MaxDatagramFrameSize 单个UDP数据报能承载的最大帧（扣除4字节长度前缀），不支持分片
+const MaxDatagramFrameSize = 65507 - 4
+
+// UDP重传和去重的默认参数
+const (
+	DefaultRetransmitInterval = 500 * time.Millisecond // 请求未收到响应时的重传间隔
+	DefaultMaxRetransmits     = 5                      // 单个请求的最大重传次数
+	DefaultMaxUDPConns        = 1024                   // 服务端同时存在的伪连接数上限
+	udpResultCacheSize        = 1024                   // 服务端缓存的最近响应数量，用于应答重传的请求
+	udpInboxSize              = 64                     // 服务端伪连接的接收队列长度
+	udpShutdownPollInterval   = 50 * time.Millisecond  // 优雅关闭时检查伪连接是否全部关闭的间隔
 )
 
 // UDPTransport 实现基于UDP的传输层
+// 服务端只有一个套接字，按远程地址把数据报分发到各个伪连接
 type UDPTransport struct {
-	conn *net.UDPConn
-	addr *net.UDPAddr
+	conn    *net.UDPConn
+	addr    *net.UDPAddr
+	opt     *Option
+	mu      sync.Mutex
+	conns   map[string]*UDPConn // 远程地址 -> 伪连接
+	accepts chan *UDPConn       // 新出现的远程地址
+	closed  chan struct{}
+	once    sync.Once
+
+	draining atomic.Bool // 优雅关闭中，丢弃来自新远程地址的数据报（由客户端重传到继承套接字的新进程）
 }
 
 // UDPConn 表示一个UDP连接(伪连接，含有远程地址连接)
+// 每个数据报承载一个帧，请求和响应通过消息头中的序号对应
 type UDPConn struct {
-	conn  *net.UDPConn
-	raddr *net.UDPAddr
+	conn         *net.UDPConn
+	raddr        *net.UDPAddr
+	transport    *UDPTransport // 服务端伪连接所属的传输层，客户端连接为nil
+	maxFrameSize int
+	inbox        chan []byte   // 服务端伪连接收到的数据报
+	done         chan struct{} // 连接关闭信号
+	once         sync.Once
+
+	mu       sync.Mutex
+	inflight map[uint64]*udpInflight // 客户端：等待响应、需要重传的请求
+	results  map[uint64][]byte       // 服务端：已收到的请求序号 -> 响应（处理中为nil）
+	order    []uint64                // 服务端：results的插入顺序，用于淘汰
+}
+
+// udpInflight 等待响应的请求
+type udpInflight struct {
+	data     []byte    // 已编码的数据报
+	sentAt   time.Time // 最近一次发送时间
+	attempts int       // 已重传次数
 }
 
-// ListenUDP UDP监听
-func (t *UDPTransport) ListenUDP(addr string) error {
+// Listen 在指定地址上监听UDP数据报
+func (t *UDPTransport) Listen(addr string) error {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return err
@@ -28,31 +77,87 @@ func (t *UDPTransport) ListenUDP(addr string) error {
 	if err != nil {
 		return err
 	}
+	return t.ListenOnConn(conn)
+}
+
+// ListenOnConn 在已有的UDP套接字上接收数据报（如从父进程继承的套接字）
+func (t *UDPTransport) ListenOnConn(conn *net.UDPConn) error {
 	t.conn = conn
-	t.addr = udpAddr
+	t.addr, _ = conn.LocalAddr().(*net.UDPAddr)
+	t.conns = make(map[string]*UDPConn)
+	t.accepts = make(chan *UDPConn, udpInboxSize)
+	t.closed = make(chan struct{})
+
+	go t.serve()
 	return nil
 }
 
-// Accept 接受一个UDP“连接”（其实是收到数据时的远程地址）
+// serve 读取数据报并按远程地址分发
+func (t *UDPTransport) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, raddr, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Close()
+			return
+		}
+
+		key := raddr.String()
+		t.mu.Lock()
+		c, ok := t.conns[key]
+		if !ok && (t.draining.Load() || len(t.conns) >= t.opt.maxUDPConns()) {
+			// 伪连接已满时丢弃来自新远程地址的数据报，避免伪造源地址耗尽内存
+			t.mu.Unlock()
+			continue
+		}
+		if !ok {
+			c = &UDPConn{
+				conn:         t.conn,
+				raddr:        raddr,
+				transport:    t,
+				maxFrameSize: t.opt.maxFrameSize(),
+				inbox:        make(chan []byte, udpInboxSize),
+				done:         make(chan struct{}),
+				results:      make(map[uint64][]byte),
+			}
+			t.conns[key] = c
+		}
+		t.mu.Unlock()
+
+		if !ok {
+			// 等待接受的队列满时丢弃数据报并移除伪连接，不阻塞其他远程地址的数据报
+			select {
+			case t.accepts <- c:
+			default:
+				c.Close()
+				continue
+			}
+		}
+
+		// 队列满时丢弃数据报，由客户端重传
+		datagram := append([]byte(nil), buf[:n]...)
+		select {
+		case c.inbox <- datagram:
+		default:
+		}
+	}
+}
+
+// Accept 接受一个UDP“连接”（来自新远程地址的第一个数据报）
 func (t *UDPTransport) Accept() (Conn, error) {
 	if t.conn == nil {
 		return nil, errors.New("UDPTransport not listening")
 	}
 
-	// 先读取数据长度前缀
-	buf := make([]byte, 4096)
-	n, addr, err := t.conn.ReadFromUDP(buf)
-	if err != nil {
-		return nil, err
+	select {
+	case c := <-t.accepts:
+		return c, nil
+	case <-t.closed:
+		return nil, errors.New("UDPTransport closed")
 	}
-	c := &UDPConn{
-		conn:  t.conn,
-		raddr: addr,
-	}
-	return c, nil
 }
 
-// DialUDP 建立UDP连接
+// Dial 建立UDP连接
 func (t *UDPTransport) Dial(addr string) (Conn, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
@@ -62,46 +167,241 @@ func (t *UDPTransport) Dial(addr string) (Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &UDPConn{conn: conn, raddr: udpAddr}, nil
+
+	c := &UDPConn{
+		conn:         conn,
+		raddr:        udpAddr,
+		maxFrameSize: t.opt.maxFrameSize(),
+		done:         make(chan struct{}),
+		inflight:     make(map[uint64]*udpInflight),
+	}
+	go c.retransmit(t.opt.retransmitInterval(), t.opt.maxRetransmits())
+	return c, nil
+}
+
+// File 返回UDP套接字的副本
+func (t *UDPTransport) File() (*os.File, error) {
+	if t.conn == nil {
+		return nil, errors.New("transport not listening")
+	}
+	return t.conn.File()
+}
+
+// Shutdown 不再接受来自新远程地址的数据报，等待已有的伪连接全部关闭后关闭套接字
+func (t *UDPTransport) Shutdown(ctx context.Context) error {
+	if t.conn == nil {
+		return nil
+	}
+	t.draining.Store(true)
+
+	ticker := time.NewTicker(udpShutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		t.mu.Lock()
+		n := len(t.conns)
+		t.mu.Unlock()
+		if n == 0 {
+			return t.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Close()
+			return ctx.Err()
+		case <-t.closed:
+			return nil
+		case <-ticker.C:
+		}
+	}
 }
 
 // Close UDP监听关闭
 func (t *UDPTransport) Close() error {
-	if t.conn != nil {
-		return t.conn.Close()
+	if t.conn == nil {
+		return nil
 	}
-	return nil
+
+	var err error
+	t.once.Do(func() {
+		close(t.closed)
+		err = t.conn.Close()
+	})
+	return err
 }
 
-// Write 向指定地址写入数据，带有四字节前缀
+// Write 向远程地址写入一个数据报，带有四字节长度前缀
 func (c *UDPConn) Write(data []byte) error {
-	sizeBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(sizeBuf, uint32(len(data)))
+	if len(data) > MaxDatagramFrameSize {
+		return fmt.Errorf("frame too large for UDP: %d bytes exceeds limit of %d bytes", len(data), MaxDatagramFrameSize)
+	}
 
-	msg := append(sizeBuf, data...)
+	msg := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(msg[:4], uint32(len(data)))
+	copy(msg[4:], data)
+
+	header, _ := protocol.DecodeHeader(data)
+	if c.transport == nil {
+		// 客户端：记录请求，未收到响应时重传
+		if header != nil && isRequest(header.MessageType) {
+			c.mu.Lock()
+			c.inflight[header.Seq] = &udpInflight{data: msg, sentAt: time.Now()}
+			c.mu.Unlock()
+		}
+		_, err := c.conn.Write(msg)
+		return err
+	}
+
+	// 服务端：缓存响应，用于应答重传的请求
+	if header != nil && isResponse(header.MessageType) {
+		c.mu.Lock()
+		if _, ok := c.results[header.Seq]; ok {
+			c.results[header.Seq] = msg
+		}
+		c.mu.Unlock()
+	}
 	_, err := c.conn.WriteToUDP(msg, c.raddr)
 	return err
 }
 
-// Read 读取UDP数据（含长度前缀）
+// RemoteAddr 返回对端地址
+func (c *UDPConn) RemoteAddr() net.Addr {
+	if c.raddr != nil {
+		return c.raddr
+	}
+	return c.conn.RemoteAddr()
+}
+
+// Read 读取一个数据报（含长度前缀）
 func (c *UDPConn) Read() ([]byte, error) {
-	buf := make([]byte, 4096)
-	n, _, err := c.conn.ReadFromUDP(buf)
+	for {
+		datagram, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(datagram) < 4 {
+			continue
+		}
+		size := binary.BigEndian.Uint32(datagram[:4])
+		if uint64(size) > uint64(len(datagram)-4) {
+			continue
+		}
+		data := datagram[4 : 4+size]
+
+		if len(data) > c.maxFrameSize {
+			head := data[:min(len(data), protocol.HeaderSize)]
+			return nil, &FrameTooLargeError{Size: uint64(size), Limit: c.maxFrameSize, Head: head}
+		}
+
+		if c.accept(data) {
+			return data, nil
+		}
+	}
+}
+
+// next 获取下一个数据报
+func (c *UDPConn) next() ([]byte, error) {
+	if c.transport == nil {
+		buf := make([]byte, 65536)
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	select {
+	case datagram := <-c.inbox:
+		return datagram, nil
+	case <-c.done:
+		return nil, io.EOF
+	case <-c.transport.closed:
+		return nil, io.EOF
+	}
+}
+
+// accept 根据序号处理重传：客户端收到响应后停止重传，
+// 服务端对重复的请求直接重发缓存的响应（处理中则忽略），返回数据是否需要交给上层
+func (c *UDPConn) accept(data []byte) bool {
+	header, err := protocol.DecodeHeader(data)
 	if err != nil {
-		return nil, err
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.transport == nil {
+		if isResponse(header.MessageType) {
+			delete(c.inflight, header.Seq)
+		}
+		return true
 	}
 
-	if n < 4 {
-		return nil, errors.New("invalid data length")
+	if !isRequest(header.MessageType) {
+		return true
 	}
-	size := binary.BigEndian.Uint32(buf[:4])
-	if int(size) > n-4 {
-		return nil, errors.New("incomplete data")
+
+	if resp, ok := c.results[header.Seq]; ok {
+		if resp != nil {
+			c.conn.WriteToUDP(resp, c.raddr)
+		}
+		return false
+	}
+
+	c.results[header.Seq] = nil
+	c.order = append(c.order, header.Seq)
+	if len(c.order) > udpResultCacheSize {
+		delete(c.results, c.order[0])
+		c.order = c.order[1:]
 	}
-	return buf[4 : 4+size], nil
+	return true
 }
 
-// Close 关闭udp连接
+// retransmit 定期重传未收到响应的请求，超过最大重传次数后放弃，由调用方超时处理
+func (c *UDPConn) retransmit(interval time.Duration, maxRetransmits int) {
+	// 以半个重传间隔检查，使实际重传时间接近设定的间隔
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			for seq, req := range c.inflight {
+				if now.Sub(req.sentAt) < interval {
+					continue
+				}
+				if req.attempts >= maxRetransmits {
+					delete(c.inflight, seq)
+					continue
+				}
+				req.attempts++
+				req.sentAt = now
+				c.conn.Write(req.data)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Close 关闭udp连接，服务端伪连接只从传输层移除，不关闭共享的套接字
 func (c *UDPConn) Close() error {
-	return c.Close()
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		if c.transport == nil {
+			err = c.conn.Close()
+			return
+		}
+
+		c.transport.mu.Lock()
+		if c.transport.conns[c.raddr.String()] == c {
+			delete(c.transport.conns, c.raddr.String())
+		}
+		c.transport.mu.Unlock()
+	})
+	return err
 }
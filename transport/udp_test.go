@@ -0,0 +1,264 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"rpc/protocol"
+)
+
+// listenUDP 在本地空闲端口上监听UDP，返回传输层和监听地址
+func listenUDP(t *testing.T, opt *Option) (*UDPTransport, string) {
+	t.Helper()
+	tr := &UDPTransport{opt: opt}
+	if err := tr.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr, tr.conn.LocalAddr().String()
+}
+
+func udpFrame(messageType protocol.MessageType, seq uint64, payload string) []byte {
+	return protocol.EncodeFrame(&protocol.Frame{
+		Header: &protocol.Header{
+			MagicNumber: protocol.MagicNumber,
+			Version:     protocol.Version,
+			MessageType: messageType,
+			Seq:         seq,
+		},
+		ServiceName: "Echo",
+		MethodName:  "Echo",
+		Payload:     []byte(payload),
+	})
+}
+
+// acceptUDP 在超时前接受一个伪连接
+func acceptUDP(t *testing.T, tr *UDPTransport) Conn {
+	t.Helper()
+	accepted := make(chan Conn, 1)
+	go func() {
+		if c, err := tr.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	select {
+	case c := <-accepted:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a UDP connection")
+		return nil
+	}
+}
+
+func TestUDPRoundTrip(t *testing.T) {
+	srv, addr := listenUDP(t, nil)
+	cli, err := (&UDPTransport{}).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	req := udpFrame(protocol.Request, 1, "ping")
+	if err := cli.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	conn := acceptUDP(t, srv)
+	data, err := conn.Read()
+	if err != nil || !bytes.Equal(data, req) {
+		t.Fatalf("server Read = %q, %v", data, err)
+	}
+
+	resp := udpFrame(protocol.Response, 1, "pong")
+	if err := conn.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+	data, err = cli.Read()
+	if err != nil || !bytes.Equal(data, resp) {
+		t.Fatalf("client Read = %q, %v", data, err)
+	}
+}
+
+func TestUDPDuplicateRequestGetsCachedResponse(t *testing.T) {
+	srv, addr := listenUDP(t, nil)
+	raddr, _ := net.ResolveUDPAddr("udp", addr)
+	raw, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	// 直接发送同一个请求两次，模拟客户端重传
+	req := udpFrame(protocol.Request, 7, "once")
+	datagram := binary.BigEndian.AppendUint32(nil, uint32(len(req)))
+	datagram = append(datagram, req...)
+	raw.Write(datagram)
+
+	conn := acceptUDP(t, srv)
+	if _, err := conn.Read(); err != nil {
+		t.Fatal(err)
+	}
+	resp := udpFrame(protocol.Response, 7, "done")
+	conn.Write(resp)
+
+	// 服务端读取到重复的请求时重发缓存的响应，不再交给上层
+	raw.Write(datagram)
+	delivered := make(chan []byte, 1)
+	go func() {
+		if data, err := conn.Read(); err == nil {
+			delivered <- data
+		}
+	}()
+	buf := make([]byte, 65536)
+	for i := 0; i < 2; i++ {
+		raw.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := raw.Read(buf)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if !bytes.Equal(buf[4:n], resp) {
+			t.Fatalf("response %d = %q, want %q", i, buf[4:n], resp)
+		}
+	}
+
+	select {
+	case data := <-delivered:
+		t.Errorf("duplicate request was delivered again: %q", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUDPMaxConns(t *testing.T) {
+	srv, addr := listenUDP(t, &Option{MaxUDPConns: 2})
+
+	for i := 0; i < 5; i++ {
+		c, err := (&UDPTransport{}).Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Write(udpFrame(protocol.Request, 1, "hello"))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	srv.mu.Lock()
+	n := len(srv.conns)
+	srv.mu.Unlock()
+	if n != 2 {
+		t.Errorf("server has %d pseudo-connections, want 2", n)
+	}
+}
+
+func TestUDPFullAcceptQueueDoesNotBlock(t *testing.T) {
+	srv, addr := listenUDP(t, &Option{MaxUDPConns: 4 * udpInboxSize})
+
+	// 不调用Accept，来自新地址的数据报超过接受队列长度
+	for i := 0; i < udpInboxSize+8; i++ {
+		c, err := (&UDPTransport{}).Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Write(udpFrame(protocol.Request, 1, "flood"))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	srv.mu.Lock()
+	n := len(srv.conns)
+	srv.mu.Unlock()
+	if n > udpInboxSize {
+		t.Errorf("server kept %d pseudo-connections, accept queue holds %d", n, udpInboxSize)
+	}
+
+	// 读取数据报的循环没有阻塞：接受队列腾出空间后新地址仍能连接
+	for i := 0; i < n; i++ {
+		acceptUDP(t, srv)
+	}
+	c, err := (&UDPTransport{}).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(udpFrame(protocol.Request, 1, "late"))
+	data, err := acceptUDP(t, srv).Read()
+	if err != nil || !bytes.Contains(data, []byte("late")) {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+}
+
+func TestUDPShutdownDrains(t *testing.T) {
+	srv, addr := listenUDP(t, nil)
+	cli, err := (&UDPTransport{}).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if err := cli.Write(udpFrame(protocol.Request, 1, "ping")); err != nil {
+		t.Fatal(err)
+	}
+	conn := acceptUDP(t, srv)
+	if _, err := conn.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Shutdown(context.Background()) }()
+
+	// 优雅关闭中丢弃来自新远程地址的数据报
+	other, err := (&UDPTransport{}).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.Write(udpFrame(protocol.Request, 1, "late"))
+	time.Sleep(100 * time.Millisecond)
+	srv.mu.Lock()
+	n := len(srv.conns)
+	srv.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d connections while draining, want 1", n)
+	}
+
+	// 已有的伪连接仍可响应，关闭后Shutdown返回
+	if err := conn.Write(udpFrame(protocol.Response, 1, "pong")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cli.Read(); err != nil || !bytes.Contains(data, []byte("pong")) {
+		t.Fatalf("client Read = %q, %v", data, err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the connection closed", err)
+	default:
+	}
+	conn.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the last connection closed")
+	}
+}
+
+func TestUDPShutdownTimeout(t *testing.T) {
+	srv, addr := listenUDP(t, nil)
+	cli, err := (&UDPTransport{}).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	cli.Write(udpFrame(protocol.Request, 1, "ping"))
+	acceptUDP(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+}
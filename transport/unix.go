@@ -0,0 +1,213 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnixScheme Unix域套接字地址前缀，如 unix:///tmp/rpc.sock，或 unix://@rpc 表示Linux抽象命名空间
+const UnixScheme = "unix://"
+
+// UnixTransport 实现基于Unix域套接字的传输层
+// 与TCP一样是字节流，连接复用TCPConn的帧格式
+type UnixTransport struct {
+	listener *net.UnixListener
+	path     string // 监听的套接字文件路径，抽象命名空间为空
+	opt      *Option
+}
+
+// parseUnixAddr 去掉unix://前缀，返回套接字路径；以@开头的地址为抽象命名空间，仅Linux支持
+func parseUnixAddr(addr string) (string, error) {
+	path := strings.TrimPrefix(addr, UnixScheme)
+	if path == "" {
+		return "", errors.New("empty unix socket path")
+	}
+	if strings.HasPrefix(path, "@") && runtime.GOOS != "linux" && runtime.GOOS != "android" {
+		return "", fmt.Errorf("abstract unix socket not supported on %s", runtime.GOOS)
+	}
+	return path, nil
+}
+
+// Listen 在指定路径上监听Unix域套接字，并按配置设置套接字文件的权限和属主
+func (t *UnixTransport) Listen(addr string) error {
+	path, err := parseUnixAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	abstract := strings.HasPrefix(path, "@")
+	if !abstract {
+		if err := removeStaleSocket(path); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+
+	// 抽象命名空间没有对应的文件，不需要设置权限和清理
+	if !abstract {
+		if err := t.opt.applySocketFile(path); err != nil {
+			listener.Close()
+			return err
+		}
+		t.path = path
+	}
+
+	t.listener = listener
+	return nil
+}
+
+// removeStaleSocket 删除上次进程异常退出遗留的套接字文件，文件仍在被监听时返回错误
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %s already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// applySocketFile 设置套接字文件的权限和属主
+func (opt *Option) applySocketFile(path string) error {
+	if opt == nil {
+		return nil
+	}
+
+	if opt.SocketMode != 0 {
+		if err := os.Chmod(path, opt.SocketMode); err != nil {
+			return err
+		}
+	}
+
+	if opt.SocketOwner == "" && opt.SocketGroup == "" {
+		return nil
+	}
+	uid, gid := -1, -1 // -1 表示不修改
+	if opt.SocketOwner != "" {
+		u, err := lookupID(opt.SocketOwner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("unknown socket owner %s: %v", opt.SocketOwner, err)
+		}
+		uid = u
+	}
+	if opt.SocketGroup != "" {
+		g, err := lookupID(opt.SocketGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("unknown socket group %s: %v", opt.SocketGroup, err)
+		}
+		gid = g
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID 将用户名或组名解析为数字ID，也接受直接给出的数字ID
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// ListenOn 在已有的Unix域套接字监听器上接受连接（如systemd传递的套接字）
+// 套接字文件由提供方管理，不设置权限，关闭时也不删除
+func (t *UnixTransport) ListenOn(listener net.Listener) error {
+	ul, ok := listener.(*net.UnixListener)
+	if !ok {
+		return fmt.Errorf("unix transport requires a *net.UnixListener, got %T", listener)
+	}
+	t.listener = ul
+	t.path = ""
+	return nil
+}
+
+// File 返回监听套接字的副本；继承它的进程就绪前本进程仍负责套接字文件，关闭时照常删除
+func (t *UnixTransport) File() (*os.File, error) {
+	if t.listener == nil {
+		return nil, errors.New("transport not listening")
+	}
+	return t.listener.File()
+}
+
+// Handoff 套接字已被新进程接管，套接字文件交由新进程使用，本进程关闭时不再删除
+func (t *UnixTransport) Handoff() {
+	if t.listener != nil {
+		t.listener.SetUnlinkOnClose(false)
+	}
+	t.path = ""
+}
+
+// Accept 接受一个新的Unix域套接字连接
+func (t *UnixTransport) Accept() (Conn, error) {
+	if t.listener == nil {
+		return nil, errors.New("transport not listening")
+	}
+	conn, err := t.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &TCPConn{conn: conn, maxFrameSize: t.opt.maxFrameSize()}, nil
+}
+
+// Dial 连接到指定路径的Unix域套接字
+func (t *UnixTransport) Dial(addr string) (Conn, error) {
+	path, err := parseUnixAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPConn{conn: conn, maxFrameSize: t.opt.maxFrameSize()}, nil
+}
+
+// Close 关闭监听并删除套接字文件
+func (t *UnixTransport) Close() error {
+	if t.listener == nil {
+		return nil
+	}
+	err := t.listener.Close()
+	if t.path != "" {
+		if rmErr := os.Remove(t.path); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
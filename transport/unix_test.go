@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	addr := UnixScheme + path
+	srv := &UnixTransport{opt: &Option{SocketMode: 0600}}
+	if err := srv.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket mode = %o, want 600", perm)
+	}
+
+	accepted := make(chan Conn, 1)
+	go func() {
+		if c, err := srv.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	cli, err := (&UnixTransport{}).Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	go cli.Write([]byte("request"))
+	if data, err := conn.Read(); err != nil || !bytes.Equal(data, []byte("request")) {
+		t.Fatalf("server Read = %q, %v", data, err)
+	}
+	go conn.Write([]byte("response"))
+	if data, err := cli.Read(); err != nil || !bytes.Equal(data, []byte("response")) {
+		t.Fatalf("client Read = %q, %v", data, err)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file still exists after Close: %v", err)
+	}
+}
+
+func TestUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+
+	// 异常退出的进程遗留的套接字文件
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+	l.Close()
+
+	srv := &UnixTransport{}
+	if err := srv.Listen(UnixScheme + path); err != nil {
+		t.Fatalf("Listen over stale socket: %v", err)
+	}
+	defer srv.Close()
+
+	// 仍在监听的套接字不能被替换
+	if err := (&UnixTransport{}).Listen(UnixScheme + path); err == nil {
+		t.Error("Listen succeeded on a socket that is in use")
+	}
+}
+
+func TestUnixListenRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&UnixTransport{}).Listen(UnixScheme + path); err == nil {
+		t.Fatal("Listen replaced a regular file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep me" {
+		t.Error("regular file was modified")
+	}
+}
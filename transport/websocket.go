@@ -0,0 +1,468 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"rpc/protocol"
+)
+
+// WebSocket协议常量（RFC 6455）
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+
+	wsCloseProtocolError = 1002 // 关闭码：对端违反协议
+)
+
+// WebSocketTransport 实现基于WebSocket的传输层，每帧协议数据作为一条二进制消息发送
+// 服务端与HTTPTransport共用同一个HTTP服务器：RPC路径上的升级请求建立WebSocket连接，普通POST请求仍按HTTP传输处理
+// 连接是持久的，支持多路复用的调用和服务端推送
+type WebSocketTransport struct {
+	HTTPTransport
+}
+
+// WebSocketConn 表示一个WebSocket连接
+type WebSocketConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	client       bool // 客户端发送的帧需要掩码
+	maxFrameSize int
+	tls          *tls.ConnectionState
+	sending      sync.Mutex // 数据帧和自动回复的控制帧可能并发写入
+	once         sync.Once
+}
+
+// isWebSocketUpgrade 判断HTTP请求是否为WebSocket升级请求
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// headerContains 判断以逗号分隔的头部值中是否包含token（不区分大小写）
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAccept 计算Sec-WebSocket-Accept
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// checkOrigin 检查升级请求的Origin，防止其他站点的页面借用浏览器中的凭证建立连接（跨站WebSocket劫持）
+func checkOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	if len(allowed) > 0 {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket 完成服务端握手，接管底层连接
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, opt *Option) (*WebSocketConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("bad websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	var allowed []string
+	if opt != nil {
+		allowed = opt.AllowedOrigins
+	}
+	if !checkOrigin(r, allowed) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("websocket origin not allowed: %s", r.Header.Get("Origin"))
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &WebSocketConn{
+		conn:         conn,
+		reader:       rw.Reader,
+		maxFrameSize: opt.maxFrameSize(),
+		tls:          r.TLS,
+	}, nil
+}
+
+// Dial 连接到WebSocket服务器，addr可以是 ws://host:port/path、wss://host:port/path 或 host:port（路径为Option.HTTPPath）
+// 配置了TLS时，host:port形式的地址使用wss
+func (t *WebSocketTransport) Dial(addr string) (Conn, error) {
+	u, err := parseWebSocketAddr(addr, t.opt.TLS != nil, t.opt.httpPath())
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if u.Scheme == "wss" {
+		tlsOpt := t.opt.TLS
+		if tlsOpt == nil {
+			tlsOpt = &TLSOption{}
+		}
+		config, err := tlsOpt.clientConfig(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: tlsHandshakeTimeout}, "tcp", u.Host, config)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err = net.Dial("tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := clientHandshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.maxFrameSize = t.opt.maxFrameSize()
+	return c, nil
+}
+
+// parseWebSocketAddr 解析WebSocket地址，地址中没有路径时使用path
+func parseWebSocketAddr(addr string, secure bool, path string) (*url.URL, error) {
+	if !strings.HasPrefix(addr, "ws://") && !strings.HasPrefix(addr, "wss://") {
+		scheme := "ws"
+		if secure {
+			scheme = "wss"
+		}
+		addr = scheme + "://" + addr
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = path
+	}
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			u.Host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			u.Host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	return u, nil
+}
+
+// clientHandshake 发送升级请求并校验服务端的应答
+func clientHandshake(conn net.Conn, u *url.URL) (*WebSocketConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.New("websocket handshake error: " + resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return nil, errors.New("websocket handshake error: invalid Sec-WebSocket-Accept")
+	}
+
+	return &WebSocketConn{conn: conn, reader: reader, client: true}, nil
+}
+
+// wsFrameHeader WebSocket帧头
+type wsFrameHeader struct {
+	fin    bool
+	opcode byte
+	masked bool
+	mask   [4]byte
+	length uint64
+}
+
+// readFrameHeader 读取一个帧头
+func (c *WebSocketConn) readFrameHeader() (*wsFrameHeader, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(c.reader, b[:2]); err != nil {
+		return nil, err
+	}
+
+	h := &wsFrameHeader{
+		fin:    b[0]&0x80 != 0,
+		opcode: b[0] & 0x0F,
+		masked: b[1]&0x80 != 0,
+		length: uint64(b[1] & 0x7F),
+	}
+	if b[0]&0x70 != 0 {
+		return nil, c.fail(wsCloseProtocolError, "websocket: unexpected reserved bits")
+	}
+	// 客户端发送的帧必须带掩码，服务端发送的帧不能带掩码（RFC 6455 §5.1），否则关闭连接
+	if h.masked == c.client {
+		return nil, c.fail(wsCloseProtocolError, "websocket: unexpected frame masking")
+	}
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.reader, b[:2]); err != nil {
+			return nil, err
+		}
+		h.length = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.reader, b[:8]); err != nil {
+			return nil, err
+		}
+		h.length = binary.BigEndian.Uint64(b[:8])
+		if h.length > math.MaxInt64 {
+			return nil, errors.New("websocket: invalid frame length")
+		}
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(c.reader, h.mask[:]); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// fail 以关闭码和原因发送close帧，返回对应的错误，调用方随后关闭连接
+func (c *WebSocketConn) fail(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	c.writeFrame(wsClose, append(payload, reason...))
+	return errors.New(reason)
+}
+
+// readPayload 从帧负载开头读取n字节并去掉掩码
+func (c *WebSocketConn) readPayload(h *wsFrameHeader, n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return nil, err
+	}
+	if h.masked {
+		for i := range data {
+			data[i] ^= h.mask[i%4]
+		}
+	}
+	return data, nil
+}
+
+// Read 读取一条完整的二进制消息，自动应答ping和close
+// 超过大小限制的消息被丢弃并返回FrameTooLargeError，连接仍可继续使用
+func (c *WebSocketConn) Read() ([]byte, error) {
+	var (
+		msg      []byte
+		started  bool
+		tooLarge *FrameTooLargeError
+	)
+
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		// 控制帧可以插在分片消息之间
+		if h.opcode >= wsClose {
+			if !h.fin || h.length > 125 {
+				return nil, errors.New("websocket: invalid control frame")
+			}
+			payload, err := c.readPayload(h, int(h.length))
+			if err != nil {
+				return nil, err
+			}
+			switch h.opcode {
+			case wsPing:
+				if err := c.writeFrame(wsPong, payload); err != nil {
+					return nil, err
+				}
+			case wsClose:
+				c.writeFrame(wsClose, payload)
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		switch h.opcode {
+		case wsBinary, wsText:
+			if started {
+				return nil, errors.New("websocket: expected continuation frame")
+			}
+			started = true
+		case wsContinuation:
+			if !started {
+				return nil, errors.New("websocket: unexpected continuation frame")
+			}
+		default:
+			return nil, fmt.Errorf("websocket: unknown opcode %d", h.opcode)
+		}
+
+		if tooLarge == nil && uint64(len(msg))+h.length > uint64(c.maxFrameSize) {
+			head := msg[:min(len(msg), protocol.HeaderSize)]
+			tooLarge = &FrameTooLargeError{Size: uint64(len(msg)), Limit: c.maxFrameSize, Head: head}
+			msg = nil
+		}
+
+		if tooLarge != nil {
+			// 只保留开头的部分数据用于定位请求，其余丢弃
+			need := min(uint64(protocol.HeaderSize-len(tooLarge.Head)), h.length)
+			head, err := c.readPayload(h, int(need))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(io.Discard, c.reader, int64(h.length-need)); err != nil {
+				return nil, err
+			}
+			tooLarge.Head = append(tooLarge.Head, head...)
+			tooLarge.Size += h.length
+		} else {
+			payload, err := c.readPayload(h, int(h.length))
+			if err != nil {
+				return nil, err
+			}
+			msg = append(msg, payload...)
+		}
+
+		if h.fin {
+			if tooLarge != nil {
+				return nil, tooLarge
+			}
+			return msg, nil
+		}
+	}
+}
+
+// writeFrame 写入一个完整的帧，客户端发送的帧使用随机掩码
+func (c *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | opcode
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header[1] |= 0x80
+		header = append(header, mask[:]...)
+
+		masked := make([]byte, length)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// Write 将一帧协议数据作为一条二进制消息发送
+func (c *WebSocketConn) Write(data []byte) error {
+	return c.writeFrame(wsBinary, data)
+}
+
+// ConnectionState 返回wss连接的TLS状态，ws连接返回nil
+func (c *WebSocketConn) ConnectionState() (*tls.ConnectionState, error) {
+	return c.tls, nil
+}
+
+// RemoteAddr 返回对端地址
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close 发送关闭帧并关闭连接
+func (c *WebSocketConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.writeFrame(wsClose, nil)
+		err = c.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,192 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// wsPipe 返回通过内存管道相连的客户端和服务端WebSocket连接（跳过握手）
+// 管道的写入要等对端读取，测试结束时直接关闭底层连接，不发送close帧
+func wsPipe(t *testing.T, maxFrameSize int) (client, server *WebSocketConn, raw net.Conn) {
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	client = &WebSocketConn{conn: a, reader: bufio.NewReader(a), client: true, maxFrameSize: maxFrameSize}
+	server = &WebSocketConn{conn: b, reader: bufio.NewReader(b), maxFrameSize: maxFrameSize}
+	return client, server, a
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	tr := NewTransportWithOption(WebSocket, nil)
+	if err := tr.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	addr := tr.(*WebSocketTransport).listener.Addr().String()
+
+	accepted := make(chan Conn, 1)
+	go func() {
+		if c, err := tr.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	cli, err := NewTransportWithOption(WebSocket, nil).Dial("ws://" + addr + "/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	// 超过125字节的消息使用扩展长度
+	req := bytes.Repeat([]byte("request "), 100)
+	if err := cli.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := conn.Read(); err != nil || !bytes.Equal(data, req) {
+		t.Fatalf("server Read = %d bytes, %v", len(data), err)
+	}
+	if err := conn.Write([]byte("response")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cli.Read(); err != nil || string(data) != "response" {
+		t.Fatalf("client Read = %q, %v", data, err)
+	}
+}
+
+func TestWebSocketFragmentsAndPing(t *testing.T) {
+	client, server, raw := wsPipe(t, DefaultMaxFrameSize)
+
+	// 分片消息之间插入ping，服务端应答pong后继续拼接消息
+	go func() {
+		raw.Write(maskedFrame(wsBinary, false, []byte("hel")))
+		raw.Write(maskedFrame(wsPing, true, []byte("p")))
+		raw.Write(maskedFrame(wsContinuation, true, []byte("lo")))
+	}()
+
+	pong := make(chan []byte, 1)
+	go func() {
+		h, err := client.readFrameHeader()
+		if err != nil || h.opcode != wsPong {
+			pong <- nil
+			return
+		}
+		payload, _ := client.readPayload(h, int(h.length))
+		pong <- payload
+	}()
+
+	if data, err := server.Read(); err != nil || string(data) != "hello" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+	if p := <-pong; string(p) != "p" {
+		t.Errorf("pong payload = %q, want %q", p, "p")
+	}
+}
+
+// maskedFrame 构造一个带掩码的客户端帧
+func maskedFrame(opcode byte, fin bool, payload []byte) []byte {
+	b := []byte{opcode, 0x80 | byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	b = append(b, mask[:]...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+func TestWebSocketRejectsUnmaskedClientFrame(t *testing.T) {
+	client, server, raw := wsPipe(t, DefaultMaxFrameSize)
+
+	go raw.Write([]byte{0x80 | wsBinary, 2, 'h', 'i'})
+
+	closeCode := make(chan uint16, 1)
+	go func() {
+		h, err := client.readFrameHeader()
+		if err != nil || h.opcode != wsClose {
+			closeCode <- 0
+			return
+		}
+		payload, _ := client.readPayload(h, int(h.length))
+		closeCode <- binary.BigEndian.Uint16(payload)
+	}()
+
+	if _, err := server.Read(); err == nil {
+		t.Fatal("server accepted an unmasked frame")
+	}
+	if code := <-closeCode; code != wsCloseProtocolError {
+		t.Errorf("close code = %d, want %d", code, wsCloseProtocolError)
+	}
+}
+
+func TestWebSocketMessageTooLarge(t *testing.T) {
+	client, server, _ := wsPipe(t, 16)
+
+	go func() {
+		client.Write(bytes.Repeat([]byte{'x'}, 32))
+		client.Write([]byte("small"))
+	}()
+
+	var tooLarge *FrameTooLargeError
+	if _, err := server.Read(); !errors.As(err, &tooLarge) || tooLarge.Size != 32 {
+		t.Fatalf("Read = %v, want FrameTooLargeError of 32 bytes", err)
+	}
+	if data, err := server.Read(); err != nil || string(data) != "small" {
+		t.Fatalf("Read after oversized message = %q, %v", data, err)
+	}
+}
+
+func TestWebSocketCloseFrame(t *testing.T) {
+	client, server, _ := wsPipe(t, DefaultMaxFrameSize)
+	go client.Close()
+	if _, err := server.Read(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Read after close frame = %v, want EOF", err)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		allowed []string
+		ok      bool
+	}{
+		{"", nil, true}, // 非浏览器客户端
+		{"http://rpc.example.com", nil, true},
+		{"http://evil.example.com", nil, false},
+		{"https://app.example.com", []string{"https://app.example.com"}, true},
+		{"http://rpc.example.com", []string{"https://app.example.com"}, false},
+		{"http://evil.example.com", []string{"*"}, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://rpc.example.com/rpc", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+
+		if got := checkOrigin(r, tt.allowed); got != tt.ok {
+			t.Errorf("checkOrigin(%q, %v) = %v, want %v", tt.origin, tt.allowed, got, tt.ok)
+		}
+		if !tt.ok {
+			w := httptest.NewRecorder()
+			if _, err := upgradeWebSocket(w, r, &Option{AllowedOrigins: tt.allowed}); err == nil || w.Code != http.StatusForbidden {
+				t.Errorf("upgrade from %q: status %d, err %v, want 403", tt.origin, w.Code, err)
+			}
+		}
+	}
+}